@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"gameAPI/config"
 	"gameAPI/data"
 	"gameAPI/kafka"
+	"io"
 	"log"
 	"net/http"
+	"net/mail"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -26,19 +38,61 @@ type NotFoundCount struct {
 	error404 *prometheus.CounterVec
 }
 
+type adminCount struct {
+	AdminActions *prometheus.CounterVec
+}
+
+type ForbiddenCount struct {
+	Forbidden *prometheus.CounterVec
+}
+
+type expiredCount struct {
+	ExpiredOffers prometheus.Counter
+}
+
 type Link struct {
 	Href string `json:"href"`
 }
 
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+var passwordResetTTL = config.Duration("PASSWORD_RESET_TTL", time.Hour)
+var emailVerificationTTL = config.Duration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+
+const emailVerificationPurpose = "email-verification"
+
+// mailCooldown is how long an address waits between password reset or verification emails
+var mailCooldown = config.Duration("MAIL_COOLDOWN", 5*time.Minute)
+var resetMails = newMailThrottle()
+var verificationMails = newMailThrottle()
+
+// Offers expire after offerTTL unless the requester picks a deadline, which can't be past offerMaxTTL
+var offerTTL = config.Duration("OFFER_TTL", 7*24*time.Hour)
+var offerMaxTTL = config.Duration("OFFER_MAX_TTL", 30*24*time.Hour)
+var offerSweepInterval = config.Duration("OFFER_SWEEP_INTERVAL", time.Minute)
+
 var register = prometheus.NewRegistry()
 var er = Error404(register)
 var gr = getRequests(register)
+var pr = postRequests(register)
+var fc = Error403(register)
+var ac = adminActions(register)
+var xc = offersExpired(register)
 
 func setStartingMetrics() {
 	er.error404.With(prometheus.Labels{"where": "GET offers by id, ID NOT FOUND"}).Add(0)
 	gr.GetRequests.With(prometheus.Labels{"where": "GET offer by id"}).Add(0)
+	gr.GetRequests.With(prometheus.Labels{"where": "GET offer messages"}).Add(0)
+	gr.GetRequests.With(prometheus.Labels{"where": "GET offer history"}).Add(0)
+	gr.GetRequests.With(prometheus.Labels{"where": "GET game provenance"}).Add(0)
+	gr.GetRequests.With(prometheus.Labels{"where": "GET offer reviews"}).Add(0)
+	gr.GetRequests.With(prometheus.Labels{"where": "GET wishlist"}).Add(0)
+	gr.GetRequests.With(prometheus.Labels{"where": "GET wishlist matches"}).Add(0)
 	gr.GetRequests.With(prometheus.Labels{"where": "GET offers user has"}).Add(0)
-	gr.GetRequests.With(prometheus.Labels{"where": "GET Games owner doesnt own"}).Add(0)
+	gr.GetRequests.With(prometheus.Labels{"where": "GET games search"}).Add(0)
 	gr.GetRequests.With(prometheus.Labels{"where": "Get game by id"}).Add(0)
 	er.error404.With(prometheus.Labels{"where": "patch offers by id ID NOT FOUND"}).Add(0)
 	er.error404.With(prometheus.Labels{"where": "update trade status offers by id"}).Add(0)
@@ -47,94 +101,131 @@ func setStartingMetrics() {
 	er.error404.With(prometheus.Labels{"where": "Delete Game, GAME NOT FOUND"}).Add(0)
 	er.error404.With(prometheus.Labels{"where": "userDelete, USER NOT FOUND"}).Add(0)
 	er.error404.With(prometheus.Labels{"where": "userPatch, USER NOT FOUND"}).Add(0)
-	er.error404.With(prometheus.Labels{"where": "Get Game by name NOT FOUND"}).Add(0)
 	gr.GetRequests.With(prometheus.Labels{"where": "Get user by id"}).Add(0)
+	gr.GetRequests.With(prometheus.Labels{"where": "GET admin users"}).Add(0)
+	ac.AdminActions.With(prometheus.Labels{"action": "change role"}).Add(0)
+	ac.AdminActions.With(prometheus.Labels{"action": "cancel offer"}).Add(0)
+	ac.AdminActions.With(prometheus.Labels{"action": "remove game"}).Add(0)
+	ac.AdminActions.With(prometheus.Labels{"action": "resolve dispute"}).Add(0)
+	gr.GetRequests.With(prometheus.Labels{"where": "GET admin disputes"}).Add(0)
+
+}
+
+// server holds the stores the handlers work against. main wires in MySQL or SQLite, anything else
+// implementing the data interfaces (data.MemoryStore for one) can be swapped in.
+type server struct {
+	users  data.UserStore
+	games  data.GameStore
+	trades data.TradeStore
+
+	// notifying tracks the wishlist notifications still running after their request returned
+	notifying sync.WaitGroup
+}
 
+func newServer(store data.Store) *server {
+	return &server{users: store, games: store, trades: store}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrateCommand(os.Args[2:]))
+	}
+
+	secret, err := loadEmailTokenSecret()
+	if err != nil {
+		log.Fatal(err)
+	}
+	emailSecret = secret
+
 	kafka.StartupKafkaProducer()
 	setStartingMetrics()
-	if err := data.ConnectDatabase(); err != nil {
+	store, err := data.ConnectDatabase()
+	if err != nil {
 		panic(err)
 	}
 
-	var one int
-	err := data.Db.QueryRow("SELECT 1").Scan(&one)
-	if err != nil {
+	if err := store.Ping(context.Background()); err != nil {
 		panic(err)
 	}
 
-	fmt.Println("DB test result:", one)
+	if err := migrateOnBoot(context.Background(), store); err != nil {
+		log.Fatalf("applying migrations failed: %v", err)
+	}
+
+	s := newServer(store)
+	go s.sweepExpiredOffers(offerSweepInterval)
+
+	fmt.Println("Listening on port 8080")
+
+	http.ListenAndServe(":8080", s.routes())
+
+}
+
+func (s *server) routes() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "Server is running")
 	})
 
-	mux.HandleFunc("/games", gameHandler)
-	mux.HandleFunc("/games/", gameByIDHandler)
-	mux.HandleFunc("/users", userPost)
-	mux.HandleFunc("/users/", userByIDHandler)
-	mux.HandleFunc("/offers", offersHandler)
-	mux.HandleFunc("/offers/", offerByIDHandler)
+	mux.HandleFunc("/games", s.gameHandler)
+	mux.HandleFunc("/games/", s.gameByIDHandler)
+	mux.HandleFunc("/users", s.userPost)
+	mux.HandleFunc("/users/", s.userByIDHandler)
+	mux.HandleFunc("/users/password-reset", s.passwordResetRequest)
+	mux.HandleFunc("/users/verify", s.verifyEmail)
+	mux.HandleFunc("/users/verify/resend", s.resendVerification)
+	mux.HandleFunc("/users/password-reset/confirm", s.passwordResetConfirm)
+	mux.HandleFunc("/offers", s.offersHandler)
+	mux.HandleFunc("/offers/", s.offerByIDHandler)
+	mux.HandleFunc("/admin/", s.adminHandler)
 
 	mux.Handle("/metrics", promhttp.HandlerFor(register, promhttp.HandlerOpts{
 		Registry: register,
 	}))
-
-	fmt.Println("Listening on port 8080")
-
-	http.ListenAndServe(":8080", mux)
-
+	return mux
 }
 
-func offersHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) offersHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		createOffer(w, r)
+		s.createOffer(w, r)
 	case http.MethodGet:
-		listOffers(w, r)
+		s.listOffers(w, r)
 	default:
 		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
-func listOffers(w http.ResponseWriter, r *http.Request) {
+func (s *server) listOffers(w http.ResponseWriter, r *http.Request) {
 	// 1. Get the TRUSTED ID from the Nginx header
-	userStr := r.Header.Get("X-User-ID")
-	if userStr == "" {
-		writeError(w, http.StatusUnauthorized, "Identity missing")
-		return
-	}
-
-	// Convert to int - this is now our source of truth
-	userID, err := strconv.Atoi(userStr)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid User ID format")
+	who, ok := requireIdentity(w, r)
+	if !ok {
 		return
 	}
+	userID := who.UserID
 
 	// 2. Parse query filters (status and type)
 	kind := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("type")))
 	status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
 
 	var offers []data.TradeOffer
+	var err error
 
-	if status != "" && status != "pending" && status != "accepted" && status != "rejected" && status != "cancelled" {
+	if status != "" && !data.IsValidTradeStatus(status) {
 		writeError(w, http.StatusBadRequest, "Invalid status")
 		return
 	}
 
 	// 3. Fetch data based on the TRUSTED userID
 	if kind == "outgoing" {
-		offers, err = data.GetOutgoingTradeOffers(userID)
+		offers, err = s.trades.GetOutgoingTradeOffers(r.Context(), userID)
 	} else {
 		// Defaults to incoming if type is not "outgoing"
-		offers, err = data.GetIncomingTradeOffers(userID)
+		offers, err = s.trades.GetIncomingTradeOffers(r.Context(), userID)
 	}
 
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeDataError(w, err)
 		return
 	}
 
@@ -149,74 +240,174 @@ func listOffers(w http.ResponseWriter, r *http.Request) {
 		offers = filtered
 	}
 
-	// 5. Build HATEOAS response
+	// 5. Look up reputation so owners can judge who they are trading with
+	parties := make([]int, 0, len(offers)*2)
+	for _, o := range offers {
+		parties = append(parties, o.RequesterID, o.OwnerUserID)
+	}
+	reputations, err := s.trades.GetReputations(r.Context(), parties)
+	if err != nil {
+		writeDataError(w, err)
+		return
+	}
+
+	// 6. Build HATEOAS response
 	resp := make([]any, 0, len(offers))
 	for _, o := range offers {
-		resp = append(resp, tradeHATEOAS(o))
+		offer := tradeHATEOAS(o)
+		offer["requesterReputation"] = reputations[o.RequesterID]
+		offer["ownerReputation"] = reputations[o.OwnerUserID]
+		resp = append(resp, offer)
 	}
 
 	writeJSON(w, http.StatusOK, resp)
 	gr.GetRequests.With(prometheus.Labels{"where": "GET offers user has"}).Inc()
 }
 
-func offerByIDHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) offerByIDHandler(w http.ResponseWriter, r *http.Request) {
 	// 1. Get the TRUSTED ID from the Nginx header
-	userStr := r.Header.Get("X-User-ID")
-	if userStr == "" {
-		writeError(w, http.StatusUnauthorized, "Identity missing")
+	who, ok := requireIdentity(w, r)
+	if !ok {
 		return
 	}
+	userID := who.UserID
 
-	userID, err := strconv.Atoi(userStr)
+	id, sub, err := parseOfferPath(r.URL.Path)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid User ID format")
+		writeError(w, http.StatusBadRequest, "Invalid Offer ID")
 		return
 	}
 
-	id, err := parseOfferID(r.URL.Path)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid Offer ID")
+	switch sub {
+	case "":
+	case "counter":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.counterOffer(w, r, id, userID)
+		return
+	case "shipment":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.shipOffer(w, r, id, userID)
+		return
+	case "receipt":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.receiveOffer(w, r, id, userID)
+		return
+	case "reviews":
+		switch r.Method {
+		case http.MethodGet:
+			s.listOfferReviews(w, r, id, userID)
+		case http.MethodPost:
+			s.postOfferReview(w, r, id, userID)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	case "disputes":
+		switch r.Method {
+		case http.MethodGet:
+			s.listOfferDisputes(w, r, id, userID)
+		case http.MethodPost:
+			s.openOfferDispute(w, r, id, userID)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	case "history":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.getOfferHistory(w, r, id, userID)
+		return
+	case "messages":
+		switch r.Method {
+		case http.MethodGet:
+			s.listOfferMessages(w, r, id, userID)
+		case http.MethodPost:
+			s.postOfferMessage(w, r, id, userID)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	default:
+		writeError(w, http.StatusNotFound, "Unknown offer route")
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
 		// Pass the userID to verify the person is part of this trade
-		getOfferByID(w, r, id, userID)
+		s.getOfferByID(w, r, id, userID)
 	case http.MethodPatch:
 		// Pass the userID to verify only the recipient can accept/reject
-		patchOffer(w, r, id, userID)
+		s.patchOffer(w, r, id, userID)
 	default:
 		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
-func getOfferByID(w http.ResponseWriter, r *http.Request, id int, userID int) {
+// offerForParty fetches an offer and makes sure the caller is one of its two parties.
+func (s *server) offerForParty(ctx context.Context, w http.ResponseWriter, id int, userID int, where string) (data.TradeOffer, bool) {
 	// 1. Fetch the offer from the database
-	offer, err := data.GetTradeOfferByID(id)
+	offer, err := s.trades.GetTradeOfferByID(ctx, id)
 	if err != nil {
-		if err.Error() == "trade offer not found in database" {
-			writeError(w, http.StatusNotFound, err.Error())
-			er.error404.With(prometheus.Labels{"where": "GET offers by id, ID NOT FOUND"}).Inc()
-			return
+		if errors.Is(err, data.ErrNotFound) {
+			er.error404.With(prometheus.Labels{"where": where}).Inc()
 		}
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
+		writeDataError(w, err)
+		return data.TradeOffer{}, false
 	}
 
 	// 2. SECURE AUTHORIZATION CHECK
 	// Only allow the person who made the offer or the person who received it to see it.
 	if offer.RequesterID != userID && offer.OwnerUserID != userID {
 		writeError(w, http.StatusForbidden, "You are not authorized to view this trade")
+		return data.TradeOffer{}, false
+	}
+	return offer, true
+}
+
+func (s *server) getOfferByID(w http.ResponseWriter, r *http.Request, id int, userID int) {
+	offer, ok := s.offerForParty(r.Context(), w, id, userID, "GET offers by id, ID NOT FOUND")
+	if !ok {
 		return
 	}
 
-	// 3. Return the data if the check passes
-	writeJSON(w, http.StatusOK, tradeHATEOAS(offer))
+	// 3. Attach the negotiation thread the offer belongs to
+	thread, err := s.trades.GetTradeThread(r.Context(), id)
+	if err != nil {
+		writeDataError(w, err)
+		return
+	}
+	history := make([]any, 0, len(thread))
+	for _, o := range thread {
+		history = append(history, tradeHATEOAS(o))
+	}
+
+	// 4. Return the data if the check passes
+	resp := tradeHATEOAS(offer)
+	resp["thread"] = history
+	writeJSON(w, http.StatusOK, resp)
 	gr.GetRequests.With(prometheus.Labels{"where": "GET offer by id"}).Inc()
 }
 
-func patchOffer(w http.ResponseWriter, r *http.Request, id int, userID int) {
+// offerStatusTitles are the words the offer mails use for the statuses a PATCH can end in.
+var offerStatusTitles = map[string]string{
+	"accepted":  "Accepted",
+	"rejected":  "Rejected",
+	"cancelled": "Cancelled",
+}
+
+func (s *server) patchOffer(w http.ResponseWriter, r *http.Request, id int, userID int) {
 	var patch data.TradeOfferPatch
 	if err := readJSON(r, &patch); err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
@@ -229,19 +420,17 @@ func patchOffer(w http.ResponseWriter, r *http.Request, id int, userID int) {
 	}
 
 	newStatus := strings.ToLower(strings.TrimSpace(*patch.CurrentStatus))
-	if newStatus != "accepted" && newStatus != "rejected" && newStatus != "cancelled" {
+	if _, ok := offerStatusTitles[newStatus]; !ok {
 		writeError(w, http.StatusBadRequest, "Invalid status")
 		return
 	}
 
-	offer, err := data.GetTradeOfferByID(id)
+	offer, err := s.trades.GetTradeOfferByID(r.Context(), id)
 	if err != nil {
-		if err.Error() == "trade offer not found in database" {
-			writeError(w, http.StatusNotFound, err.Error())
+		if errors.Is(err, data.ErrNotFound) {
 			er.error404.With(prometheus.Labels{"where": "patch offers by id ID NOT FOUND"}).Inc()
-			return
 		}
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeDataError(w, err)
 		return
 	}
 
@@ -258,27 +447,44 @@ func patchOffer(w http.ResponseWriter, r *http.Request, id int, userID int) {
 		}
 	}
 
+	if offer.CurrentStatus == "expired" || offer.Expired(time.Now()) {
+		writeError(w, http.StatusConflict, "Offer has expired")
+		return
+	}
 	if offer.CurrentStatus != "pending" {
 		writeError(w, http.StatusConflict, "Offer is not pending")
 		return
 	}
 
 	// Fetch emails now so we can use them in both branches below
-	ownerEmail := data.GetEmailWithID(offer.OwnerUserID)
-	requesterEmail := data.GetEmailWithID(offer.RequesterID)
+	ownerEmail := s.users.GetEmailWithID(r.Context(), offer.OwnerUserID)
+	requesterEmail := s.users.GetEmailWithID(r.Context(), offer.RequesterID)
 
 	if newStatus == "accepted" {
-		if err := data.AcceptTradeOffer(id); err != nil {
-			writeError(w, http.StatusConflict, err.Error())
+		voided, err := s.trades.AcceptTradeOffer(r.Context(), id, userID)
+		if err != nil {
+			writeDataError(w, err)
 			return
 		}
 
+		// Let everyone whose offer just became impossible know why
+		for _, v := range voided {
+			if email := s.users.GetEmailWithID(r.Context(), v.RequesterID); email != "" {
+				_ = kafka.PushNotification(kafka.Notification{
+					To:        email,
+					Subject:   "Game Offer Void",
+					Body:      fmt.Sprintf("Your trade offer #%d is void because a game in it was traded in another offer.", v.OfferID),
+					EventType: "offers",
+				})
+			}
+		}
+
 		// Send Notifications for Acceptance
 		if ownerEmail != "" {
 			_ = kafka.PushNotification(kafka.Notification{
 				To:        ownerEmail,
 				Subject:   "Game Offer Accepted",
-				Body:      "You have accepted a trade offer!",
+				Body:      "You have accepted a trade offer! Ship your games and add the tracking number to the offer.",
 				EventType: "offers",
 			})
 		}
@@ -286,7 +492,7 @@ func patchOffer(w http.ResponseWriter, r *http.Request, id int, userID int) {
 			_ = kafka.PushNotification(kafka.Notification{
 				To:        requesterEmail,
 				Subject:   "Game Offer Accepted",
-				Body:      "Your game offer has been accepted!",
+				Body:      "Your game offer has been accepted! Ship your games and add the tracking number to the offer.",
 				EventType: "offers",
 			})
 		}
@@ -295,17 +501,13 @@ func patchOffer(w http.ResponseWriter, r *http.Request, id int, userID int) {
 		return
 	}
 
-	if err := data.UpdateTradeOfferStatus(id, newStatus); err != nil {
-		if err.Error() == "trade offer not found in database" {
-			writeError(w, http.StatusNotFound, err.Error())
-			return
-		}
-		writeError(w, http.StatusInternalServerError, err.Error())
+	if err := s.trades.UpdateTradeOfferStatus(r.Context(), id, newStatus, userID, ""); err != nil {
+		writeDataError(w, err)
 		return
 	}
 
 	// Send Notifications for Rejection/Cancellation
-	subject := "Game Offer " + strings.Title(newStatus)
+	subject := "Game Offer " + offerStatusTitles[newStatus]
 	if ownerEmail != "" {
 		_ = kafka.PushNotification(kafka.Notification{
 			To:        ownerEmail,
@@ -326,409 +528,2323 @@ func patchOffer(w http.ResponseWriter, r *http.Request, id int, userID int) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func createOffer(w http.ResponseWriter, r *http.Request) {
-	var offer data.TradeOfferCreateRequest
+// shipOffer records the caller's tracking number on an accepted offer.
+func (s *server) shipOffer(w http.ResponseWriter, r *http.Request, id int, userID int) {
+	var body data.TradeShipmentRequest
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	// 1. Get the TRUSTED ID from the Nginx header
-	userStr := r.Header.Get("X-User-ID")
-	if userStr == "" {
-		writeError(w, http.StatusUnauthorized, "Identity missing")
+	tracking := strings.TrimSpace(body.TrackingNumber)
+	if tracking == "" {
+		writeError(w, http.StatusBadRequest, "trackingNumber is required")
+		return
+	}
+	if utf8.RuneCountInString(tracking) > data.MaxTrackingLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("trackingNumber can be at most %d characters", data.MaxTrackingLength))
+		return
+	}
+
+	if _, ok := s.offerForParty(r.Context(), w, id, userID, "POST offer shipment, ID NOT FOUND"); !ok {
 		return
 	}
 
-	// Convert to int - this is now our source of truth for who is making the request
-	requesterID, err := strconv.Atoi(userStr)
+	offer, err := s.trades.MarkTradeShipped(r.Context(), id, userID, tracking)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid User ID format")
+		writeDataError(w, err)
 		return
 	}
 
-	if err := readJSON(r, &offer); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid Offer Data"+err.Error())
+	recipient := offer.OwnerUserID
+	if userID == offer.OwnerUserID {
+		recipient = offer.RequesterID
+	}
+	if email := s.users.GetEmailWithID(r.Context(), recipient); email != "" {
+		_ = kafka.PushNotification(kafka.Notification{
+			To:        email,
+			Subject:   "Game Offer Shipped",
+			Body:      fmt.Sprintf("The games for trade offer #%d are on their way. Tracking number: %s", id, tracking),
+			EventType: "offers",
+		})
+	}
+
+	writeJSON(w, http.StatusOK, tradeHATEOAS(offer))
+	pr.PostRequests.With(prometheus.Labels{"where": "Offer Shipment"}).Inc()
+}
+
+// receiveOffer confirms the caller got the other party's games. The second confirmation completes the trade.
+func (s *server) receiveOffer(w http.ResponseWriter, r *http.Request, id int, userID int) {
+	if _, ok := s.offerForParty(r.Context(), w, id, userID, "POST offer receipt, ID NOT FOUND"); !ok {
 		return
 	}
 
-	// 2. Validation (Check only game IDs; we ignore offer.RequesterID from JSON)
-	if offer.GameRequestedID <= 0 || offer.GameOfferedID <= 0 {
-		writeError(w, http.StatusBadRequest, "MISSING GAME IDS")
+	offer, err := s.trades.ConfirmTradeReceipt(r.Context(), id, userID)
+	if err != nil {
+		writeDataError(w, err)
 		return
 	}
-	if offer.GameRequestedID == offer.GameOfferedID {
-		writeError(w, http.StatusBadRequest, "CANT TRADE THE SAME GAME")
+
+	if offer.CurrentStatus == data.TradeStatusCompleted {
+		// The games now belong to someone else, which may be who a wishlist was waiting for
+		gameIDs := append(append([]int{}, offer.GameRequestedIDs...), offer.GameOfferedIDs...)
+		ctx := context.WithoutCancel(r.Context())
+		s.notifying.Go(func() { s.notifyWishlistMatches(ctx, gameIDs...) })
+		for _, party := range []int{offer.RequesterID, offer.OwnerUserID} {
+			if email := s.users.GetEmailWithID(r.Context(), party); email != "" {
+				_ = kafka.PushNotification(kafka.Notification{
+					To:        email,
+					Subject:   "Game Offer Completed",
+					Body:      fmt.Sprintf("Both sides received their games, trade offer #%d is complete.", id),
+					EventType: "offers",
+				})
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, tradeHATEOAS(offer))
+	pr.PostRequests.With(prometheus.Labels{"where": "Offer Receipt"}).Inc()
+}
+
+// postOfferReview lets each party rate the other once the trade has completed.
+func (s *server) postOfferReview(w http.ResponseWriter, r *http.Request, id int, userID int) {
+	var body data.TradeReviewRequest
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	comment := strings.TrimSpace(body.Comment)
+	if body.Rating < data.MinReviewRating || body.Rating > data.MaxReviewRating {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("rating must be between %d and %d", data.MinReviewRating, data.MaxReviewRating))
+		return
+	}
+	if utf8.RuneCountInString(comment) > data.MaxReviewCommentLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("comment can be at most %d characters", data.MaxReviewCommentLength))
+		return
+	}
+
+	if _, ok := s.offerForParty(r.Context(), w, id, userID, "POST offer review, ID NOT FOUND"); !ok {
 		return
 	}
 
-	// 3. Fetch requested game to find the target owner
-	requestedGame, err := data.GetOwnedGameBYID(offer.GameRequestedID)
+	review, err := s.trades.CreateTradeReview(r.Context(), id, userID, body.Rating, comment)
 	if err != nil {
-		if err.Error() == "game not found in database" {
-			writeError(w, http.StatusNotFound, "Game not found in database")
-			er.error404.With(prometheus.Labels{"where": "GetOwnedGameByID GAMErequest NOT FOUND"}).Inc()
-			return
-		}
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeDataError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, reviewHATEOAS(review))
+	pr.PostRequests.With(prometheus.Labels{"where": "Offer Review"}).Inc()
+}
+
+func (s *server) listOfferReviews(w http.ResponseWriter, r *http.Request, id int, userID int) {
+	if _, ok := s.offerForParty(r.Context(), w, id, userID, "GET offer reviews, ID NOT FOUND"); !ok {
 		return
 	}
 
-	// 4. Fetch offered game to verify ownership
-	offeredGame, err := data.GetOwnedGameBYID(offer.GameOfferedID)
+	reviews, err := s.trades.GetTradeReviews(r.Context(), id)
 	if err != nil {
-		if err.Error() == "game not found in database" {
-			writeError(w, http.StatusNotFound, "Game not found in database")
-			er.error404.With(prometheus.Labels{"where": "GetOwnedGameByID GAMEoffered NOT FOUND"}).Inc()
-			return
-		}
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeDataError(w, err)
 		return
 	}
 
-	// 5. SECURE OWNERSHIP CHECK
-	// Verify the game being offered actually belongs to the person logged in
-	if offeredGame.OwnerUserID != requesterID {
-		writeError(w, http.StatusForbidden, "You can only offer your own games")
+	resp := make([]any, 0, len(reviews))
+	for _, rv := range reviews {
+		resp = append(resp, reviewHATEOAS(rv))
+	}
+	writeJSON(w, http.StatusOK, resp)
+	gr.GetRequests.With(prometheus.Labels{"where": "GET offer reviews"}).Inc()
+}
+
+// openOfferDispute lets either party put an accepted trade on hold for a moderator.
+func (s *server) openOfferDispute(w http.ResponseWriter, r *http.Request, id int, userID int) {
+	var body data.TradeDisputeRequest
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Prevent trading with yourself
-	if requestedGame.OwnerUserID == requesterID {
-		writeError(w, http.StatusBadRequest, "This is your own game")
+	reason := strings.TrimSpace(body.Reason)
+	evidence := strings.TrimSpace(body.Evidence)
+	if reason == "" {
+		writeError(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+	if utf8.RuneCountInString(reason) > data.MaxDisputeReasonLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("reason can be at most %d characters", data.MaxDisputeReasonLength))
+		return
+	}
+	if utf8.RuneCountInString(evidence) > data.MaxDisputeEvidenceLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("evidence can be at most %d characters", data.MaxDisputeEvidenceLength))
 		return
 	}
 
-	trade := data.TradeOffer{
-		RequesterID:     requesterID, // Use TRUSTED ID
-		OwnerUserID:     requestedGame.OwnerUserID,
-		GameRequestedID: offer.GameRequestedID,
-		GameOfferedID:   offer.GameOfferedID,
-		CurrentStatus:   "pending",
+	offer, ok := s.offerForParty(r.Context(), w, id, userID, "POST offer dispute, ID NOT FOUND")
+	if !ok {
+		return
 	}
 
-	tradeID, err := data.CreateTradeOffer(trade)
+	dispute, err := s.trades.OpenTradeDispute(r.Context(), id, userID, reason, evidence)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeDataError(w, err)
 		return
 	}
 
-	// Use requesterID for the notification email lookups
-	gameOwnerEmail := data.GetEmailWithID(requestedGame.OwnerUserID)
-	requestMakerEmail := data.GetEmailWithID(requesterID)
+	s.notifyDisputeParties(r.Context(), offer, "Trade Dispute Opened",
+		fmt.Sprintf("A dispute was opened on trade offer #%d and the trade is on hold until a moderator reviews it.\n\nReason: %s", id, reason))
 
-	// ... (rest of notification logic using requesterID) ...
+	writeJSON(w, http.StatusCreated, disputeHATEOAS(dispute))
+	pr.PostRequests.With(prometheus.Labels{"where": "Offer Dispute"}).Inc()
+}
 
-	if gameOwnerEmail != "" {
-		_ = kafka.PushNotification(kafka.Notification{
-			To:        gameOwnerEmail,
-			Subject:   "Game Offer Created",
-			Body:      "An offer has been made for your game",
-			EventType: "offers",
-		})
+func (s *server) listOfferDisputes(w http.ResponseWriter, r *http.Request, id int, userID int) {
+	if _, ok := s.offerForParty(r.Context(), w, id, userID, "GET offer disputes, ID NOT FOUND"); !ok {
+		return
 	}
 
-	if requestMakerEmail != "" {
-		_ = kafka.PushNotification(kafka.Notification{
-			To:        requestMakerEmail,
-			Subject:   "Game Offer Created",
-			Body:      "You have made a game offer",
-			EventType: "offers",
-		})
+	disputes, err := s.trades.GetTradeDisputes(r.Context(), id)
+	if err != nil {
+		writeDataError(w, err)
+		return
 	}
 
-	trade.OfferID = tradeID
-	writeJSON(w, http.StatusCreated, tradeHATEOAS(trade))
-	pc := postRequests(register)
-	pc.PostRequests.With(prometheus.Labels{"where": "Create Offer"}).Inc()
+	resp := make([]any, 0, len(disputes))
+	for _, d := range disputes {
+		resp = append(resp, disputeHATEOAS(d))
+	}
+	writeJSON(w, http.StatusOK, resp)
+	gr.GetRequests.With(prometheus.Labels{"where": "GET offer disputes"}).Inc()
 }
 
-func writeJSON(w http.ResponseWriter, status int, v any) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(v)
+func (s *server) notifyDisputeParties(ctx context.Context, offer data.TradeOffer, subject string, body string) {
+	for _, party := range []int{offer.RequesterID, offer.OwnerUserID} {
+		if email := s.users.GetEmailWithID(ctx, party); email != "" {
+			_ = kafka.PushNotification(kafka.Notification{
+				To:        email,
+				Subject:   subject,
+				Body:      body,
+				EventType: "offers",
+			})
+		}
+	}
 }
 
-func writeError(w http.ResponseWriter, status int, msg string) {
-	writeJSON(w, status, map[string]any{
-		"error":  msg,
-		"status": status,
-	})
-}
+func (s *server) getOfferHistory(w http.ResponseWriter, r *http.Request, id int, userID int) {
+	if _, ok := s.offerForParty(r.Context(), w, id, userID, "GET offer history, ID NOT FOUND"); !ok {
+		return
+	}
 
-func userHATEOAS(userID int, username, email, streetAddress string) map[string]any {
-	return map[string]any{
-		"id":            userID,
-		"username":      username,
-		"email":         email,
-		"streetAddress": streetAddress,
-		"_links": map[string]Link{
-			"self":   {Href: fmt.Sprintf("/users/%d", userID)},
-			"update": {Href: fmt.Sprintf("/users/%d", userID)},
-			"patch":  {Href: fmt.Sprintf("/users/%d", userID)},
-			"delete": {Href: fmt.Sprintf("/users/%d", userID)},
-		},
+	events, err := s.trades.GetTradeHistory(r.Context(), id)
+	if err != nil {
+		writeDataError(w, err)
+		return
 	}
-}
 
-func gameHATEOAS(game data.Game) map[string]any {
-	return map[string]any{
-		"id":          game.ID,
-		"title":       game.Title,
-		"publisher":   game.Publisher,
-		"description": game.Description,
-		"year":        game.Year,
-		"condition":   game.Condition,
+	resp := make([]any, 0, len(events))
+	for _, e := range events {
+		event := map[string]any{
+			"eventId":   e.EventID,
+			"eventType": e.EventType,
+			"toStatus":  e.ToStatus,
+			"createdAt": e.CreatedAt.UTC().Format(time.RFC3339),
+			"actorId":   e.ActorID,
+		}
+		if e.FromStatus != "" {
+			event["fromStatus"] = e.FromStatus
+		}
+		if e.Note != "" {
+			event["note"] = e.Note
+		}
+		if e.ActorID != nil {
+			event["_links"] = map[string]Link{
+				"actor": {Href: fmt.Sprintf("/users/%d", *e.ActorID)},
+			}
+		}
+		resp = append(resp, event)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"offerId": id,
+		"events":  resp,
 		"_links": map[string]Link{
-			"self":   {Href: fmt.Sprintf("/games/%d", game.ID)},
-			"update": {Href: fmt.Sprintf("/games/%d", game.ID)},
-			"patch":  {Href: fmt.Sprintf("/games/%d", game.ID)},
-			"delete": {Href: fmt.Sprintf("/games/%d", game.ID)},
+			"self":  {Href: fmt.Sprintf("/offers/%d/history", id)},
+			"offer": {Href: fmt.Sprintf("/offers/%d", id)},
 		},
+	})
+	gr.GetRequests.With(prometheus.Labels{"where": "GET offer history"}).Inc()
+}
+
+func (s *server) listOfferMessages(w http.ResponseWriter, r *http.Request, id int, userID int) {
+	if _, ok := s.offerForParty(r.Context(), w, id, userID, "GET offer messages, ID NOT FOUND"); !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	limit := defaultPageLimit
+	if raw := q.Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(v, maxPageLimit)
+	}
+
+	after := 0
+	if raw := q.Get("after"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid after")
+			return
+		}
+		after = v
+	}
+
+	messages, err := s.trades.ListTradeMessages(r.Context(), id, after, limit)
+	if err != nil {
+		writeDataError(w, err)
+		return
+	}
+
+	resp := make([]any, 0, len(messages))
+	for _, m := range messages {
+		resp = append(resp, messageHATEOAS(m))
+	}
+
+	links := map[string]Link{
+		"self":  {Href: r.URL.RequestURI()},
+		"offer": {Href: fmt.Sprintf("/offers/%d", id)},
 	}
+	if len(messages) == limit {
+		next := r.URL.Query()
+		next.Set("after", strconv.Itoa(messages[len(messages)-1].MessageID))
+		links["next"] = Link{Href: r.URL.Path + "?" + next.Encode()}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"messages": resp,
+		"count":    len(resp),
+		"_links":   links,
+	})
+	gr.GetRequests.With(prometheus.Labels{"where": "GET offer messages"}).Inc()
+}
+
+func (s *server) postOfferMessage(w http.ResponseWriter, r *http.Request, id int, userID int) {
+	var body data.TradeMessageRequest
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	text := strings.TrimSpace(body.Body)
+	if text == "" {
+		writeError(w, http.StatusBadRequest, "body is required")
+		return
+	}
+	if utf8.RuneCountInString(text) > data.MaxMessageLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("body can be at most %d characters", data.MaxMessageLength))
+		return
+	}
+
+	offer, ok := s.offerForParty(r.Context(), w, id, userID, "POST offer messages, ID NOT FOUND")
+	if !ok {
+		return
+	}
+
+	msg, err := s.trades.CreateTradeMessage(r.Context(), id, userID, text)
+	if err != nil {
+		writeDataError(w, err)
+		return
+	}
+
+	recipient := offer.OwnerUserID
+	if userID == offer.OwnerUserID {
+		recipient = offer.RequesterID
+	}
+	if email := s.users.GetEmailWithID(r.Context(), recipient); email != "" {
+		_ = kafka.PushNotification(kafka.Notification{
+			To:        email,
+			Subject:   "New Offer Message",
+			Body:      fmt.Sprintf("You have a new message on trade offer #%d:\n\n%s", id, text),
+			EventType: "offers",
+		})
+	}
+
+	writeJSON(w, http.StatusCreated, messageHATEOAS(msg))
+	pr.PostRequests.With(prometheus.Labels{"where": "Offer Message"}).Inc()
+}
+
+// counterOffer lets the recipient answer a pending offer with their own terms. The original
+// is marked countered and the counter goes back the other way as a new pending offer.
+func (s *server) counterOffer(w http.ResponseWriter, r *http.Request, id int, userID int) {
+	var body data.TradeOfferCounterRequest
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid Offer Data"+err.Error())
+		return
+	}
+
+	offer, err := s.trades.GetTradeOfferByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			er.error404.With(prometheus.Labels{"where": "counter offer ID NOT FOUND"}).Inc()
+		}
+		writeDataError(w, err)
+		return
+	}
+
+	if userID != offer.OwnerUserID {
+		writeError(w, http.StatusForbidden, "Only the recipient can counter this offer")
+		return
+	}
+	if !s.requireGoodStanding(r.Context(), w, userID) {
+		return
+	}
+	if offer.CurrentStatus == "expired" || offer.Expired(time.Now()) {
+		writeError(w, http.StatusConflict, "Offer has expired")
+		return
+	}
+	if offer.CurrentStatus != "pending" {
+		writeError(w, http.StatusConflict, "Offer is not pending")
+		return
+	}
+
+	// The recipient becomes the requester, so they now ask for the original requester's games
+	ownerID, ok := s.validateOfferGames(r.Context(), w, userID, body.GameRequestedIDs, body.GameOfferedIDs)
+	if !ok {
+		return
+	}
+	if ownerID != offer.RequesterID {
+		writeError(w, http.StatusBadRequest, "A counter-offer can only request games from the original requester")
+		return
+	}
+
+	expiresAt, ok := offerExpiry(w, body.ExpiresAt)
+	if !ok {
+		return
+	}
+
+	counter := data.TradeOffer{
+		RequesterID:      userID,
+		OwnerUserID:      offer.RequesterID,
+		GameRequestedIDs: body.GameRequestedIDs,
+		GameOfferedIDs:   body.GameOfferedIDs,
+		CurrentStatus:    "pending",
+		ExpiresAt:        &expiresAt,
+	}
+
+	counterID, err := s.trades.CounterTradeOffer(r.Context(), id, counter)
+	if err != nil {
+		writeDataError(w, err)
+		return
+	}
+
+	if email := s.users.GetEmailWithID(r.Context(), offer.RequesterID); email != "" {
+		_ = kafka.PushNotification(kafka.Notification{
+			To:        email,
+			Subject:   "Game Offer Countered",
+			Body:      "Your game offer has received a counter-offer.",
+			EventType: "offers",
+		})
+	}
+	if email := s.users.GetEmailWithID(r.Context(), userID); email != "" {
+		_ = kafka.PushNotification(kafka.Notification{
+			To:        email,
+			Subject:   "Game Offer Countered",
+			Body:      "You have sent a counter-offer.",
+			EventType: "offers",
+		})
+	}
+
+	counter.OfferID = counterID
+	counter.ParentOfferID = &id
+	writeJSON(w, http.StatusCreated, tradeHATEOAS(counter))
+	pr.PostRequests.With(prometheus.Labels{"where": "Counter Offer"}).Inc()
+}
+
+// requireGoodStanding stops users who collected MaxStrikes from disputes from making new offers.
+func (s *server) requireGoodStanding(ctx context.Context, w http.ResponseWriter, userID int) bool {
+	user, err := s.users.GetUser(ctx, userID)
+	if err != nil {
+		writeDataError(w, err)
+		return false
+	}
+	if user.Strikes >= data.MaxStrikes {
+		writeError(w, http.StatusForbidden, "You have too many strikes to make new offers")
+		fc.Forbidden.With(prometheus.Labels{"where": "offer, TOO MANY STRIKES"}).Inc()
+		return false
+	}
+	return true
+}
+
+func (s *server) createOffer(w http.ResponseWriter, r *http.Request) {
+	var offer data.TradeOfferCreateRequest
+
+	// 1. Get the TRUSTED ID from the Nginx header
+	who, ok := requireIdentity(w, r)
+	if !ok {
+		return
+	}
+	requesterID := who.UserID
+	if !s.requireGoodStanding(r.Context(), w, requesterID) {
+		return
+	}
+
+	if err := readJSON(r, &offer); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid Offer Data"+err.Error())
+		return
+	}
+
+	// 2. Validation (Check only game IDs; we ignore offer.RequesterID from JSON)
+	requested := offer.GameRequestedIDs
+	if offer.GameRequestedID > 0 {
+		requested = append(requested, offer.GameRequestedID)
+	}
+	offered := offer.GameOfferedIDs
+	if offer.GameOfferedID > 0 {
+		offered = append(offered, offer.GameOfferedID)
+	}
+
+	// 3. Check every game and find the target owner
+	ownerID, ok := s.validateOfferGames(r.Context(), w, requesterID, requested, offered)
+	if !ok {
+		return
+	}
+
+	expiresAt, ok := offerExpiry(w, offer.ExpiresAt)
+	if !ok {
+		return
+	}
+
+	trade := data.TradeOffer{
+		RequesterID:      requesterID, // Use TRUSTED ID
+		OwnerUserID:      ownerID,
+		GameRequestedIDs: requested,
+		GameOfferedIDs:   offered,
+		CurrentStatus:    "pending",
+		ExpiresAt:        &expiresAt,
+	}
+
+	tradeID, err := s.trades.CreateTradeOffer(r.Context(), trade)
+	if err != nil {
+		writeDataError(w, err)
+		return
+	}
+
+	// Use requesterID for the notification email lookups
+	gameOwnerEmail := s.users.GetEmailWithID(r.Context(), ownerID)
+	requestMakerEmail := s.users.GetEmailWithID(r.Context(), requesterID)
+
+	// ... (rest of notification logic using requesterID) ...
+
+	if gameOwnerEmail != "" {
+		_ = kafka.PushNotification(kafka.Notification{
+			To:        gameOwnerEmail,
+			Subject:   "Game Offer Created",
+			Body:      "An offer has been made for your game",
+			EventType: "offers",
+		})
+	}
+
+	if requestMakerEmail != "" {
+		_ = kafka.PushNotification(kafka.Notification{
+			To:        requestMakerEmail,
+			Subject:   "Game Offer Created",
+			Body:      "You have made a game offer",
+			EventType: "offers",
+		})
+	}
+
+	trade.OfferID = tradeID
+	writeJSON(w, http.StatusCreated, tradeHATEOAS(trade))
+	pr.PostRequests.With(prometheus.Labels{"where": "Create Offer"}).Inc()
+}
+
+// offerExpiry picks the deadline for a new offer, the default lifetime unless the caller asked for one.
+func offerExpiry(w http.ResponseWriter, requested *time.Time) (time.Time, bool) {
+	now := time.Now()
+	if requested == nil {
+		return now.Add(offerTTL).UTC(), true
+	}
+	if !requested.After(now) {
+		writeError(w, http.StatusBadRequest, "expiresAt must be in the future")
+		return time.Time{}, false
+	}
+	if requested.After(now.Add(offerMaxTTL)) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("expiresAt can be at most %s away", offerMaxTTL))
+		return time.Time{}, false
+	}
+	return requested.UTC(), true
+}
+
+// sweepExpiredOffers runs for the life of the process and expires stale offers every interval.
+func (s *server) sweepExpiredOffers(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.expireOffers(context.Background())
+	}
+}
+
+func (s *server) expireOffers(ctx context.Context) {
+	expired, err := s.trades.ExpireTradeOffers(ctx, time.Now())
+	if err != nil {
+		log.Println("offer sweep failed:", err)
+	}
+
+	for _, o := range expired {
+		xc.ExpiredOffers.Inc()
+		for _, userID := range []int{o.RequesterID, o.OwnerUserID} {
+			if email := s.users.GetEmailWithID(ctx, userID); email != "" {
+				_ = kafka.PushNotification(kafka.Notification{
+					To:        email,
+					Subject:   "Game Offer Expired",
+					Body:      fmt.Sprintf("Trade offer #%d expired before it was answered.", o.OfferID),
+					EventType: "offers",
+				})
+			}
+		}
+	}
+}
+
+// maxOfferGames caps how many games one side of an offer can hold.
+const maxOfferGames = 10
+
+// validateOfferGames checks both sides of an offer and returns the owner of the requested games.
+// Every requested game must belong to the same other user and every offered game to the requester.
+func (s *server) validateOfferGames(ctx context.Context, w http.ResponseWriter, requesterID int, requested, offered []int) (int, bool) {
+	if len(requested) == 0 || len(offered) == 0 {
+		writeError(w, http.StatusBadRequest, "MISSING GAME IDS")
+		return 0, false
+	}
+	if len(requested) > maxOfferGames || len(offered) > maxOfferGames {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("An offer can hold at most %d games per side", maxOfferGames))
+		return 0, false
+	}
+
+	seen := make(map[int]bool, len(requested)+len(offered))
+	for _, id := range append(append([]int{}, requested...), offered...) {
+		if id <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid game ID")
+			return 0, false
+		}
+		if seen[id] {
+			writeError(w, http.StatusBadRequest, "CANT TRADE THE SAME GAME")
+			return 0, false
+		}
+		seen[id] = true
+	}
+
+	ownerID := 0
+	for _, id := range requested {
+		game, err := s.games.GetOwnedGameBYID(ctx, id)
+		if err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				er.error404.With(prometheus.Labels{"where": "GetOwnedGameByID GAMErequest NOT FOUND"}).Inc()
+			}
+			writeDataError(w, err)
+			return 0, false
+		}
+		// Prevent trading with yourself
+		if game.OwnerUserID == requesterID {
+			writeError(w, http.StatusBadRequest, "This is your own game")
+			return 0, false
+		}
+		if ownerID != 0 && game.OwnerUserID != ownerID {
+			writeError(w, http.StatusBadRequest, "Requested games must all belong to the same user")
+			return 0, false
+		}
+		ownerID = game.OwnerUserID
+	}
+
+	for _, id := range offered {
+		game, err := s.games.GetOwnedGameBYID(ctx, id)
+		if err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				er.error404.With(prometheus.Labels{"where": "GetOwnedGameByID GAMEoffered NOT FOUND"}).Inc()
+			}
+			writeDataError(w, err)
+			return 0, false
+		}
+		// Verify the game being offered actually belongs to the person logged in
+		if game.OwnerUserID != requesterID {
+			writeError(w, http.StatusForbidden, "You can only offer your own games")
+			return 0, false
+		}
+	}
+
+	// Games already promised in an accepted trade can't be offered again until it finishes
+	busy, err := s.trades.GamesInActiveTrade(ctx, append(append([]int{}, requested...), offered...))
+	if err != nil {
+		writeDataError(w, err)
+		return 0, false
+	}
+	if len(busy) > 0 {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Game %d is in an active trade", busy[0]))
+		return 0, false
+	}
+
+	return ownerID, true
+}
+
+// identity is the caller as vouched for by the auth service through nginx.
+type identity struct {
+	UserID int
+	Role   string
+}
+
+func (id identity) isAdmin() bool {
+	return id.Role == data.RoleAdmin
+}
+
+func (id identity) isModerator() bool {
+	return id.Role == data.RoleModerator || id.Role == data.RoleAdmin
+}
+
+// requireIdentity reads the trusted X-User-ID header, writing a 401/400 when it is unusable.
+func requireIdentity(w http.ResponseWriter, r *http.Request) (identity, bool) {
+	userStr := r.Header.Get("X-User-ID")
+	if userStr == "" {
+		writeError(w, http.StatusUnauthorized, "Identity missing")
+		return identity{}, false
+	}
+
+	userID, err := strconv.Atoi(userStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid User ID format")
+		return identity{}, false
+	}
+
+	return identity{
+		UserID: userID,
+		Role:   strings.ToLower(strings.TrimSpace(r.Header.Get("X-User-Role"))),
+	}, true
+}
+
+// requireRole writes a 403 unless the caller passes the given role check.
+func requireRole(w http.ResponseWriter, r *http.Request, allowed func(identity) bool) (identity, bool) {
+	who, ok := requireIdentity(w, r)
+	if !ok {
+		return identity{}, false
+	}
+	if !allowed(who) {
+		writeError(w, http.StatusForbidden, "You dont have permission to do that")
+		fc.Forbidden.With(prometheus.Labels{"where": r.Method + " admin"}).Inc()
+		return identity{}, false
+	}
+	return who, true
+}
+
+// ownerLookup resolves which user owns the resource with the given ID.
+type ownerLookup func(ctx context.Context, id int) (int, error)
+
+func userOwnerID(ctx context.Context, id int) (int, error) {
+	return id, nil
+}
+
+func (s *server) gameOwnerID(ctx context.Context, id int) (int, error) {
+	game, err := s.games.GetOwnedGameBYID(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return game.OwnerUserID, nil
+}
+
+// requireOwner is the single authorization check for every mutating route:
+// only the owner of the resource (or an admin) gets through, everyone else gets a 403.
+func requireOwner(w http.ResponseWriter, r *http.Request, resourceID int, owner ownerLookup) (identity, bool) {
+	who, ok := requireIdentity(w, r)
+	if !ok {
+		return identity{}, false
+	}
+
+	ownerID, err := owner(r.Context(), resourceID)
+	if err != nil {
+		writeDataError(w, err)
+		return identity{}, false
+	}
+
+	if ownerID != who.UserID && !who.isAdmin() {
+		writeError(w, http.StatusForbidden, "You can only modify your own resources")
+		fc.Forbidden.With(prometheus.Labels{"where": r.Method + " " + strings.Split(strings.Trim(r.URL.Path, "/"), "/")[0]}).Inc()
+		return identity{}, false
+	}
+
+	return who, true
+}
+
+func (s *server) adminHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/"), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "users":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.adminListUsers(w, r)
+
+	case len(parts) == 2 && parts[0] == "users":
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid User ID")
+			return
+		}
+		if r.Method != http.MethodPatch {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.adminPatchUser(w, r, id)
+
+	case len(parts) == 3 && parts[0] == "offers" && parts[2] == "cancel":
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid Offer ID")
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.adminCancelOffer(w, r, id)
+
+	case len(parts) == 1 && parts[0] == "disputes":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.adminListDisputes(w, r)
+
+	case len(parts) == 3 && parts[0] == "disputes" && parts[2] == "resolve":
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid Dispute ID")
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.adminResolveDispute(w, r, id)
+
+	case len(parts) == 2 && parts[0] == "games":
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid Game ID")
+			return
+		}
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.adminRemoveGame(w, r, id)
+
+	default:
+		writeError(w, http.StatusNotFound, "Unknown admin route")
+	}
+}
+
+func (s *server) adminListUsers(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, identity.isAdmin); !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	role := strings.ToLower(strings.TrimSpace(q.Get("role")))
+	if role != "" && !data.IsValidRole(role) {
+		writeError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	limit := defaultPageLimit
+	if raw := q.Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(v, maxPageLimit)
+	}
+
+	after := 0
+	if raw := q.Get("after"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid after")
+			return
+		}
+		after = v
+	}
+
+	users, err := s.users.ListUsers(r.Context(), role, after, limit)
+	if err != nil {
+		writeDataError(w, err)
+		return
+	}
+
+	resp := make([]any, 0, len(users))
+	for _, u := range users {
+		user := userHATEOAS(u.ID, u.Username, u.Email, u.StreetAddress)
+		user["role"] = u.Role
+		user["strikes"] = u.Strikes
+		resp = append(resp, user)
+	}
+
+	links := map[string]Link{
+		"self": {Href: r.URL.RequestURI()},
+	}
+	if len(users) == limit {
+		next := r.URL.Query()
+		next.Set("after", strconv.Itoa(users[len(users)-1].ID))
+		links["next"] = Link{Href: r.URL.Path + "?" + next.Encode()}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"users":  resp,
+		"count":  len(resp),
+		"_links": links,
+	})
+	gr.GetRequests.With(prometheus.Labels{"where": "GET admin users"}).Inc()
+}
+
+func (s *server) adminPatchUser(w http.ResponseWriter, r *http.Request, id int) {
+	who, ok := requireRole(w, r, identity.isAdmin)
+	if !ok {
+		return
+	}
+
+	var patch data.UserRolePatch
+	if err := readJSON(r, &patch); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if patch.Role == nil {
+		writeError(w, http.StatusBadRequest, "role is required")
+		return
+	}
+
+	role := strings.ToLower(strings.TrimSpace(*patch.Role))
+	if !data.IsValidRole(role) {
+		writeError(w, http.StatusBadRequest, "Invalid role, use user, moderator or admin")
+		return
+	}
+	// Stops the last admin from locking everybody out by accident
+	if id == who.UserID {
+		writeError(w, http.StatusBadRequest, "You cant change your own role")
+		return
+	}
+
+	if err := s.users.UpdateUserRole(r.Context(), id, role); err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			er.error404.With(prometheus.Labels{"where": "adminPatchUser, USER NOT FOUND"}).Inc()
+		}
+		writeDataError(w, err)
+		return
+	}
+
+	ac.AdminActions.With(prometheus.Labels{"action": "change role"}).Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type adminReason struct {
+	Reason string `json:"reason"`
+}
+
+func (s *server) adminCancelOffer(w http.ResponseWriter, r *http.Request, id int) {
+	mod, ok := requireRole(w, r, identity.isModerator)
+	if !ok {
+		return
+	}
+
+	// The reason is optional, an empty body is fine
+	var body adminReason
+	if err := readJSON(r, &body); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	offer, err := s.trades.GetTradeOfferByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			er.error404.With(prometheus.Labels{"where": "adminCancelOffer, OFFER NOT FOUND"}).Inc()
+		}
+		writeDataError(w, err)
+		return
+	}
+
+	if offer.CurrentStatus != "pending" {
+		writeError(w, http.StatusConflict, "Offer is not pending")
+		return
+	}
+
+	if err := s.trades.UpdateTradeOfferStatus(r.Context(), id, "cancelled", mod.UserID, body.Reason); err != nil {
+		writeDataError(w, err)
+		return
+	}
+
+	msg := "A trade offer you are part of was cancelled by a moderator."
+	if body.Reason != "" {
+		msg += " Reason: " + body.Reason
+	}
+	for _, userID := range []int{offer.OwnerUserID, offer.RequesterID} {
+		if email := s.users.GetEmailWithID(r.Context(), userID); email != "" {
+			_ = kafka.PushNotification(kafka.Notification{
+				To:        email,
+				Subject:   "Game Offer Cancelled",
+				Body:      msg,
+				EventType: "offers",
+			})
+		}
+	}
+
+	ac.AdminActions.With(prometheus.Labels{"action": "cancel offer"}).Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) adminListDisputes(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, identity.isModerator); !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	status := strings.ToLower(strings.TrimSpace(q.Get("status")))
+	if status != "" && status != data.DisputeStatusOpen && status != data.DisputeStatusResolved {
+		writeError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	limit := defaultPageLimit
+	if raw := q.Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(v, maxPageLimit)
+	}
+
+	after := 0
+	if raw := q.Get("after"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid after")
+			return
+		}
+		after = v
+	}
+
+	disputes, err := s.trades.ListDisputes(r.Context(), status, after, limit)
+	if err != nil {
+		writeDataError(w, err)
+		return
+	}
+
+	resp := make([]any, 0, len(disputes))
+	for _, d := range disputes {
+		resp = append(resp, disputeHATEOAS(d))
+	}
+
+	links := map[string]Link{
+		"self": {Href: r.URL.RequestURI()},
+	}
+	if len(disputes) == limit {
+		next := r.URL.Query()
+		next.Set("after", strconv.Itoa(disputes[len(disputes)-1].DisputeID))
+		links["next"] = Link{Href: r.URL.Path + "?" + next.Encode()}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"disputes": resp,
+		"count":    len(resp),
+		"_links":   links,
+	})
+	gr.GetRequests.With(prometheus.Labels{"where": "GET admin disputes"}).Inc()
+}
+
+func (s *server) adminResolveDispute(w http.ResponseWriter, r *http.Request, id int) {
+	mod, ok := requireRole(w, r, identity.isModerator)
+	if !ok {
+		return
+	}
+
+	var ruling data.TradeDisputeResolution
+	if err := readJSON(r, &ruling); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ruling.Resolution = strings.ToLower(strings.TrimSpace(ruling.Resolution))
+	ruling.Note = strings.TrimSpace(ruling.Note)
+	if ruling.Resolution != data.DisputeResolutionRevert && ruling.Resolution != data.DisputeResolutionClose {
+		writeError(w, http.StatusBadRequest, "resolution must be revert or close")
+		return
+	}
+	if utf8.RuneCountInString(ruling.Note) > data.MaxDisputeReasonLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("note can be at most %d characters", data.MaxDisputeReasonLength))
+		return
+	}
+
+	dispute, offer, err := s.trades.ResolveTradeDispute(r.Context(), id, mod.UserID, ruling)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			er.error404.With(prometheus.Labels{"where": "adminResolveDispute, DISPUTE NOT FOUND"}).Inc()
+		}
+		writeDataError(w, err)
+		return
+	}
+
+	msg := fmt.Sprintf("A moderator resolved the dispute on trade offer #%d.", offer.OfferID)
+	if ruling.Resolution == data.DisputeResolutionRevert {
+		msg += " The trade has been reverted and every game is back with its original owner."
+	} else {
+		msg += " The dispute was closed and the trade continues."
+	}
+	if ruling.Note != "" {
+		msg += "\n\nNote: " + ruling.Note
+	}
+	s.notifyDisputeParties(r.Context(), offer, "Trade Dispute Resolved", msg)
+
+	ac.AdminActions.With(prometheus.Labels{"action": "resolve dispute"}).Inc()
+	writeJSON(w, http.StatusOK, disputeHATEOAS(dispute))
+}
+
+func (s *server) adminRemoveGame(w http.ResponseWriter, r *http.Request, id int) {
+	if _, ok := requireRole(w, r, identity.isModerator); !ok {
+		return
+	}
+
+	game, err := s.games.GetOwnedGameBYID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			er.error404.With(prometheus.Labels{"where": "adminRemoveGame, GAME NOT FOUND"}).Inc()
+		}
+		writeDataError(w, err)
+		return
+	}
+
+	if err := s.games.DeleteGameByID(r.Context(), id); err != nil {
+		writeDataError(w, err)
+		return
+	}
+
+	if email := s.users.GetEmailWithID(r.Context(), game.OwnerUserID); email != "" {
+		msg := "Your listing for " + game.Title + " was removed by a moderator."
+		if reason := strings.TrimSpace(r.URL.Query().Get("reason")); reason != "" {
+			msg += " Reason: " + reason
+		}
+		_ = kafka.PushNotification(kafka.Notification{
+			To:        email,
+			Subject:   "Game Listing Removed",
+			Body:      msg,
+			EventType: "users",
+		})
+	}
+
+	ac.AdminActions.With(prometheus.Labels{"action": "remove game"}).Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]any{
+		"error":  msg,
+		"status": status,
+	})
+}
+
+// statusForError maps the error kinds from the data package onto HTTP status codes.
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, data.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, data.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, data.ErrInvalid), errors.Is(err, data.ErrInvalidToken):
+		return http.StatusBadRequest
+	case errors.Is(err, data.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, context.DeadlineExceeded):
+		// The query ran past DB_QUERY_TIMEOUT
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeDataError writes an error from the data package with the status matching its kind.
+func writeDataError(w http.ResponseWriter, err error) {
+	writeError(w, statusForError(err), err.Error())
+}
+
+func userHATEOAS(userID int, username, email, streetAddress string) map[string]any {
+	return map[string]any{
+		"id":            userID,
+		"username":      username,
+		"email":         email,
+		"streetAddress": streetAddress,
+		"_links": map[string]Link{
+			"self":   {Href: fmt.Sprintf("/users/%d", userID)},
+			"update": {Href: fmt.Sprintf("/users/%d", userID)},
+			"patch":  {Href: fmt.Sprintf("/users/%d", userID)},
+			"delete": {Href: fmt.Sprintf("/users/%d", userID)},
+		},
+	}
+}
+
+func gameHATEOAS(game data.Game) map[string]any {
+	return map[string]any{
+		"id":          game.ID,
+		"title":       game.Title,
+		"publisher":   game.Publisher,
+		"description": game.Description,
+		"platform":    game.Platform,
+		"year":        game.Year,
+		"condition":   game.Condition,
+		"_links": map[string]Link{
+			"self":       {Href: fmt.Sprintf("/games/%d", game.ID)},
+			"update":     {Href: fmt.Sprintf("/games/%d", game.ID)},
+			"patch":      {Href: fmt.Sprintf("/games/%d", game.ID)},
+			"delete":     {Href: fmt.Sprintf("/games/%d", game.ID)},
+			"provenance": {Href: fmt.Sprintf("/games/%d/provenance", game.ID)},
+		},
+	}
+}
+
+func ownedGameHATEOAS(game data.OwnedGame) map[string]any {
+	resp := gameHATEOAS(data.Game{
+		ID:          game.ID,
+		Title:       game.Title,
+		Publisher:   game.Publisher,
+		Description: game.Description,
+		Platform:    game.Platform,
+		Year:        game.Year,
+		Condition:   game.Condition,
+	})
+	resp["ownerUserId"] = game.OwnerUserID
+	resp["previousOwners"] = game.PreviousOwners
+	resp["_links"].(map[string]Link)["owner"] = Link{Href: fmt.Sprintf("/users/%d", game.OwnerUserID)}
+	return resp
+}
+
+func tradeHATEOAS(o data.TradeOffer) map[string]any {
+	resp := map[string]any{
+		"offerId":          o.OfferID,
+		"requesterId":      o.RequesterID,
+		"ownerUserId":      o.OwnerUserID,
+		"gameRequestedIds": o.GameRequestedIDs,
+		"gameOfferedIds":   o.GameOfferedIDs,
+		"currentStatus":    o.CurrentStatus,
+		"_links": map[string]Link{
+			"self":     {Href: fmt.Sprintf("/offers/%d", o.OfferID)},
+			"update":   {Href: fmt.Sprintf("/offers/%d", o.OfferID)},
+			"patch":    {Href: fmt.Sprintf("/offers/%d", o.OfferID)},
+			"counter":  {Href: fmt.Sprintf("/offers/%d/counter", o.OfferID)},
+			"messages": {Href: fmt.Sprintf("/offers/%d/messages", o.OfferID)},
+			"history":  {Href: fmt.Sprintf("/offers/%d/history", o.OfferID)},
+		},
+	}
+	// Clients from before bundles read the single id fields, they are kept for a side with one game
+	if len(o.GameRequestedIDs) == 1 {
+		resp["gameRequestedId"] = o.GameRequestedIDs[0]
+	}
+	if len(o.GameOfferedIDs) == 1 {
+		resp["gameOfferedId"] = o.GameOfferedIDs[0]
+	}
+	if !o.CreatedAt.IsZero() {
+		resp["createdAt"] = o.CreatedAt.UTC().Format(time.RFC3339)
+		resp["updatedAt"] = o.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	if o.ExpiresAt != nil {
+		resp["expiresAt"] = o.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	switch o.CurrentStatus {
+	case data.TradeStatusAwaitingShipment, data.TradeStatusShipped, data.TradeStatusReceived,
+		data.TradeStatusCompleted, data.TradeStatusDisputed:
+		resp["shipment"] = o.Shipment
+		links := resp["_links"].(map[string]Link)
+		links["shipment"] = Link{Href: fmt.Sprintf("/offers/%d/shipment", o.OfferID)}
+		links["receipt"] = Link{Href: fmt.Sprintf("/offers/%d/receipt", o.OfferID)}
+		links["disputes"] = Link{Href: fmt.Sprintf("/offers/%d/disputes", o.OfferID)}
+		if o.CurrentStatus == data.TradeStatusCompleted {
+			links["reviews"] = Link{Href: fmt.Sprintf("/offers/%d/reviews", o.OfferID)}
+		}
+	}
+	if o.ParentOfferID != nil {
+		resp["parentOfferId"] = *o.ParentOfferID
+		resp["_links"].(map[string]Link)["parent"] = Link{Href: fmt.Sprintf("/offers/%d", *o.ParentOfferID)}
+	}
+	return resp
+}
+
+func messageHATEOAS(m data.TradeMessage) map[string]any {
+	return map[string]any{
+		"messageId": m.MessageID,
+		"offerId":   m.OfferID,
+		"senderId":  m.SenderID,
+		"body":      m.Body,
+		"createdAt": m.CreatedAt.UTC().Format(time.RFC3339),
+		"_links": map[string]Link{
+			"offer":    {Href: fmt.Sprintf("/offers/%d", m.OfferID)},
+			"messages": {Href: fmt.Sprintf("/offers/%d/messages", m.OfferID)},
+			"sender":   {Href: fmt.Sprintf("/users/%d", m.SenderID)},
+		},
+	}
+}
+
+func wishlistHATEOAS(item data.WishlistItem) map[string]any {
+	resp := map[string]any{
+		"wishId":    item.WishID,
+		"title":     item.Title,
+		"createdAt": item.CreatedAt.UTC().Format(time.RFC3339),
+		"_links": map[string]Link{
+			"delete":   {Href: fmt.Sprintf("/users/%d/wishlist/%d", item.UserID, item.WishID)},
+			"wishlist": {Href: fmt.Sprintf("/users/%d/wishlist", item.UserID)},
+		},
+	}
+	if item.Platform != "" {
+		resp["platform"] = item.Platform
+	}
+	if item.Condition != "" {
+		resp["condition"] = item.Condition
+	}
+	return resp
+}
+
+func reviewHATEOAS(rv data.TradeReview) map[string]any {
+	return map[string]any{
+		"reviewId":   rv.ReviewID,
+		"offerId":    rv.OfferID,
+		"reviewerId": rv.ReviewerID,
+		"revieweeId": rv.RevieweeID,
+		"rating":     rv.Rating,
+		"comment":    rv.Comment,
+		"createdAt":  rv.CreatedAt.UTC().Format(time.RFC3339),
+		"_links": map[string]Link{
+			"offer":    {Href: fmt.Sprintf("/offers/%d", rv.OfferID)},
+			"reviewer": {Href: fmt.Sprintf("/users/%d", rv.ReviewerID)},
+			"reviewee": {Href: fmt.Sprintf("/users/%d", rv.RevieweeID)},
+		},
+	}
+}
+
+func disputeHATEOAS(d data.TradeDispute) map[string]any {
+	resp := map[string]any{
+		"disputeId":      d.DisputeID,
+		"offerId":        d.OfferID,
+		"openedBy":       d.OpenedBy,
+		"reason":         d.Reason,
+		"evidence":       d.Evidence,
+		"status":         d.Status,
+		"previousStatus": d.PreviousStatus,
+		"createdAt":      d.CreatedAt.UTC().Format(time.RFC3339),
+		"_links": map[string]Link{
+			"offer":   {Href: fmt.Sprintf("/offers/%d", d.OfferID)},
+			"resolve": {Href: fmt.Sprintf("/admin/disputes/%d/resolve", d.DisputeID)},
+		},
+	}
+	if d.Status == data.DisputeStatusResolved {
+		resp["resolution"] = d.Resolution
+		resp["resolutionNote"] = d.ResolutionNote
+		resp["resolvedBy"] = d.ResolvedBy
+		resp["penalisedUserId"] = d.PenalisedUserID
+		if d.ResolvedAt != nil {
+			resp["resolvedAt"] = d.ResolvedAt.UTC().Format(time.RFC3339)
+		}
+	}
+	return resp
+}
+
+func readJSON(r *http.Request, dst any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return dec.Decode(dst)
+}
+
+func (s *server) gamePost(w http.ResponseWriter, r *http.Request) {
+	var gameRequest data.GameCreateRequest
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Its gotta be a Post method")
+		return
+	}
+
+	// 1. Get the TRUSTED ID from the Nginx header
+	who, ok := requireIdentity(w, r)
+	if !ok {
+		return
+	}
+	userID := who.UserID
+
+	if err := readJSON(r, &gameRequest); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 2. Validate fields (we no longer check gameRequest.OwnerUserID because we use our trusted userID)
+	if gameRequest.Title == "" || gameRequest.Publisher == "" ||
+		gameRequest.Description == "" || gameRequest.Year <= 0 || gameRequest.Condition == "" {
+		writeError(w, http.StatusBadRequest, "MISSING REQUIRED FIELDS")
+		return
+	}
+	gameRequest.Platform = strings.TrimSpace(gameRequest.Platform)
+	if utf8.RuneCountInString(gameRequest.Platform) > data.MaxPlatformLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("platform can be at most %d characters", data.MaxPlatformLength))
+		return
+	}
+
+	game := data.Game{
+		Title:       gameRequest.Title,
+		Publisher:   gameRequest.Publisher,
+		Description: gameRequest.Description,
+		Platform:    gameRequest.Platform,
+		Year:        gameRequest.Year,
+		Condition:   gameRequest.Condition,
+	}
+
+	// 3. Create the game using the TRUSTED userID from the header
+	newGameID, err := s.games.CreateGame(r.Context(), game, userID)
+	if err != nil {
+		writeDataError(w, err)
+		return
+	}
+
+	game.ID = newGameID
+	writeJSON(w, http.StatusCreated, gameHATEOAS(game))
+	ctx := context.WithoutCancel(r.Context())
+	s.notifying.Go(func() { s.notifyWishlistMatches(ctx, newGameID) })
+
+	pr.PostRequests.With(prometheus.Labels{"where": "Create game"}).Inc()
+}
+
+func (s *server) userPost(w http.ResponseWriter, r *http.Request) {
+	var userRequest data.NewUserRequest
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Its gotta be a Post method")
+		return
+	}
+
+	if err := readJSON(r, &userRequest); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if userRequest.Username == "" || userRequest.Password == "" ||
+		userRequest.Email == "" || userRequest.StreetAddress == "" {
+		writeError(w, http.StatusBadRequest, "MISSING REQUIRED FIELDS :( ")
+		return
+	}
+
+	if msg := data.CheckPasswordPolicy(userRequest.Password); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if addr, err := mail.ParseAddress(userRequest.Email); err != nil || addr.Address != userRequest.Email {
+		writeError(w, http.StatusBadRequest, "That doesnt look like an email address")
+		return
+	}
+
+	user := data.User{
+		Username:      userRequest.Username,
+		Password:      userRequest.Password,
+		Email:         userRequest.Email,
+		StreetAddress: userRequest.StreetAddress,
+	}
+
+	newUserId, err := s.users.CreateUser(r.Context(), user)
+	if err != nil {
+		writeDataError(w, err)
+		return
+	}
+
+	// New accounts cant log in until the address is confirmed
+	sendVerificationEmail(newUserId, userRequest.Email)
+
+	response := userHATEOAS(newUserId, userRequest.Username, userRequest.Email, userRequest.StreetAddress)
+	response["emailVerified"] = false
+
+	writeJSON(w, http.StatusCreated, response)
+	pr.PostRequests.With(prometheus.Labels{"where": "Create user"}).Inc()
+}
+
+func (s *server) passwordResetRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Its gotta be a Post method")
+		return
+	}
+
+	var req data.PasswordResetRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Email) == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	// Same answer whether or not the account exists so this cant be used to find registered emails
+	accepted := map[string]any{
+		"status":  http.StatusAccepted,
+		"message": "If that email is registered a reset link is on its way",
+	}
+
+	// Asking again inside the cooldown gets the same answer but no email
+	if !resetMails.allow(req.Email, time.Now()) {
+		writeJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+
+	userID, err := s.users.GetUserIDByEmail(r.Context(), req.Email)
+	if err != nil {
+		if !errors.Is(err, data.ErrNotFound) {
+			log.Println("password reset lookup failed:", err)
+		}
+		writeJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Error generating reset token")
+		return
+	}
+	// The throttle above is per instance, the database check holds it across all of them
+	now := time.Now()
+	if err := s.users.CreatePasswordReset(r.Context(), userID, data.HashToken(token), now.Add(passwordResetTTL), now.Add(-mailCooldown)); err != nil {
+		if errors.Is(err, data.ErrTooSoon) {
+			writeJSON(w, http.StatusAccepted, accepted)
+			return
+		}
+		writeDataError(w, err)
+		return
+	}
+
+	body := "Use this code to reset your password, it expires in " + passwordResetTTL.String() + ": " + token
+	if base := os.Getenv("PASSWORD_RESET_URL"); base != "" {
+		body = "Reset your password here, the link expires in " + passwordResetTTL.String() + ": " + base + "?token=" + token
+	}
+	err = kafka.PushNotification(kafka.Notification{
+		To:        req.Email,
+		Subject:   "Password Reset Requested",
+		Body:      body + "\n\nIf you didnt ask for this you can ignore this email.",
+		EventType: "users",
+	})
+	if err != nil {
+		log.Println("kafka push FAILED:", err)
+	}
+
+	writeJSON(w, http.StatusAccepted, accepted)
+	pr.PostRequests.With(prometheus.Labels{"where": "Password reset request"}).Inc()
+}
+
+func (s *server) passwordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Its gotta be a Post method")
+		return
+	}
+
+	var req data.PasswordResetConfirm
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Token == "" || req.Password == "" {
+		writeError(w, http.StatusBadRequest, "token and password are required")
+		return
+	}
+	if msg := data.CheckPasswordPolicy(req.Password); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	userID, err := s.users.ResetPassword(r.Context(), data.HashToken(req.Token), req.Password)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) || errors.Is(err, data.ErrInvalidToken) {
+			writeError(w, http.StatusBadRequest, "Invalid or expired reset token")
+			return
+		}
+		writeDataError(w, err)
+		return
+	}
+
+	if userEmail := s.users.GetEmailWithID(r.Context(), userID); userEmail != "" {
+		err := kafka.PushNotification(kafka.Notification{
+			To:        userEmail,
+			Subject:   "Password Changed",
+			Body:      "Your password was reset and every device was signed out (if this wasnt you we have a problem)",
+			EventType: "users",
+		})
+		if err != nil {
+			log.Println("kafka push FAILED:", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// minEmailTokenSecret is the shortest EMAIL_TOKEN_SECRET accepted, HS256 wants a key at least as long as its hash.
+const minEmailTokenSecret = 32
+
+// emailSecret signs verification links. It is its own secret, not shared with anything else, so
+// leaking or rotating one never affects the other. main refuses to start without it.
+var emailSecret []byte
+
+func loadEmailTokenSecret() ([]byte, error) {
+	secret := os.Getenv("EMAIL_TOKEN_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("EMAIL_TOKEN_SECRET is not set")
+	}
+	if len(secret) < minEmailTokenSecret {
+		return nil, fmt.Errorf("EMAIL_TOKEN_SECRET must be at least %d bytes", minEmailTokenSecret)
+	}
+	return []byte(secret), nil
+}
+
+func sendVerificationEmail(userID int, email string) {
+	claims := jwt.MapClaims{
+		"sub":     userID,
+		"email":   email,
+		"purpose": emailVerificationPurpose,
+		"exp":     time.Now().Add(emailVerificationTTL).Unix(),
+		"iat":     time.Now().Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(emailSecret)
+	if err != nil {
+		log.Println("signing verification token failed:", err)
+		return
+	}
+
+	body := "Confirm your email with this code: " + token
+	if base := os.Getenv("EMAIL_VERIFICATION_URL"); base != "" {
+		body = "Confirm your email here: " + base + "?token=" + token
+	}
+	err = kafka.PushNotification(kafka.Notification{
+		To:        email,
+		Subject:   "Verify Your Email",
+		Body:      body + "\n\nThe link expires in " + emailVerificationTTL.String() + ".",
+		EventType: "users",
+	})
+	if err != nil {
+		log.Println("kafka push FAILED:", err)
+	}
+}
+
+func (s *server) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var token string
+	switch r.Method {
+	case http.MethodGet:
+		// GET so the link in the email works when clicked
+		token = r.URL.Query().Get("token")
+	case http.MethodPost:
+		var req data.EmailVerificationRequest
+		if err := readJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		token = req.Token
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if token == "" {
+		writeError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		return emailSecret, nil
+	})
+	if err != nil || !parsed.Valid || claims["purpose"] != emailVerificationPurpose {
+		writeError(w, http.StatusBadRequest, "Invalid or expired verification token")
+		return
+	}
+	userID, ok := claims["sub"].(float64)
+	email, ok2 := claims["email"].(string)
+	if !ok || !ok2 {
+		writeError(w, http.StatusBadRequest, "Invalid or expired verification token")
+		return
+	}
+
+	if err := s.users.MarkEmailVerified(r.Context(), int(userID), email); err != nil {
+		if errors.Is(err, data.ErrInvalidToken) {
+			writeError(w, http.StatusBadRequest, "Invalid or expired verification token")
+			return
+		}
+		writeDataError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":  http.StatusOK,
+		"message": "Email verified, you can log in now",
+		"_links": map[string]Link{
+			"user":  {Href: fmt.Sprintf("/users/%d", int(userID))},
+			"login": {Href: "/users/login"},
+		},
+	})
+}
+
+func (s *server) resendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Its gotta be a Post method")
+		return
+	}
+
+	var req data.PasswordResetRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Email) == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	// Like password resets, never reveal whether the address is registered or was throttled
+	if verificationMails.allow(req.Email, time.Now()) {
+		if userID, err := s.users.GetUserIDByEmail(r.Context(), req.Email); err == nil {
+			if verified, err := s.users.IsEmailVerified(r.Context(), userID); err == nil && !verified {
+				sendVerificationEmail(userID, req.Email)
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"status":  http.StatusAccepted,
+		"message": "If that email is registered and unverified a new link is on its way",
+	})
+}
+
+// mailThrottle remembers when each address was last sent a mail so one address can't be flooded.
+// State is in memory and per instance, restarting the api clears it.
+type mailThrottle struct {
+	mu   sync.Mutex
+	sent map[string]time.Time
+	// order holds the sends oldest first, so expired addresses are dropped from the front
+	// without scanning the whole map.
+	order []mailSend
+}
+
+type mailSend struct {
+	key string
+	at  time.Time
+}
+
+func newMailThrottle() *mailThrottle {
+	return &mailThrottle{sent: map[string]time.Time{}}
+}
+
+// allow reports whether email is out of its cooldown and, if so, starts a new one.
+func (t *mailThrottle) allow(email string, now time.Time) bool {
+	key := strings.ToLower(strings.TrimSpace(email))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(now)
+	if _, ok := t.sent[key]; ok {
+		return false
+	}
+	t.sent[key] = now
+	t.order = append(t.order, mailSend{key: key, at: now})
+	return true
+}
+
+// prune drops the sends whose cooldown is over. An address is only in order once, until its send
+// expires, so deleting it from sent here can't drop a newer cooldown.
+func (t *mailThrottle) prune(now time.Time) {
+	for len(t.order) > 0 && now.Sub(t.order[0].at) >= mailCooldown {
+		delete(t.sent, t.order[0].key)
+		t.order = t.order[1:]
+	}
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// migrateOnBoot applies the pending migrations when MIGRATE_ON_BOOT is set. Every api instance
+// can have it on, the migration lock makes the others wait and then skip.
+func migrateOnBoot(ctx context.Context, store *data.SQLStore) error {
+	if !config.Bool("MIGRATE_ON_BOOT") {
+		return nil
+	}
+	ran, err := store.MigrateUp(ctx)
+	for _, m := range ran {
+		log.Printf("applied migration %04d_%s", m.Version, m.Name)
+	}
+	return err
+}
+
+// migrateCommand is `server migrate up|down|status|baseline`, returning the exit code.
+//
+//   - up applies every pending migration.
+//   - down reverts the newest applied one.
+//   - status lists them all with when each was applied.
+//   - baseline adopts a database built from the old SQLScriptChanges.sql: it records 0001 as
+//     applied without running it, after which up brings the schema the rest of the way.
+func migrateCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: migrate up|down|status|baseline")
+		return 2
+	}
+
+	store, err := data.ConnectDatabase()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		ran, err := store.MigrateUp(ctx)
+		for _, m := range ran {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(ran) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		m, ok, err := store.MigrateDown(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if !ok {
+			fmt.Println("no migrations to revert")
+			return 0
+		}
+		fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+	case "status":
+		status, err := store.MigrationStatus(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, st := range status {
+			applied := "pending"
+			if st.Dirty {
+				applied = "dirty, failed part way through"
+			} else if st.AppliedAt != nil {
+				applied = "applied " + st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", st.Version, st.Name, applied)
+		}
+	case "baseline":
+		m, err := store.MigrateBaseline(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("recorded %04d_%s as applied, run migrate up for the rest\n", m.Version, m.Name)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q, want up, down, status or baseline\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+func (s *server) userGetByID(w http.ResponseWriter, r *http.Request, id int) {
+	user, err := s.users.GetUser(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			er.error404.With(prometheus.Labels{"where": "userGetByID USER NOT FOUND"}).Inc()
+		}
+		writeDataError(w, err)
+		return
+	}
+
+	reputation, err := s.trades.GetUserReputation(r.Context(), id)
+	if err != nil {
+		writeDataError(w, err)
+		return
+	}
+
+	response := userHATEOAS(id, user.Username, user.Email, user.StreetAddress)
+	response["reputation"] = reputation
+
+	writeJSON(w, http.StatusOK, response)
+	gr.GetRequests.With(prometheus.Labels{"where": "Get user by id"}).Inc()
 }
 
-func tradeHATEOAS(o data.TradeOffer) map[string]any {
-	return map[string]any{
-		"offerId":         o.OfferID,
-		"requesterId":     o.RequesterID,
-		"ownerUserId":     o.OwnerUserID,
-		"gameRequestedId": o.GameRequestedID,
-		"gameOfferedId":   o.GameOfferedID,
-		"currentStatus":   o.CurrentStatus,
-		"_links": map[string]Link{
-			"self":   {Href: fmt.Sprintf("/offers/%d", o.OfferID)},
-			"update": {Href: fmt.Sprintf("/offers/%d", o.OfferID)},
-			"patch":  {Href: fmt.Sprintf("/offers/%d", o.OfferID)},
-		},
+func (s *server) gameGetByID(w http.ResponseWriter, r *http.Request, id int) {
+	game, err := s.games.GetGameBYID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			er.error404.With(prometheus.Labels{"where": "Get Game by id NOT FOUND"}).Inc()
+		}
+		writeDataError(w, err)
+		return
 	}
+	gr.GetRequests.With(prometheus.Labels{"where": "Get game by id"}).Inc()
+	writeJSON(w, http.StatusOK, gameHATEOAS(game))
 }
 
-func readJSON(r *http.Request, dst any) error {
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
-	return dec.Decode(dst)
-}
+// wishlistHandler serves /users/{id}/wishlist, /users/{id}/wishlist/matches and /users/{id}/wishlist/{wishId}.
+func (s *server) wishlistHandler(w http.ResponseWriter, r *http.Request, userID int, rest string) {
+	switch {
+	case rest == "":
+		switch r.Method {
+		case http.MethodGet:
+			s.getWishlist(w, r, userID)
+		case http.MethodPost:
+			s.addWishlistItem(w, r, userID)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
 
-func gamePost(w http.ResponseWriter, r *http.Request) {
-	var gameRequest data.GameCreateRequest
+	case rest == "matches":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.getWishlistMatches(w, r, userID)
 
-	if r.Method != http.MethodPost {
-		writeError(w, http.StatusMethodNotAllowed, "Its gotta be a Post method")
-		return
+	default:
+		wishID, err := strconv.Atoi(rest)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid Wish ID")
+			return
+		}
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if err := s.users.DeleteWishlistItem(r.Context(), userID, wishID); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				er.error404.With(prometheus.Labels{"where": "Delete wishlist item, NOT FOUND"}).Inc()
+			}
+			writeDataError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
 	}
+}
 
-	// 1. Get the TRUSTED ID from the Nginx header
-	userStr := r.Header.Get("X-User-ID")
-	if userStr == "" {
-		writeError(w, http.StatusUnauthorized, "Identity missing")
+func (s *server) getWishlist(w http.ResponseWriter, r *http.Request, userID int) {
+	items, err := s.users.GetWishlist(r.Context(), userID)
+	if err != nil {
+		writeDataError(w, err)
 		return
 	}
 
-	// Convert the header string to an int
-	userID, err := strconv.Atoi(userStr)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid User ID format")
-		return
+	resp := make([]any, 0, len(items))
+	for _, item := range items {
+		resp = append(resp, wishlistHATEOAS(item))
 	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": resp,
+		"count": len(resp),
+		"_links": map[string]Link{
+			"self":    {Href: fmt.Sprintf("/users/%d/wishlist", userID)},
+			"matches": {Href: fmt.Sprintf("/users/%d/wishlist/matches", userID)},
+			"user":    {Href: fmt.Sprintf("/users/%d", userID)},
+		},
+	})
+	gr.GetRequests.With(prometheus.Labels{"where": "GET wishlist"}).Inc()
+}
 
-	if err := readJSON(r, &gameRequest); err != nil {
+func (s *server) addWishlistItem(w http.ResponseWriter, r *http.Request, userID int) {
+	var body data.WishlistRequest
+	if err := readJSON(r, &body); err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// 2. Validate fields (we no longer check gameRequest.OwnerUserID because we use our trusted userID)
-	if gameRequest.Title == "" || gameRequest.Publisher == "" ||
-		gameRequest.Description == "" || gameRequest.Year <= 0 || gameRequest.Condition == "" {
-		writeError(w, http.StatusBadRequest, "MISSING REQUIRED FIELDS")
+	body.Title = strings.TrimSpace(body.Title)
+	body.Platform = strings.TrimSpace(body.Platform)
+	body.Condition = strings.TrimSpace(body.Condition)
+	if body.Title == "" {
+		writeError(w, http.StatusBadRequest, "title is required")
 		return
 	}
-
-	game := data.Game{
-		Title:       gameRequest.Title,
-		Publisher:   gameRequest.Publisher,
-		Description: gameRequest.Description,
-		Year:        gameRequest.Year,
-		Condition:   gameRequest.Condition,
+	// Same limits as the GAMES columns they are matched against
+	if utf8.RuneCountInString(body.Title) > 40 || utf8.RuneCountInString(body.Platform) > data.MaxPlatformLength || utf8.RuneCountInString(body.Condition) > 20 {
+		writeError(w, http.StatusBadRequest, "title and platform can be at most 40 characters, condition 20")
+		return
 	}
 
-	// 3. Create the game using the TRUSTED userID from the header
-	newGameID, err := data.CreateGame(game, userID)
+	item, err := s.users.AddWishlistItem(r.Context(), userID, body)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeDataError(w, err)
 		return
 	}
 
-	game.ID = newGameID
-	writeJSON(w, http.StatusCreated, gameHATEOAS(game))
-
-	gp := postRequests(register)
-	gp.PostRequests.With(prometheus.Labels{"where": "Create game"}).Inc()
+	writeJSON(w, http.StatusCreated, wishlistHATEOAS(item))
+	pr.PostRequests.With(prometheus.Labels{"where": "Add wishlist item"}).Inc()
 }
 
-func userPost(w http.ResponseWriter, r *http.Request) {
-	var userRequest data.NewUserRequest
-
-	if r.Method != http.MethodPost {
-		writeError(w, http.StatusMethodNotAllowed, "Its gotta be a Post method")
-		return
+func (s *server) getWishlistMatches(w http.ResponseWriter, r *http.Request, userID int) {
+	q := r.URL.Query()
+	limit := defaultPageLimit
+	if raw := q.Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(v, maxPageLimit)
 	}
 
-	if err := readJSON(r, &userRequest); err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
-		return
+	after := 0
+	if raw := q.Get("after"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid after")
+			return
+		}
+		after = v
 	}
 
-	if userRequest.Username == "" || userRequest.Password == "" ||
-		userRequest.Email == "" || userRequest.StreetAddress == "" {
-		writeError(w, http.StatusBadRequest, "MISSING REQUIRED FIELDS :( ")
+	games, err := s.games.GetWishlistMatches(r.Context(), userID, after, limit)
+	if err != nil {
+		writeDataError(w, err)
 		return
 	}
 
-	user := data.User{
-		Username:      userRequest.Username,
-		Password:      userRequest.Password,
-		Email:         userRequest.Email,
-		StreetAddress: userRequest.StreetAddress,
+	resp := make([]any, 0, len(games))
+	for _, g := range games {
+		resp = append(resp, ownedGameHATEOAS(g))
 	}
 
-	newUserId, err := data.CreateUser(user)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
-		return
+	links := map[string]Link{
+		"self":     {Href: r.URL.RequestURI()},
+		"wishlist": {Href: fmt.Sprintf("/users/%d/wishlist", userID)},
+	}
+	if len(games) == limit {
+		next := r.URL.Query()
+		next.Set("after", strconv.Itoa(games[len(games)-1].ID))
+		links["next"] = Link{Href: r.URL.Path + "?" + next.Encode()}
 	}
 
-	response := userHATEOAS(newUserId, userRequest.Username, userRequest.Email, userRequest.StreetAddress)
-
-	writeJSON(w, http.StatusCreated, response)
-	up := postRequests(register)
-	up.PostRequests.With(prometheus.Labels{"where": "Create user"}).Inc()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"games":  resp,
+		"count":  len(resp),
+		"_links": links,
+	})
+	gr.GetRequests.With(prometheus.Labels{"where": "GET wishlist matches"}).Inc()
 }
 
-func userGetByID(w http.ResponseWriter, r *http.Request, id int) {
-	user, err := data.GetUser(id)
-	if err != nil {
-		if err.Error() == "user not found in database" {
-			writeError(w, http.StatusNotFound, err.Error())
-			er.error404.With(prometheus.Labels{"where": "userGetByID USER NOT FOUND"}).Inc()
-			return
+// notifyWishlistMatches tells everyone hunting for one of these games that it is now available.
+// Handlers run it in its own goroutine so a popular title doesn't hold up their response, with a
+// context that outlives the request.
+func (s *server) notifyWishlistMatches(ctx context.Context, gameIDs ...int) {
+	for _, gameID := range gameIDs {
+		wishers, err := s.games.GetWishersForGame(ctx, gameID)
+		if err != nil {
+			log.Println("wishlist match lookup failed:", err)
+			continue
+		}
+		for _, wish := range wishers {
+			if email := s.users.GetEmailWithID(ctx, wish.UserID); email != "" {
+				_ = kafka.PushNotification(kafka.Notification{
+					To:        email,
+					Subject:   "Wishlist Match",
+					Body:      fmt.Sprintf("A game matching \"%s\" on your wishlist is available: /games/%d", wish.Title, gameID),
+					EventType: "users",
+				})
+			}
 		}
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
 	}
-
-	response := userHATEOAS(id, user.Username, user.Email, user.StreetAddress)
-
-	writeJSON(w, http.StatusOK, response)
-	gr.GetRequests.With(prometheus.Labels{"where": "Get user by id"}).Inc()
 }
 
-func gameGetByID(w http.ResponseWriter, r *http.Request, id int) {
-	game, err := data.GetGameBYID(id)
+func (s *server) userByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id, sub, err := parseUserPath(r.URL.Path)
 	if err != nil {
-		if err.Error() == "game not found in database" {
-			writeError(w, http.StatusNotFound, err.Error())
-			gg := Error404(register)
-			gg.error404.With(prometheus.Labels{"where": "Get Game by id NOT FOUND"}).Inc()
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if sub == "wishlist" || strings.HasPrefix(sub, "wishlist/") {
+		// A wishlist is private to its owner (and admins)
+		if _, ok := requireOwner(w, r, id, userOwnerID); !ok {
 			return
 		}
-		writeError(w, http.StatusInternalServerError, err.Error())
+		s.wishlistHandler(w, r, id, strings.TrimPrefix(strings.TrimPrefix(sub, "wishlist"), "/"))
 		return
 	}
-	gr.GetRequests.With(prometheus.Labels{"where": "Get game by id"}).Inc()
-	writeJSON(w, http.StatusOK, gameHATEOAS(game))
-}
-
-func userByIDHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := parseUserID(r.URL.Path)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+	if sub != "" {
+		writeError(w, http.StatusNotFound, "Unknown user route")
 		return
 	}
 
+	switch r.Method {
+	case http.MethodPut, http.MethodPatch, http.MethodDelete:
+		if _, ok := requireOwner(w, r, id, userOwnerID); !ok {
+			return
+		}
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		userGetByID(w, r, id)
+		s.userGetByID(w, r, id)
 	case http.MethodPut:
-		userPut(w, r, id)
+		s.userPut(w, r, id)
 	case http.MethodPatch:
-		userPatch(w, r, id)
+		s.userPatch(w, r, id)
 	case http.MethodDelete:
-		userDelete(w, r, id)
+		s.userDelete(w, r, id)
 	default:
 		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
-func gameHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) gameHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 
 	case http.MethodGet:
-		title := r.URL.Query().Get("title")
-		excludeOwner := r.URL.Query().Get("excludeOwnerId")
+		s.gameSearch(w, r)
+		return
 
-		if title != "" {
-			game, err := data.GetGameBYName(title)
-			if err != nil {
-				if err.Error() == "game not found in database" {
-					writeError(w, http.StatusNotFound, err.Error())
-					er.error404.With(prometheus.Labels{"where": "Get Game by name NOT FOUND"}).Inc()
-					return
-				}
-				writeError(w, http.StatusInternalServerError, err.Error())
-				return
-			}
-			writeJSON(w, http.StatusOK, gameHATEOAS(game))
-			return
-		} else if excludeOwner != "" {
-			var id, err = strconv.Atoi(excludeOwner)
-			if err != nil {
-				writeError(w, http.StatusBadRequest, err.Error())
-				return
-			}
-			var games []data.Game
-			games, err = data.GetGamesNotOwnedByID(id)
-			if err != nil {
-				writeError(w, http.StatusInternalServerError, err.Error())
-				return
-			}
-			writeJSON(w, http.StatusOK, games)
+	case http.MethodPost:
+		s.gamePost(w, r)
+		return
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+}
 
-			gr.GetRequests.With(prometheus.Labels{"where ": "GET Games owner doesnt own"}).Inc()
+func (s *server) gameSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	search := data.GameSearch{
+		Title:     strings.TrimSpace(q.Get("title")),
+		Publisher: strings.TrimSpace(q.Get("publisher")),
+		Platform:  strings.TrimSpace(q.Get("platform")),
+		Condition: strings.TrimSpace(q.Get("condition")),
+		Sort:      strings.ToLower(strings.TrimSpace(q.Get("sort"))),
+		Limit:     defaultPageLimit,
+	}
+
+	intParams := map[string]*int{
+		"yearFrom":       &search.YearFrom,
+		"yearTo":         &search.YearTo,
+		"ownerId":        &search.OwnerID,
+		"excludeOwnerId": &search.ExcludeOwnerID,
+		"limit":          &search.Limit,
+	}
+	for name, dst := range intParams {
+		raw := q.Get(name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid "+name)
 			return
-		} else {
-			writeError(w, http.StatusBadRequest, "You gotta provide a title or id to exclude")
+		}
+		*dst = v
+	}
+
+	if search.Limit > maxPageLimit {
+		search.Limit = maxPageLimit
+	}
+	if search.YearFrom > 0 && search.YearTo > 0 && search.YearFrom > search.YearTo {
+		writeError(w, http.StatusBadRequest, "yearFrom cant be after yearTo")
+		return
+	}
+	if search.Sort != "" && !data.IsValidGameSort(search.Sort) {
+		writeError(w, http.StatusBadRequest, "Invalid sort, use id, title, publisher, year or condition (prefix with - for descending)")
+		return
+	}
+
+	if raw := q.Get("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid cursor")
 			return
 		}
+		search.Cursor = cursor
+	}
 
-	case http.MethodPost:
-		gamePost(w, r)
+	page, err := s.games.SearchGames(r.Context(), search)
+	if err != nil {
+		writeDataError(w, err)
 		return
+	}
 
-	default:
-		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	games := make([]any, 0, len(page.Games))
+	for _, g := range page.Games {
+		games = append(games, ownedGameHATEOAS(g))
+	}
+
+	links := map[string]Link{
+		"self": {Href: pageHref(r, q.Get("cursor"))},
+	}
+	if page.Next != nil {
+		links["next"] = Link{Href: pageHref(r, encodeCursor(page.Next))}
+	}
+	if page.Prev != nil {
+		links["prev"] = Link{Href: pageHref(r, encodeCursor(page.Prev))}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"games":  games,
+		"count":  len(games),
+		"limit":  search.Limit,
+		"_links": links,
+	})
+	gr.GetRequests.With(prometheus.Labels{"where": "GET games search"}).Inc()
+}
+
+// pageHref rebuilds the request URL with a different cursor so next/prev links keep every filter.
+func pageHref(r *http.Request, cursor string) string {
+	q := r.URL.Query()
+	q.Del("cursor")
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	if len(q) == 0 {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + q.Encode()
+}
+
+func encodeCursor(c *data.GameCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (*data.GameCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c data.GameCursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+	if c.ID <= 0 {
+		return nil, fmt.Errorf("cursor is missing an id")
+	}
+	return &c, nil
+}
+
+// gameProvenance lists every owner a game has had, starting with whoever listed it.
+func (s *server) gameProvenance(w http.ResponseWriter, r *http.Request, id int) {
+	game, err := s.games.GetOwnedGameBYID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			er.error404.With(prometheus.Labels{"where": "GET game provenance, GAME NOT FOUND"}).Inc()
+		}
+		writeDataError(w, err)
+		return
+	}
+
+	transfers, err := s.games.GetGameProvenance(r.Context(), id)
+	if err != nil {
+		writeDataError(w, err)
 		return
 	}
+
+	chain := make([]any, 0, len(transfers))
+	for _, t := range transfers {
+		links := map[string]Link{}
+		if t.FromUserID != nil {
+			links["from"] = Link{Href: fmt.Sprintf("/users/%d", *t.FromUserID)}
+		}
+		if t.ToUserID != nil {
+			links["to"] = Link{Href: fmt.Sprintf("/users/%d", *t.ToUserID)}
+		}
+		if t.OfferID != nil {
+			links["offer"] = Link{Href: fmt.Sprintf("/offers/%d", *t.OfferID)}
+		}
+		chain = append(chain, map[string]any{
+			"transferId":    t.TransferID,
+			"fromUserId":    t.FromUserID,
+			"toUserId":      t.ToUserID,
+			"offerId":       t.OfferID,
+			"transferredAt": t.TransferredAt.UTC().Format(time.RFC3339),
+			"_links":        links,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"gameId":         game.ID,
+		"ownerUserId":    game.OwnerUserID,
+		"previousOwners": game.PreviousOwners,
+		"transfers":      chain,
+		"_links": map[string]Link{
+			"self": {Href: fmt.Sprintf("/games/%d/provenance", game.ID)},
+			"game": {Href: fmt.Sprintf("/games/%d", game.ID)},
+		},
+	})
+	gr.GetRequests.With(prometheus.Labels{"where": "GET game provenance"}).Inc()
 }
 
-func gameByIDHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := parseGameID(r.URL.Path)
+func (s *server) gameByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id, sub, err := parseGamePath(r.URL.Path)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	switch sub {
+	case "":
+	case "provenance":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.gameProvenance(w, r, id)
+		return
+	default:
+		writeError(w, http.StatusNotFound, "Unknown game route")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPatch, http.MethodDelete:
+		if _, ok := requireOwner(w, r, id, s.gameOwnerID); !ok {
+			return
+		}
+		// A game that is promised to someone or in the post can't change under them
+		busy, err := s.trades.GamesInActiveTrade(r.Context(), []int{id})
+		if err != nil {
+			writeDataError(w, err)
+			return
+		}
+		if len(busy) > 0 {
+			writeError(w, http.StatusConflict, "Game is in an active trade")
+			return
+		}
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		gameGetByID(w, r, id)
+		s.gameGetByID(w, r, id)
 	case http.MethodPut:
-		gamePut(w, r, id)
+		s.gamePut(w, r, id)
 	case http.MethodDelete:
-		gameDelete(w, r, id)
+		s.gameDelete(w, r, id)
 	case http.MethodPatch:
-		gamePatch(w, r, id)
+		s.gamePatch(w, r, id)
 	default:
 		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
-func gamePut(w http.ResponseWriter, r *http.Request, id int) {
+func (s *server) gamePut(w http.ResponseWriter, r *http.Request, id int) {
 	var game data.GamePutRequest
 	if err := readJSON(r, &game); err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
@@ -739,22 +2855,24 @@ func gamePut(w http.ResponseWriter, r *http.Request, id int) {
 		writeError(w, http.StatusBadRequest, "MISSING REQUIRED FIELDS >:( ")
 		return
 	}
+	game.Platform = strings.TrimSpace(game.Platform)
+	if utf8.RuneCountInString(game.Platform) > data.MaxPlatformLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("platform can be at most %d characters", data.MaxPlatformLength))
+		return
+	}
 
-	if err := data.UpdateFullGame(id, game); err != nil {
-		if err.Error() == "game not found in database" {
-			writeError(w, http.StatusNotFound, err.Error())
-			ufg := Error404(register)
-			ufg.error404.With(prometheus.Labels{"where": "Put Update full game, GAME NOT FOUND"}).Inc()
-			return
+	if err := s.games.UpdateFullGame(r.Context(), id, game); err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			er.error404.With(prometheus.Labels{"where": "Put Update full game, GAME NOT FOUND"}).Inc()
 		}
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeDataError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func userPut(w http.ResponseWriter, r *http.Request, id int) {
+func (s *server) userPut(w http.ResponseWriter, r *http.Request, id int) {
 	var user data.UserPutRequest
 	if err := readJSON(r, &user); err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
@@ -766,80 +2884,96 @@ func userPut(w http.ResponseWriter, r *http.Request, id int) {
 		return
 	}
 
-	if err := data.UpdateUsername(id, user.Username); err != nil {
-		if err.Error() == "user not found in database" {
-			writeError(w, http.StatusNotFound, err.Error())
-			uu := Error404(register)
-			uu.error404.With(prometheus.Labels{"where": "Put Update user,USERNAME NOT FOUND"}).Inc()
-			return
+	if err := s.users.UpdateUsername(r.Context(), id, user.Username); err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			er.error404.With(prometheus.Labels{"where": "Put Update user,USERNAME NOT FOUND"}).Inc()
 		}
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeDataError(w, err)
 		return
 	}
 
-	if err := data.UpdateStreetAddress(id, user.StreetAddress); err != nil {
-		if err.Error() == "user not found in database" {
-			writeError(w, http.StatusNotFound, err.Error())
-			usa := Error404(register)
-			usa.error404.With(prometheus.Labels{"where": "put update street address, ADDRESS NOT FOUND"}).Inc()
-			return
+	if err := s.users.UpdateStreetAddress(r.Context(), id, user.StreetAddress); err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			er.error404.With(prometheus.Labels{"where": "put update street address, ADDRESS NOT FOUND"}).Inc()
 		}
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeDataError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func userDelete(w http.ResponseWriter, r *http.Request, id int) {
-	err := data.DeleteUserByID(id)
+func (s *server) userDelete(w http.ResponseWriter, r *http.Request, id int) {
+	err := s.users.DeleteUserByID(r.Context(), id)
 	if err != nil {
-		if err.Error() == "user not found in database" {
-			writeError(w, http.StatusNotFound, err.Error())
+		if errors.Is(err, data.ErrNotFound) {
 			er.error404.With(prometheus.Labels{"where": "userDelete, USER NOT FOUND"}).Inc()
-			return
 		}
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeDataError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func gameDelete(w http.ResponseWriter, r *http.Request, id int) {
-	err := data.DeleteGameByID(id)
+func (s *server) gameDelete(w http.ResponseWriter, r *http.Request, id int) {
+	err := s.games.DeleteGameByID(r.Context(), id)
 	if err != nil {
-		if err.Error() == "game not found in database" {
-			writeError(w, http.StatusNotFound, err.Error())
+		if errors.Is(err, data.ErrNotFound) {
 			er.error404.With(prometheus.Labels{"where": "Delete Game, GAME NOT FOUND"}).Inc()
-			return
 		}
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeDataError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func userPatch(w http.ResponseWriter, r *http.Request, id int) {
+func (s *server) userPatch(w http.ResponseWriter, r *http.Request, id int) {
 	var Patch data.UserPatch
 	if err := readJSON(r, &Patch); err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	// Check the password change before writing anything so a bad currentPassword doesnt leave a half applied patch
+	if Patch.Password != nil {
+		if *Patch.Password == "" {
+			writeError(w, http.StatusBadRequest, "Cant change to a password that hasnt been provided dude")
+			return
+		}
+		if msg := data.CheckPasswordPolicy(*Patch.Password); msg != "" {
+			writeError(w, http.StatusBadRequest, msg)
+			return
+		}
+		if Patch.CurrentPassword == nil || *Patch.CurrentPassword == "" {
+			writeError(w, http.StatusBadRequest, "currentPassword is required to change your password")
+			return
+		}
+		matches, err := s.users.CheckUserPassword(r.Context(), id, *Patch.CurrentPassword)
+		if err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				er.error404.With(prometheus.Labels{"where": "userPatch, USER NOT FOUND"}).Inc()
+			}
+			writeDataError(w, err)
+			return
+		}
+		if !matches {
+			writeError(w, http.StatusForbidden, "currentPassword is wrong")
+			return
+		}
+	}
+
 	if Patch.Username != nil {
 		if *Patch.Username == "" {
 			writeError(w, http.StatusBadRequest, "Username is needed guy")
 			return
 		}
-		if err := data.UpdateUsername(id, *Patch.Username); err != nil {
-			if err.Error() == "user not found in database" {
-				writeError(w, http.StatusNotFound, err.Error())
+		if err := s.users.UpdateUsername(r.Context(), id, *Patch.Username); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
 				er.error404.With(prometheus.Labels{"where": "userPatch, USER NOT FOUND"}).Inc()
-				return
 			}
-			writeError(w, http.StatusInternalServerError, err.Error())
+			writeDataError(w, err)
 			return
 		}
 	}
@@ -849,33 +2983,24 @@ func userPatch(w http.ResponseWriter, r *http.Request, id int) {
 			writeError(w, http.StatusBadRequest, "Cant change to an address that hasnt been provided dude")
 			return
 		}
-		if err := data.UpdateStreetAddress(id, *Patch.StreetAddress); err != nil {
-			if err.Error() == "user not found in database" {
-				writeError(w, http.StatusNotFound, err.Error())
+		if err := s.users.UpdateStreetAddress(r.Context(), id, *Patch.StreetAddress); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
 				er.error404.With(prometheus.Labels{"where": "userPatch, USER NOT FOUND"}).Inc()
-				return
 			}
-			writeError(w, http.StatusInternalServerError, err.Error())
+			writeDataError(w, err)
 			return
 		}
 	}
 
 	if Patch.Password != nil {
-		if *Patch.Password == "" {
-			writeError(w, http.StatusBadRequest, "Cant change to a password that hasnt been provided dude")
-			return
-		}
-		if err := data.UpdateUserPassword(id, *Patch.Password); err != nil {
-			if err.Error() == "user not found in database (passwordCheck)" {
-				writeError(w, http.StatusNotFound, err.Error())
-				uu := Error404(register)
-				uu.error404.With(prometheus.Labels{"where": "userPatch, USER NOT FOUND"})
-				return
+		if err := s.users.UpdateUserPassword(r.Context(), id, *Patch.Password); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				er.error404.With(prometheus.Labels{"where": "userPatch, USER NOT FOUND"}).Inc()
 			}
-			writeError(w, http.StatusInternalServerError, err.Error())
+			writeDataError(w, err)
 			return
 		}
-		var userEmail = data.GetEmailWithID(id)
+		var userEmail = s.users.GetEmailWithID(r.Context(), id)
 		if userEmail == "" {
 			log.Println("user email not found in database")
 		} else {
@@ -899,7 +3024,7 @@ func userPatch(w http.ResponseWriter, r *http.Request, id int) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func gamePatch(w http.ResponseWriter, r *http.Request, id int) {
+func (s *server) gamePatch(w http.ResponseWriter, r *http.Request, id int) {
 	var Patch data.GamePatch
 	if err := readJSON(r, &Patch); err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
@@ -913,14 +3038,11 @@ func gamePatch(w http.ResponseWriter, r *http.Request, id int) {
 			writeError(w, http.StatusBadRequest, "No title Provided")
 			return
 		}
-		if err := data.UpdateGameTitle(id, *Patch.Title); err != nil {
-			if err.Error() == "game not found in database" {
-				writeError(w, http.StatusNotFound, err.Error())
-				uu := Error404(register)
-				uu.error404.With(prometheus.Labels{"where": "gamePatch, GAME NOT FOUND"})
-				return
+		if err := s.games.UpdateGameTitle(r.Context(), id, *Patch.Title); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				er.error404.With(prometheus.Labels{"where": "gamePatch, GAME NOT FOUND"}).Inc()
 			}
-			writeError(w, http.StatusInternalServerError, err.Error())
+			writeDataError(w, err)
 			return
 		}
 		updated = true
@@ -931,14 +3053,11 @@ func gamePatch(w http.ResponseWriter, r *http.Request, id int) {
 			writeError(w, http.StatusBadRequest, "No condition Provided")
 			return
 		}
-		if err := data.UpdateGameCondition(id, *Patch.Condition); err != nil {
-			if err.Error() == "game not found in database" {
-				writeError(w, http.StatusNotFound, err.Error())
-				ugp := Error404(register)
-				ugp.error404.With(prometheus.Labels{"where": "gamePatch condition, GAME NOT FOUND"})
-				return
+		if err := s.games.UpdateGameCondition(r.Context(), id, *Patch.Condition); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				er.error404.With(prometheus.Labels{"where": "gamePatch condition, GAME NOT FOUND"}).Inc()
 			}
-			writeError(w, http.StatusInternalServerError, err.Error())
+			writeDataError(w, err)
 			return
 		}
 		updated = true
@@ -949,14 +3068,28 @@ func gamePatch(w http.ResponseWriter, r *http.Request, id int) {
 			writeError(w, http.StatusBadRequest, "No description Provided")
 			return
 		}
-		if err := data.UpdateGameDescription(id, *Patch.Description); err != nil {
-			if err.Error() == "game not found in database" {
-				writeError(w, http.StatusNotFound, err.Error())
-				ugd := Error404(register)
-				ugd.error404.With(prometheus.Labels{"where": "gamePatch description, GAME NOT FOUND"})
-				return
+		if err := s.games.UpdateGameDescription(r.Context(), id, *Patch.Description); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				er.error404.With(prometheus.Labels{"where": "gamePatch description, GAME NOT FOUND"}).Inc()
+			}
+			writeDataError(w, err)
+			return
+		}
+		updated = true
+	}
+
+	// Unlike the others platform can be cleared, older listings don't have one either
+	if Patch.Platform != nil {
+		platform := strings.TrimSpace(*Patch.Platform)
+		if utf8.RuneCountInString(platform) > data.MaxPlatformLength {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("platform can be at most %d characters", data.MaxPlatformLength))
+			return
+		}
+		if err := s.games.UpdateGamePlatform(r.Context(), id, platform); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				er.error404.With(prometheus.Labels{"where": "gamePatch platform, GAME NOT FOUND"}).Inc()
 			}
-			writeError(w, http.StatusInternalServerError, err.Error())
+			writeDataError(w, err)
 			return
 		}
 		updated = true
@@ -970,22 +3103,28 @@ func gamePatch(w http.ResponseWriter, r *http.Request, id int) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func parseGameID(path string) (int, error) {
-	raw := strings.TrimPrefix(path, "/games/")
-	raw = strings.Trim(raw, "/")
-	return strconv.Atoi(raw)
+// parseGamePath splits /games/{id}/{sub} into the game ID and the optional sub-resource.
+func parseGamePath(path string) (int, string, error) {
+	raw := strings.Trim(strings.TrimPrefix(path, "/games/"), "/")
+	idPart, sub, _ := strings.Cut(raw, "/")
+	id, err := strconv.Atoi(idPart)
+	return id, sub, err
 }
 
-func parseUserID(path string) (int, error) {
-	raw := strings.TrimPrefix(path, "/users/")
-	raw = strings.Trim(raw, "/")
-	return strconv.Atoi(raw)
+// parseUserPath splits /users/{id}/{sub...} into the user ID and whatever follows it.
+func parseUserPath(path string) (int, string, error) {
+	raw := strings.Trim(strings.TrimPrefix(path, "/users/"), "/")
+	idPart, sub, _ := strings.Cut(raw, "/")
+	id, err := strconv.Atoi(idPart)
+	return id, sub, err
 }
 
-func parseOfferID(path string) (int, error) {
-	raw := strings.TrimPrefix(path, "/offers/")
-	raw = strings.Trim(raw, "/")
-	return strconv.Atoi(raw)
+// parseOfferPath splits /offers/{id}/{sub} into the offer ID and the optional sub-resource.
+func parseOfferPath(path string) (int, string, error) {
+	raw := strings.Trim(strings.TrimPrefix(path, "/offers/"), "/")
+	idPart, sub, _ := strings.Cut(raw, "/")
+	id, err := strconv.Atoi(idPart)
+	return id, sub, err
 }
 
 func postRequests(register prometheus.Registerer) *postCount {
@@ -1028,3 +3167,40 @@ func Error404(register prometheus.Registerer) *NotFoundCount {
 	register.MustRegister(ntc.error404)
 	return ntc
 }
+
+func Error403(register prometheus.Registerer) *ForbiddenCount {
+	fbc := &ForbiddenCount{
+		prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "total_of_403_errors",
+			Help: "Tracks requests that were refused because the caller doesnt own the resource",
+		},
+			[]string{"where"},
+		),
+	}
+	register.MustRegister(fbc.Forbidden)
+	return fbc
+}
+
+func adminActions(register prometheus.Registerer) *adminCount {
+	adc := &adminCount{
+		prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "total_of_admin_actions",
+			Help: "Counts moderator and admin actions by type",
+		},
+			[]string{"action"},
+		),
+	}
+	register.MustRegister(adc.AdminActions)
+	return adc
+}
+
+func offersExpired(register prometheus.Registerer) *expiredCount {
+	exc := &expiredCount{
+		prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "total_expired_offers",
+			Help: "Counts trade offers the sweeper moved to expired",
+		}),
+	}
+	register.MustRegister(exc.ExpiredOffers)
+	return exc
+}
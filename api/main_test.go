@@ -0,0 +1,1305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gameAPI/data"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testPassword = "penguin123"
+
+func TestMain(m *testing.M) {
+	emailSecret = []byte("test-email-token-secret-0123456789")
+	os.Exit(m.Run())
+}
+
+// testAPI is the whole api served in-process over one store.
+type testAPI struct {
+	store   data.Store
+	handler http.Handler
+	// db is the SQLite database under store, nil for the memory store
+	db *sql.DB
+}
+
+// forEachStore runs fn against a fresh api for every backend.
+func forEachStore(t *testing.T, fn func(t *testing.T, api *testAPI)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, newTestAPI(t, data.NewMemoryStore()))
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		store, db := newSQLiteStore(t)
+		api := newTestAPI(t, store)
+		api.db = db
+		fn(t, api)
+	})
+}
+
+// newSQLiteStore is an empty migrated database that lives as long as the test.
+func newSQLiteStore(t *testing.T) (*data.SQLStore, *sql.DB) {
+	t.Helper()
+	store, db := newUnmigratedSQLiteStore(t)
+	if _, err := store.MigrateUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return store, db
+}
+
+// newUnmigratedSQLiteStore is an in-memory database without a single table.
+func newUnmigratedSQLiteStore(t *testing.T) (*data.SQLStore, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:?_pragma=foreign_keys(1)&_txlock=immediate&_time_format=sqlite&_timezone=UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: gets its own database, keep it to one
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	store, err := data.NewSQLStore(db, "sqlite", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, db
+}
+
+func newTestAPI(t *testing.T, store data.Store) *testAPI {
+	// The mail throttles are package state, every test starts with them empty
+	resetMails = newMailThrottle()
+	verificationMails = newMailThrottle()
+	s := newServer(store)
+	// Registered after the store's cleanup so it runs first, before the database is closed
+	t.Cleanup(s.notifying.Wait)
+	return &testAPI{store: store, handler: s.routes()}
+}
+
+var anonymous = identity{}
+
+func userID(id int) identity {
+	return identity{UserID: id}
+}
+
+func admin(id int) identity {
+	return identity{UserID: id, Role: data.RoleAdmin}
+}
+
+func moderator(id int) identity {
+	return identity{UserID: id, Role: data.RoleModerator}
+}
+
+// do sends one request as who. body is sent as is when it is a string and as JSON otherwise.
+func (a *testAPI) do(t *testing.T, who identity, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	return a.doContext(t, context.Background(), who, method, path, body)
+}
+
+// doContext is do with the request running under ctx.
+func (a *testAPI) doContext(t *testing.T, ctx context.Context, who identity, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("encoding body: %v", err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	req := httptest.NewRequestWithContext(ctx, method, path, reader)
+	if who.UserID != 0 {
+		req.Header.Set("X-User-ID", strconv.Itoa(who.UserID))
+	}
+	if who.Role != "" {
+		req.Header.Set("X-User-Role", who.Role)
+	}
+	rec := httptest.NewRecorder()
+	a.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect sends a request and fails the test unless it gets status back.
+func (a *testAPI) expect(t *testing.T, status int, who identity, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := a.do(t, who, method, path, body)
+	if rec.Code != status {
+		t.Fatalf("%s %s: got %d, want %d: %s", method, path, rec.Code, status, rec.Body.String())
+	}
+	return rec
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+// intField reads a JSON number out of a decoded object.
+func intField(t *testing.T, obj map[string]any, key string) int {
+	t.Helper()
+	v, ok := obj[key].(float64)
+	if !ok {
+		t.Fatalf("%s is %v, want a number", key, obj[key])
+	}
+	return int(v)
+}
+
+func (a *testAPI) createUser(t *testing.T, name string) int {
+	t.Helper()
+	rec := a.expect(t, http.StatusCreated, anonymous, http.MethodPost, "/users", data.NewUserRequest{
+		Username:      name,
+		Password:      testPassword,
+		Email:         name + "@example.com",
+		StreetAddress: "1 Ice Floe",
+	})
+	return intField(t, decode[map[string]any](t, rec), "id")
+}
+
+func (a *testAPI) createGame(t *testing.T, owner int, title string) int {
+	t.Helper()
+	rec := a.expect(t, http.StatusCreated, userID(owner), http.MethodPost, "/games", data.GameCreateRequest{
+		Title:       title,
+		Publisher:   "Nintendo",
+		Description: "Cartridge only",
+		Platform:    "N64",
+		Year:        1998,
+		Condition:   "good",
+	})
+	return intField(t, decode[map[string]any](t, rec), "id")
+}
+
+func (a *testAPI) createOffer(t *testing.T, requester, requested, offered int) int {
+	t.Helper()
+	rec := a.expect(t, http.StatusCreated, userID(requester), http.MethodPost, "/offers", data.TradeOfferCreateRequest{
+		GameRequestedIDs: []int{requested},
+		GameOfferedIDs:   []int{offered},
+	})
+	return intField(t, decode[map[string]any](t, rec), "offerId")
+}
+
+// trade is two users with a game each and a pending offer from bob for alice's game.
+type trade struct {
+	alice, bob         int
+	aliceGame, bobGame int
+	offer              int
+}
+
+func (a *testAPI) newTrade(t *testing.T) trade {
+	t.Helper()
+	var tr trade
+	tr.alice = a.createUser(t, "alice")
+	tr.bob = a.createUser(t, "bob")
+	tr.aliceGame = a.createGame(t, tr.alice, "Ocarina of Time")
+	tr.bobGame = a.createGame(t, tr.bob, "Super Mario 64")
+	tr.offer = a.createOffer(t, tr.bob, tr.aliceGame, tr.bobGame)
+	return tr
+}
+
+func (a *testAPI) accept(t *testing.T, tr trade) {
+	t.Helper()
+	a.expect(t, http.StatusNoContent, userID(tr.alice), http.MethodPatch, fmt.Sprintf("/offers/%d", tr.offer),
+		map[string]string{"currentStatus": "accepted"})
+}
+
+// complete takes an accepted trade through shipping and receipt on both sides.
+func (a *testAPI) complete(t *testing.T, tr trade) {
+	t.Helper()
+	for _, party := range []int{tr.alice, tr.bob} {
+		a.expect(t, http.StatusOK, userID(party), http.MethodPost, fmt.Sprintf("/offers/%d/shipment", tr.offer),
+			data.TradeShipmentRequest{TrackingNumber: "TRACK" + strconv.Itoa(party)})
+	}
+	for _, party := range []int{tr.alice, tr.bob} {
+		a.expect(t, http.StatusOK, userID(party), http.MethodPost, fmt.Sprintf("/offers/%d/receipt", tr.offer), nil)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		alice := api.createUser(t, "alice")
+		bob := api.createUser(t, "bob")
+		path := fmt.Sprintf("/users/%d", alice)
+
+		t.Run("create", func(t *testing.T) {
+			api.expect(t, http.StatusBadRequest, anonymous, http.MethodPost, "/users", data.NewUserRequest{Username: "carol"})
+			api.expect(t, http.StatusBadRequest, anonymous, http.MethodPost, "/users", data.NewUserRequest{
+				Username: "carol", Password: "short", Email: "carol@example.com", StreetAddress: "2 Ice Floe",
+			})
+			api.expect(t, http.StatusBadRequest, anonymous, http.MethodPost, "/users", data.NewUserRequest{
+				Username: "carol", Password: testPassword, Email: "not an email", StreetAddress: "2 Ice Floe",
+			})
+			api.expect(t, http.StatusBadRequest, anonymous, http.MethodPost, "/users", `{"username":`)
+			api.expect(t, http.StatusConflict, anonymous, http.MethodPost, "/users", data.NewUserRequest{
+				Username: "alice2", Password: testPassword, Email: "ALICE@example.com", StreetAddress: "2 Ice Floe",
+			})
+			api.expect(t, http.StatusMethodNotAllowed, anonymous, http.MethodGet, "/users", nil)
+		})
+
+		t.Run("get", func(t *testing.T) {
+			got := decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, path, nil))
+			if got["username"] != "alice" || got["reputation"] == nil {
+				t.Fatalf("got %v", got)
+			}
+			api.expect(t, http.StatusNotFound, anonymous, http.MethodGet, "/users/999", nil)
+			api.expect(t, http.StatusBadRequest, anonymous, http.MethodGet, "/users/abc", nil)
+			api.expect(t, http.StatusNotFound, anonymous, http.MethodGet, path+"/nope", nil)
+		})
+
+		t.Run("put", func(t *testing.T) {
+			body := data.UserPutRequest{Username: "alice-renamed", StreetAddress: "3 Ice Floe"}
+			api.expect(t, http.StatusUnauthorized, anonymous, http.MethodPut, path, body)
+			api.expect(t, http.StatusForbidden, userID(bob), http.MethodPut, path, body)
+			api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPut, path, data.UserPutRequest{Username: "x"})
+			api.expect(t, http.StatusNoContent, userID(alice), http.MethodPut, path, body)
+			api.expect(t, http.StatusNoContent, admin(bob), http.MethodPut, path, body)
+			api.expect(t, http.StatusNotFound, admin(bob), http.MethodPut, "/users/999", body)
+
+			got := decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, path, nil))
+			if got["username"] != "alice-renamed" || got["streetAddress"] != "3 Ice Floe" {
+				t.Fatalf("got %v", got)
+			}
+		})
+
+		t.Run("patch", func(t *testing.T) {
+			api.expect(t, http.StatusUnauthorized, anonymous, http.MethodPatch, path, map[string]string{"username": "a"})
+			api.expect(t, http.StatusForbidden, userID(bob), http.MethodPatch, path, map[string]string{"username": "a"})
+			api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPatch, path, map[string]string{})
+			api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPatch, path, map[string]string{"username": ""})
+			api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPatch, path, map[string]string{"streetAddress": ""})
+			api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPatch, path, map[string]string{"unknown": "field"})
+			api.expect(t, http.StatusNoContent, userID(alice), http.MethodPatch, path, map[string]string{"username": "alice"})
+			api.expect(t, http.StatusNotFound, admin(bob), http.MethodPatch, "/users/999", map[string]string{"username": "a"})
+		})
+
+		t.Run("delete", func(t *testing.T) {
+			carol := api.createUser(t, "carol")
+			carolPath := fmt.Sprintf("/users/%d", carol)
+			api.expect(t, http.StatusUnauthorized, anonymous, http.MethodDelete, carolPath, nil)
+			api.expect(t, http.StatusForbidden, userID(bob), http.MethodDelete, carolPath, nil)
+			api.expect(t, http.StatusNoContent, userID(carol), http.MethodDelete, carolPath, nil)
+			api.expect(t, http.StatusNotFound, anonymous, http.MethodGet, carolPath, nil)
+			api.expect(t, http.StatusNotFound, admin(bob), http.MethodDelete, carolPath, nil)
+			api.expect(t, http.StatusMethodNotAllowed, userID(alice), http.MethodPost, path, nil)
+		})
+	})
+}
+
+func TestPasswordChange(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		alice := api.createUser(t, "alice")
+		path := fmt.Sprintf("/users/%d", alice)
+		ctx := context.Background()
+		before, err := api.store.GetTokenVersion(ctx, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPatch, path, map[string]string{"password": "newpenguin456"})
+		api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPatch, path,
+			map[string]string{"password": "weak", "currentPassword": testPassword})
+		api.expect(t, http.StatusForbidden, userID(alice), http.MethodPatch, path,
+			map[string]string{"password": "newpenguin456", "currentPassword": "wrong123"})
+		api.expect(t, http.StatusNoContent, userID(alice), http.MethodPatch, path,
+			map[string]string{"password": "newpenguin456", "currentPassword": testPassword})
+
+		if id := api.store.VerifyUser(ctx, "alice@example.com", testPassword); id != -1 {
+			t.Fatalf("old password still logs in as %d", id)
+		}
+		if id := api.store.VerifyUser(ctx, "alice@example.com", "newpenguin456"); id != alice {
+			t.Fatalf("new password logs in as %d, want %d", id, alice)
+		}
+		after, err := api.store.GetTokenVersion(ctx, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after <= before {
+			t.Fatalf("token version went from %d to %d, old tokens still work", before, after)
+		}
+	})
+}
+
+func TestPasswordReset(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		ctx := context.Background()
+		alice := api.createUser(t, "alice")
+
+		t.Run("request", func(t *testing.T) {
+			// Registered or not the answer is the same
+			for _, email := range []string{"alice@example.com", "nobody@example.com", "alice@example.com"} {
+				api.expect(t, http.StatusAccepted, anonymous, http.MethodPost, "/users/password-reset", data.PasswordResetRequest{Email: email})
+			}
+			api.expect(t, http.StatusBadRequest, anonymous, http.MethodPost, "/users/password-reset", data.PasswordResetRequest{})
+			api.expect(t, http.StatusMethodNotAllowed, anonymous, http.MethodGet, "/users/password-reset", nil)
+		})
+
+		t.Run("confirm", func(t *testing.T) {
+			token := "reset-token-for-alice"
+			err := api.store.CreatePasswordReset(ctx, alice, data.HashToken(token), time.Now().Add(time.Hour), time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Another one inside the cooldown is refused by the store, whichever instance asks
+			err = api.store.CreatePasswordReset(ctx, alice, data.HashToken("second"), time.Now().Add(time.Hour), time.Now().Add(-mailCooldown))
+			if !errors.Is(err, data.ErrTooSoon) {
+				t.Fatalf("got %v, want data.ErrTooSoon", err)
+			}
+
+			path := "/users/password-reset/confirm"
+			api.expect(t, http.StatusBadRequest, anonymous, http.MethodPost, path, data.PasswordResetConfirm{Token: token})
+			api.expect(t, http.StatusBadRequest, anonymous, http.MethodPost, path, data.PasswordResetConfirm{Token: token, Password: "weak"})
+			api.expect(t, http.StatusBadRequest, anonymous, http.MethodPost, path, data.PasswordResetConfirm{Token: "wrong", Password: "reset12345"})
+			api.expect(t, http.StatusNoContent, anonymous, http.MethodPost, path, data.PasswordResetConfirm{Token: token, Password: "reset12345"})
+			api.expect(t, http.StatusBadRequest, anonymous, http.MethodPost, path, data.PasswordResetConfirm{Token: token, Password: "again12345"})
+			api.expect(t, http.StatusMethodNotAllowed, anonymous, http.MethodGet, path, nil)
+
+			if id := api.store.VerifyUser(ctx, "alice@example.com", "reset12345"); id != alice {
+				t.Fatalf("reset password logs in as %d, want %d", id, alice)
+			}
+		})
+	})
+}
+
+func TestMailThrottle(t *testing.T) {
+	throttle := newMailThrottle()
+	now := time.Unix(1_700_000_000, 0)
+
+	if !throttle.allow("alice@example.com", now) {
+		t.Fatal("first mail was throttled")
+	}
+	// Same address however it is typed
+	if throttle.allow(" Alice@Example.com ", now.Add(time.Second)) {
+		t.Fatal("second mail inside the cooldown was allowed")
+	}
+	if !throttle.allow("bob@example.com", now.Add(time.Second)) {
+		t.Fatal("another address was throttled")
+	}
+	if !throttle.allow("alice@example.com", now.Add(mailCooldown)) {
+		t.Fatal("mail after the cooldown was throttled")
+	}
+
+	// Expired addresses are forgotten, the newest cooldown is kept
+	for i := range 100 {
+		throttle.allow(fmt.Sprintf("user%d@example.com", i), now.Add(mailCooldown))
+	}
+	later := now.Add(2*mailCooldown + time.Second)
+	if !throttle.allow("carol@example.com", later.Add(time.Second-mailCooldown)) {
+		t.Fatal("new address was throttled")
+	}
+	throttle.allow("dave@example.com", later)
+	if len(throttle.sent) != 2 || len(throttle.order) != 2 {
+		t.Fatalf("throttle kept %d addresses and %d sends, want 2", len(throttle.sent), len(throttle.order))
+	}
+	if throttle.allow("carol@example.com", later) {
+		t.Fatal("pruning dropped an address still in its cooldown")
+	}
+}
+
+func verificationToken(t *testing.T, userID int, email string, expires time.Time) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":     userID,
+		"email":   email,
+		"purpose": emailVerificationPurpose,
+		"exp":     expires.Unix(),
+	}).SignedString(emailSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestLoadEmailTokenSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		ok     bool
+	}{
+		{"unset", "", false},
+		{"too short", strings.Repeat("x", minEmailTokenSecret-1), false},
+		{"long enough", strings.Repeat("x", minEmailTokenSecret), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("EMAIL_TOKEN_SECRET", tt.secret)
+			// The auth service's secret is no fallback any more
+			t.Setenv("JWT_SECRET", strings.Repeat("y", 64))
+			secret, err := loadEmailTokenSecret()
+			if (err == nil) != tt.ok {
+				t.Fatalf("got err %v, want ok=%v", err, tt.ok)
+			}
+			if tt.ok && string(secret) != tt.secret {
+				t.Fatalf("got secret %q", secret)
+			}
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		alice := api.createUser(t, "alice")
+		bob := api.createUser(t, "bob")
+		ctx := context.Background()
+
+		api.expect(t, http.StatusBadRequest, anonymous, http.MethodGet, "/users/verify", nil)
+		api.expect(t, http.StatusBadRequest, anonymous, http.MethodGet, "/users/verify?token=garbage", nil)
+		expired := verificationToken(t, alice, "alice@example.com", time.Now().Add(-time.Minute))
+		api.expect(t, http.StatusBadRequest, anonymous, http.MethodGet, "/users/verify?token="+expired, nil)
+		api.expect(t, http.StatusMethodNotAllowed, anonymous, http.MethodDelete, "/users/verify", nil)
+
+		// A link for an address the account no longer has is refused
+		stale := verificationToken(t, alice, "old@example.com", time.Now().Add(time.Hour))
+		api.expect(t, http.StatusBadRequest, anonymous, http.MethodGet, "/users/verify?token="+stale, nil)
+
+		token := verificationToken(t, alice, "alice@example.com", time.Now().Add(time.Hour))
+		got := decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, "/users/verify?token="+token, nil))
+		if got["_links"] == nil {
+			t.Fatalf("got %v", got)
+		}
+		token = verificationToken(t, bob, "bob@example.com", time.Now().Add(time.Hour))
+		api.expect(t, http.StatusOK, anonymous, http.MethodPost, "/users/verify", data.EmailVerificationRequest{Token: token})
+
+		for _, id := range []int{alice, bob} {
+			if verified, err := api.store.IsEmailVerified(ctx, id); err != nil || !verified {
+				t.Fatalf("user %d verified=%v err=%v", id, verified, err)
+			}
+		}
+
+		api.expect(t, http.StatusAccepted, anonymous, http.MethodPost, "/users/verify/resend", data.PasswordResetRequest{Email: "alice@example.com"})
+		api.expect(t, http.StatusAccepted, anonymous, http.MethodPost, "/users/verify/resend", data.PasswordResetRequest{Email: "nobody@example.com"})
+		api.expect(t, http.StatusBadRequest, anonymous, http.MethodPost, "/users/verify/resend", data.PasswordResetRequest{})
+		api.expect(t, http.StatusMethodNotAllowed, anonymous, http.MethodGet, "/users/verify/resend", nil)
+	})
+}
+
+func TestGames(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		alice := api.createUser(t, "alice")
+		bob := api.createUser(t, "bob")
+		game := api.createGame(t, alice, "Ocarina of Time")
+		path := fmt.Sprintf("/games/%d", game)
+
+		t.Run("create", func(t *testing.T) {
+			body := data.GameCreateRequest{Title: "Halo 2", Publisher: "Bungie", Description: "Disc", Year: 2004, Condition: "good"}
+			api.expect(t, http.StatusUnauthorized, anonymous, http.MethodPost, "/games", body)
+			api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPost, "/games", data.GameCreateRequest{Title: "Halo 2"})
+			body.Platform = strings.Repeat("x", data.MaxPlatformLength+1)
+			api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPost, "/games", body)
+			// Counted in characters, not bytes
+			body.Platform = strings.Repeat("é", data.MaxPlatformLength)
+			api.expect(t, http.StatusCreated, userID(alice), http.MethodPost, "/games", body)
+			api.expect(t, http.StatusMethodNotAllowed, userID(alice), http.MethodDelete, "/games", nil)
+		})
+
+		t.Run("get", func(t *testing.T) {
+			got := decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, path, nil))
+			if got["title"] != "Ocarina of Time" || got["platform"] != "N64" {
+				t.Fatalf("got %v", got)
+			}
+			api.expect(t, http.StatusNotFound, anonymous, http.MethodGet, "/games/999", nil)
+			api.expect(t, http.StatusBadRequest, anonymous, http.MethodGet, "/games/abc", nil)
+			api.expect(t, http.StatusNotFound, anonymous, http.MethodGet, path+"/nope", nil)
+		})
+
+		t.Run("search", func(t *testing.T) {
+			page := decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, "/games?platform=n64&title=ocarina", nil))
+			if intField(t, page, "count") != 1 {
+				t.Fatalf("got %v", page)
+			}
+			page = decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, "/games?limit=1&sort=-id", nil))
+			links := page["_links"].(map[string]any)
+			if links["next"] == nil {
+				t.Fatalf("no next link in %v", page)
+			}
+			next := links["next"].(map[string]any)["href"].(string)
+			page = decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, next, nil))
+			if intField(t, page, "count") != 1 {
+				t.Fatalf("got %v", page)
+			}
+
+			// title and excludeOwnerId used to return a bare game and a bare array; both are page filters now.
+			page = decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, "/games?title=nothing%20like%20it", nil))
+			if intField(t, page, "count") != 0 {
+				t.Fatalf("got %v", page)
+			}
+			page = decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, fmt.Sprintf("/games?excludeOwnerId=%d", alice), nil))
+			if _, ok := page["games"].([]any); !ok {
+				t.Fatalf("got %v", page)
+			}
+
+			for _, query := range []string{"limit=0", "excludeOwnerId=abc", "yearFrom=abc", "yearFrom=2000&yearTo=1990", "sort=price", "cursor=!!"} {
+				api.expect(t, http.StatusBadRequest, anonymous, http.MethodGet, "/games?"+query, nil)
+			}
+		})
+
+		t.Run("provenance", func(t *testing.T) {
+			got := decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, path+"/provenance", nil))
+			if intField(t, got, "ownerUserId") != alice {
+				t.Fatalf("got %v", got)
+			}
+			api.expect(t, http.StatusNotFound, anonymous, http.MethodGet, "/games/999/provenance", nil)
+			api.expect(t, http.StatusMethodNotAllowed, anonymous, http.MethodPost, path+"/provenance", nil)
+		})
+
+		t.Run("put", func(t *testing.T) {
+			body := data.GamePutRequest{Title: "Majoras Mask", Publisher: "Nintendo", Description: "Boxed", Platform: "N64", Year: 2000, Condition: "mint"}
+			api.expect(t, http.StatusUnauthorized, anonymous, http.MethodPut, path, body)
+			api.expect(t, http.StatusForbidden, userID(bob), http.MethodPut, path, body)
+			api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPut, path, data.GamePutRequest{Title: "x"})
+			api.expect(t, http.StatusNoContent, userID(alice), http.MethodPut, path, body)
+			api.expect(t, http.StatusNotFound, admin(bob), http.MethodPut, "/games/999", body)
+
+			got := decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, path, nil))
+			if got["title"] != "Majoras Mask" || got["condition"] != "mint" {
+				t.Fatalf("got %v", got)
+			}
+		})
+
+		t.Run("patch", func(t *testing.T) {
+			api.expect(t, http.StatusUnauthorized, anonymous, http.MethodPatch, path, map[string]string{"title": "a"})
+			api.expect(t, http.StatusForbidden, userID(bob), http.MethodPatch, path, map[string]string{"title": "a"})
+			api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPatch, path, map[string]string{})
+			api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPatch, path, map[string]string{"title": ""})
+			api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPatch, path, map[string]string{"condition": ""})
+			api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPatch, path, map[string]string{"description": ""})
+			api.expect(t, http.StatusNoContent, userID(alice), http.MethodPatch, path,
+				map[string]string{"title": "Ocarina of Time", "condition": "fair", "description": "Loose", "platform": ""})
+			api.expect(t, http.StatusNoContent, admin(bob), http.MethodPatch, path, map[string]string{"platform": "N64"})
+			api.expect(t, http.StatusNotFound, admin(bob), http.MethodPatch, "/games/999", map[string]string{"title": "a"})
+
+			got := decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, path, nil))
+			if got["condition"] != "fair" || got["description"] != "Loose" || got["platform"] != "N64" {
+				t.Fatalf("got %v", got)
+			}
+		})
+
+		t.Run("delete", func(t *testing.T) {
+			doomed := api.createGame(t, alice, "Doomed")
+			doomedPath := fmt.Sprintf("/games/%d", doomed)
+			api.expect(t, http.StatusUnauthorized, anonymous, http.MethodDelete, doomedPath, nil)
+			api.expect(t, http.StatusForbidden, userID(bob), http.MethodDelete, doomedPath, nil)
+			api.expect(t, http.StatusNoContent, userID(alice), http.MethodDelete, doomedPath, nil)
+			api.expect(t, http.StatusNotFound, anonymous, http.MethodGet, doomedPath, nil)
+			api.expect(t, http.StatusNotFound, admin(bob), http.MethodDelete, doomedPath, nil)
+		})
+	})
+}
+
+func TestGameInActiveTrade(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		tr := api.newTrade(t)
+
+		// A pending offer doesn't hold the game, deleting it voids the offer and the history says why
+		spare := api.createGame(t, tr.alice, "Spare")
+		pending := api.createOffer(t, tr.bob, spare, tr.bobGame)
+		api.expect(t, http.StatusNoContent, userID(tr.alice), http.MethodDelete, fmt.Sprintf("/games/%d", spare), nil)
+		got := decode[map[string]any](t, api.expect(t, http.StatusOK, userID(tr.bob), http.MethodGet, fmt.Sprintf("/offers/%d", pending), nil))
+		if got["currentStatus"] != "void" {
+			t.Fatalf("got %v", got)
+		}
+		history := decode[map[string]any](t, api.expect(t, http.StatusOK, userID(tr.bob), http.MethodGet, fmt.Sprintf("/offers/%d/history", pending), nil))
+		events := history["events"].([]any)
+		last := events[len(events)-1].(map[string]any)
+		if len(events) != 2 || last["toStatus"] != "void" || !strings.Contains(fmt.Sprint(last["note"]), "deleted") {
+			t.Fatalf("got %v", history)
+		}
+
+		// Accepting holds it for the trade, it can't change under the other party
+		path := fmt.Sprintf("/games/%d", tr.aliceGame)
+		api.accept(t, tr)
+		api.expect(t, http.StatusConflict, userID(tr.alice), http.MethodPut, path, data.GamePutRequest{
+			Title: "x", Publisher: "x", Description: "x", Year: 2000, Condition: "x",
+		})
+		api.expect(t, http.StatusConflict, userID(tr.alice), http.MethodPatch, path, map[string]string{"title": "x"})
+		api.expect(t, http.StatusConflict, userID(tr.alice), http.MethodDelete, path, nil)
+		api.expect(t, http.StatusConflict, moderator(tr.bob), http.MethodDelete, fmt.Sprintf("/admin/games/%d", tr.aliceGame), nil)
+
+		// Once the trade is done the new owner can delete it and the offer stays in the history
+		api.complete(t, tr)
+		api.expect(t, http.StatusNoContent, userID(tr.bob), http.MethodDelete, path, nil)
+		got = decode[map[string]any](t, api.expect(t, http.StatusOK, userID(tr.bob), http.MethodGet, fmt.Sprintf("/offers/%d", tr.offer), nil))
+		if got["currentStatus"] != data.TradeStatusCompleted {
+			t.Fatalf("got %v", got)
+		}
+	})
+}
+
+func TestOffers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		tr := api.newTrade(t)
+		carol := api.createUser(t, "carol")
+		path := fmt.Sprintf("/offers/%d", tr.offer)
+
+		t.Run("create", func(t *testing.T) {
+			body := data.TradeOfferCreateRequest{GameRequestedIDs: []int{tr.aliceGame}, GameOfferedIDs: []int{tr.bobGame}}
+			api.expect(t, http.StatusUnauthorized, anonymous, http.MethodPost, "/offers", body)
+			api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodPost, "/offers", data.TradeOfferCreateRequest{})
+			api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodPost, "/offers", data.TradeOfferCreateRequest{
+				GameRequestedIDs: []int{tr.bobGame}, GameOfferedIDs: []int{tr.bobGame},
+			})
+			api.expect(t, http.StatusBadRequest, userID(tr.alice), http.MethodPost, "/offers", body)
+			api.expect(t, http.StatusForbidden, userID(carol), http.MethodPost, "/offers", body)
+			api.expect(t, http.StatusNotFound, userID(tr.bob), http.MethodPost, "/offers", data.TradeOfferCreateRequest{
+				GameRequestedIDs: []int{999}, GameOfferedIDs: []int{tr.bobGame},
+			})
+			past := time.Now().Add(-time.Hour)
+			api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodPost, "/offers", data.TradeOfferCreateRequest{
+				GameRequestedIDs: []int{tr.aliceGame}, GameOfferedIDs: []int{tr.bobGame}, ExpiresAt: &past,
+			})
+
+			// The single id fields from before bundles still work
+			rec := api.expect(t, http.StatusCreated, userID(tr.bob), http.MethodPost, "/offers", data.TradeOfferCreateRequest{
+				GameRequestedID: tr.aliceGame, GameOfferedID: tr.bobGame,
+			})
+			got := decode[map[string]any](t, rec)
+			if intField(t, got, "gameRequestedId") != tr.aliceGame || got["currentStatus"] != data.TradeStatusPending {
+				t.Fatalf("got %v", got)
+			}
+			api.expect(t, http.StatusMethodNotAllowed, userID(tr.bob), http.MethodDelete, "/offers", nil)
+		})
+
+		t.Run("list", func(t *testing.T) {
+			incoming := decode[[]map[string]any](t, api.expect(t, http.StatusOK, userID(tr.alice), http.MethodGet, "/offers", nil))
+			if len(incoming) != 2 || incoming[0]["requesterReputation"] == nil {
+				t.Fatalf("got %v", incoming)
+			}
+			outgoing := decode[[]map[string]any](t, api.expect(t, http.StatusOK, userID(tr.alice), http.MethodGet, "/offers?type=outgoing", nil))
+			if len(outgoing) != 0 {
+				t.Fatalf("got %v", outgoing)
+			}
+			pending := decode[[]map[string]any](t, api.expect(t, http.StatusOK, userID(tr.bob), http.MethodGet, "/offers?type=outgoing&status=pending", nil))
+			if len(pending) != 2 {
+				t.Fatalf("got %v", pending)
+			}
+			api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodGet, "/offers?status=lost", nil)
+			api.expect(t, http.StatusUnauthorized, anonymous, http.MethodGet, "/offers", nil)
+		})
+
+		t.Run("get", func(t *testing.T) {
+			got := decode[map[string]any](t, api.expect(t, http.StatusOK, userID(tr.bob), http.MethodGet, path, nil))
+			if intField(t, got, "offerId") != tr.offer || got["thread"] == nil {
+				t.Fatalf("got %v", got)
+			}
+			api.expect(t, http.StatusForbidden, userID(carol), http.MethodGet, path, nil)
+			api.expect(t, http.StatusNotFound, userID(tr.bob), http.MethodGet, "/offers/999", nil)
+			api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodGet, "/offers/abc", nil)
+			api.expect(t, http.StatusNotFound, userID(tr.bob), http.MethodGet, path+"/nope", nil)
+			api.expect(t, http.StatusUnauthorized, anonymous, http.MethodGet, path, nil)
+			api.expect(t, http.StatusMethodNotAllowed, userID(tr.bob), http.MethodDelete, path, nil)
+		})
+
+		t.Run("counter", func(t *testing.T) {
+			spare := api.createGame(t, tr.alice, "Spare")
+			counter := data.TradeOfferCounterRequest{GameRequestedIDs: []int{tr.bobGame}, GameOfferedIDs: []int{spare}}
+			api.expect(t, http.StatusForbidden, userID(tr.bob), http.MethodPost, path+"/counter", counter)
+			api.expect(t, http.StatusNotFound, userID(tr.alice), http.MethodPost, "/offers/999/counter", counter)
+			api.expect(t, http.StatusBadRequest, userID(tr.alice), http.MethodPost, path+"/counter", data.TradeOfferCounterRequest{})
+			api.expect(t, http.StatusMethodNotAllowed, userID(tr.alice), http.MethodGet, path+"/counter", nil)
+
+			offer := api.createOffer(t, tr.bob, tr.aliceGame, tr.bobGame)
+			offerPath := fmt.Sprintf("/offers/%d", offer)
+			got := decode[map[string]any](t, api.expect(t, http.StatusCreated, userID(tr.alice), http.MethodPost, offerPath+"/counter", counter))
+			if intField(t, got, "requesterId") != tr.alice || intField(t, got, "ownerUserId") != tr.bob {
+				t.Fatalf("got %v", got)
+			}
+			api.expect(t, http.StatusConflict, userID(tr.alice), http.MethodPost, offerPath+"/counter", counter)
+		})
+
+		t.Run("patch", func(t *testing.T) {
+			api.expect(t, http.StatusBadRequest, userID(tr.alice), http.MethodPatch, path, map[string]string{})
+			api.expect(t, http.StatusBadRequest, userID(tr.alice), http.MethodPatch, path, map[string]string{"currentStatus": "completed"})
+			api.expect(t, http.StatusForbidden, userID(tr.bob), http.MethodPatch, path, map[string]string{"currentStatus": "accepted"})
+			api.expect(t, http.StatusForbidden, userID(tr.alice), http.MethodPatch, path, map[string]string{"currentStatus": "cancelled"})
+			api.expect(t, http.StatusNotFound, userID(tr.alice), http.MethodPatch, "/offers/999", map[string]string{"currentStatus": "accepted"})
+
+			rejected := api.createOffer(t, tr.bob, tr.aliceGame, tr.bobGame)
+			api.expect(t, http.StatusNoContent, userID(tr.alice), http.MethodPatch, fmt.Sprintf("/offers/%d", rejected), map[string]string{"currentStatus": "rejected"})
+			api.expect(t, http.StatusConflict, userID(tr.alice), http.MethodPatch, fmt.Sprintf("/offers/%d", rejected), map[string]string{"currentStatus": "accepted"})
+			cancelled := api.createOffer(t, tr.bob, tr.aliceGame, tr.bobGame)
+			api.expect(t, http.StatusNoContent, userID(tr.bob), http.MethodPatch, fmt.Sprintf("/offers/%d", cancelled), map[string]string{"currentStatus": "cancelled"})
+
+			api.accept(t, tr)
+			api.expect(t, http.StatusConflict, userID(tr.alice), http.MethodPatch, path, map[string]string{"currentStatus": "accepted"})
+			api.expect(t, http.StatusConflict, userID(tr.bob), http.MethodPatch, path, map[string]string{"currentStatus": "cancelled"})
+
+			// Accepting voided the other offers for the same games
+			got := decode[map[string]any](t, api.expect(t, http.StatusOK, userID(tr.bob), http.MethodGet, path, nil))
+			if got["currentStatus"] != data.TradeStatusAwaitingShipment {
+				t.Fatalf("got %v", got)
+			}
+		})
+	})
+}
+
+func TestTradeLifecycle(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		tr := api.newTrade(t)
+		carol := api.createUser(t, "carol")
+		path := fmt.Sprintf("/offers/%d", tr.offer)
+
+		t.Run("before acceptance", func(t *testing.T) {
+			api.expect(t, http.StatusConflict, userID(tr.bob), http.MethodPost, path+"/shipment", data.TradeShipmentRequest{TrackingNumber: "T1"})
+			api.expect(t, http.StatusConflict, userID(tr.bob), http.MethodPost, path+"/receipt", nil)
+			api.expect(t, http.StatusConflict, userID(tr.bob), http.MethodPost, path+"/reviews", data.TradeReviewRequest{Rating: 5})
+		})
+
+		api.accept(t, tr)
+
+		t.Run("messages", func(t *testing.T) {
+			api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodPost, path+"/messages", data.TradeMessageRequest{})
+			api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodPost, path+"/messages",
+				data.TradeMessageRequest{Body: strings.Repeat("x", data.MaxMessageLength+1)})
+			api.expect(t, http.StatusForbidden, userID(carol), http.MethodPost, path+"/messages", data.TradeMessageRequest{Body: "hi"})
+			api.expect(t, http.StatusNotFound, userID(tr.bob), http.MethodPost, "/offers/999/messages", data.TradeMessageRequest{Body: "hi"})
+			// Lengths are counted in characters, not bytes
+			for _, body := range []string{"hi", "when do you ship?", "tomorrow", strings.Repeat("é", data.MaxMessageLength)} {
+				api.expect(t, http.StatusCreated, userID(tr.bob), http.MethodPost, path+"/messages", data.TradeMessageRequest{Body: body})
+			}
+
+			page := decode[map[string]any](t, api.expect(t, http.StatusOK, userID(tr.alice), http.MethodGet, path+"/messages?limit=2", nil))
+			if intField(t, page, "count") != 2 || page["_links"].(map[string]any)["next"] == nil {
+				t.Fatalf("got %v", page)
+			}
+			api.expect(t, http.StatusBadRequest, userID(tr.alice), http.MethodGet, path+"/messages?limit=0", nil)
+			api.expect(t, http.StatusBadRequest, userID(tr.alice), http.MethodGet, path+"/messages?after=-1", nil)
+			api.expect(t, http.StatusForbidden, userID(carol), http.MethodGet, path+"/messages", nil)
+			api.expect(t, http.StatusMethodNotAllowed, userID(tr.alice), http.MethodPut, path+"/messages", nil)
+		})
+
+		t.Run("shipping", func(t *testing.T) {
+			api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodPost, path+"/shipment", data.TradeShipmentRequest{})
+			api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodPost, path+"/shipment",
+				data.TradeShipmentRequest{TrackingNumber: strings.Repeat("x", data.MaxTrackingLength+1)})
+			api.expect(t, http.StatusForbidden, userID(carol), http.MethodPost, path+"/shipment", data.TradeShipmentRequest{TrackingNumber: "T1"})
+			api.expect(t, http.StatusNotFound, userID(tr.bob), http.MethodPost, "/offers/999/shipment", data.TradeShipmentRequest{TrackingNumber: "T1"})
+			api.expect(t, http.StatusMethodNotAllowed, userID(tr.bob), http.MethodGet, path+"/shipment", nil)
+			api.expect(t, http.StatusMethodNotAllowed, userID(tr.bob), http.MethodGet, path+"/receipt", nil)
+
+			api.complete(t, tr)
+			api.expect(t, http.StatusConflict, userID(tr.bob), http.MethodPost, path+"/receipt", nil)
+
+			got := decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, fmt.Sprintf("/games/%d/provenance", tr.aliceGame), nil))
+			if intField(t, got, "ownerUserId") != tr.bob || intField(t, got, "previousOwners") != 1 {
+				t.Fatalf("got %v", got)
+			}
+		})
+
+		t.Run("history", func(t *testing.T) {
+			got := decode[map[string]any](t, api.expect(t, http.StatusOK, userID(tr.alice), http.MethodGet, path+"/history", nil))
+			if events := got["events"].([]any); len(events) < 2 {
+				t.Fatalf("got %v", got)
+			}
+			api.expect(t, http.StatusForbidden, userID(carol), http.MethodGet, path+"/history", nil)
+			api.expect(t, http.StatusNotFound, userID(tr.alice), http.MethodGet, "/offers/999/history", nil)
+			api.expect(t, http.StatusMethodNotAllowed, userID(tr.alice), http.MethodPost, path+"/history", nil)
+		})
+
+		t.Run("reviews", func(t *testing.T) {
+			api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodPost, path+"/reviews", data.TradeReviewRequest{Rating: 6})
+			api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodPost, path+"/reviews",
+				data.TradeReviewRequest{Rating: 5, Comment: strings.Repeat("x", data.MaxReviewCommentLength+1)})
+			api.expect(t, http.StatusForbidden, userID(carol), http.MethodPost, path+"/reviews", data.TradeReviewRequest{Rating: 5})
+			api.expect(t, http.StatusNotFound, userID(tr.bob), http.MethodPost, "/offers/999/reviews", data.TradeReviewRequest{Rating: 5})
+			api.expect(t, http.StatusCreated, userID(tr.bob), http.MethodPost, path+"/reviews", data.TradeReviewRequest{Rating: 5, Comment: strings.Repeat("é", data.MaxReviewCommentLength)})
+			api.expect(t, http.StatusConflict, userID(tr.bob), http.MethodPost, path+"/reviews", data.TradeReviewRequest{Rating: 4})
+			api.expect(t, http.StatusCreated, userID(tr.alice), http.MethodPost, path+"/reviews", data.TradeReviewRequest{Rating: 3})
+
+			reviews := decode[[]map[string]any](t, api.expect(t, http.StatusOK, userID(tr.bob), http.MethodGet, path+"/reviews", nil))
+			if len(reviews) != 2 {
+				t.Fatalf("got %v", reviews)
+			}
+			api.expect(t, http.StatusForbidden, userID(carol), http.MethodGet, path+"/reviews", nil)
+			api.expect(t, http.StatusMethodNotAllowed, userID(tr.bob), http.MethodDelete, path+"/reviews", nil)
+
+			got := decode[map[string]any](t, api.expect(t, http.StatusOK, anonymous, http.MethodGet, fmt.Sprintf("/users/%d", tr.alice), nil))
+			if rep := got["reputation"].(map[string]any); intField(t, rep, "reviewCount") != 1 {
+				t.Fatalf("got %v", got)
+			}
+		})
+	})
+}
+
+func TestDisputes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		tr := api.newTrade(t)
+		carol := api.createUser(t, "carol")
+		mod := api.createUser(t, "mod")
+		path := fmt.Sprintf("/offers/%d", tr.offer)
+
+		api.expect(t, http.StatusConflict, userID(tr.bob), http.MethodPost, path+"/disputes", data.TradeDisputeRequest{Reason: "too early"})
+		api.accept(t, tr)
+
+		api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodPost, path+"/disputes", data.TradeDisputeRequest{})
+		api.expect(t, http.StatusBadRequest, userID(tr.bob), http.MethodPost, path+"/disputes",
+			data.TradeDisputeRequest{Reason: strings.Repeat("x", data.MaxDisputeReasonLength+1)})
+		api.expect(t, http.StatusForbidden, userID(carol), http.MethodPost, path+"/disputes", data.TradeDisputeRequest{Reason: "scam"})
+		api.expect(t, http.StatusNotFound, userID(tr.bob), http.MethodPost, "/offers/999/disputes", data.TradeDisputeRequest{Reason: "scam"})
+		got := decode[map[string]any](t, api.expect(t, http.StatusCreated, userID(tr.bob), http.MethodPost, path+"/disputes",
+			data.TradeDisputeRequest{Reason: strings.Repeat("é", data.MaxDisputeReasonLength), Evidence: "no tracking"}))
+		dispute := intField(t, got, "disputeId")
+		if got["status"] != data.DisputeStatusOpen || got["previousStatus"] != data.TradeStatusAwaitingShipment {
+			t.Fatalf("got %v", got)
+		}
+		api.expect(t, http.StatusConflict, userID(tr.alice), http.MethodPost, path+"/disputes", data.TradeDisputeRequest{Reason: "again"})
+
+		// The trade is frozen while a moderator looks at it
+		api.expect(t, http.StatusConflict, userID(tr.alice), http.MethodPost, path+"/shipment", data.TradeShipmentRequest{TrackingNumber: "T1"})
+		api.expect(t, http.StatusConflict, userID(tr.bob), http.MethodDelete, fmt.Sprintf("/users/%d", tr.bob), nil)
+
+		disputes := decode[[]map[string]any](t, api.expect(t, http.StatusOK, userID(tr.alice), http.MethodGet, path+"/disputes", nil))
+		if len(disputes) != 1 {
+			t.Fatalf("got %v", disputes)
+		}
+		api.expect(t, http.StatusForbidden, userID(carol), http.MethodGet, path+"/disputes", nil)
+		api.expect(t, http.StatusMethodNotAllowed, userID(tr.bob), http.MethodPut, path+"/disputes", nil)
+
+		resolve := fmt.Sprintf("/admin/disputes/%d/resolve", dispute)
+		api.expect(t, http.StatusBadRequest, moderator(mod), http.MethodPost, resolve, data.TradeDisputeResolution{Resolution: "refund"})
+		api.expect(t, http.StatusBadRequest, moderator(mod), http.MethodPost, resolve,
+			data.TradeDisputeResolution{Resolution: data.DisputeResolutionClose, PenaliseUserID: carol})
+		api.expect(t, http.StatusNotFound, moderator(mod), http.MethodPost, "/admin/disputes/999/resolve",
+			data.TradeDisputeResolution{Resolution: data.DisputeResolutionClose})
+		got = decode[map[string]any](t, api.expect(t, http.StatusOK, moderator(mod), http.MethodPost, resolve,
+			data.TradeDisputeResolution{Resolution: data.DisputeResolutionClose, Note: "shipped late", PenaliseUserID: tr.alice}))
+		if got["status"] != data.DisputeStatusResolved || intField(t, got, "penalisedUserId") != tr.alice {
+			t.Fatalf("got %v", got)
+		}
+		api.expect(t, http.StatusConflict, moderator(mod), http.MethodPost, resolve,
+			data.TradeDisputeResolution{Resolution: data.DisputeResolutionClose})
+
+		// Closing hands the trade back where it was
+		got = decode[map[string]any](t, api.expect(t, http.StatusOK, userID(tr.bob), http.MethodGet, path, nil))
+		if got["currentStatus"] != data.TradeStatusAwaitingShipment {
+			t.Fatalf("got %v", got)
+		}
+	})
+}
+
+func TestStrikes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		alice := api.createUser(t, "alice")
+		bob := api.createUser(t, "bob")
+		mod := api.createUser(t, "mod")
+
+		for i := range data.MaxStrikes {
+			tr := trade{
+				alice:     alice,
+				bob:       bob,
+				aliceGame: api.createGame(t, alice, fmt.Sprintf("Alice game %d", i)),
+				bobGame:   api.createGame(t, bob, fmt.Sprintf("Bob game %d", i)),
+			}
+			tr.offer = api.createOffer(t, bob, tr.aliceGame, tr.bobGame)
+			api.accept(t, tr)
+			got := decode[map[string]any](t, api.expect(t, http.StatusCreated, userID(alice), http.MethodPost,
+				fmt.Sprintf("/offers/%d/disputes", tr.offer), data.TradeDisputeRequest{Reason: "empty box"}))
+			api.expect(t, http.StatusOK, moderator(mod), http.MethodPost, fmt.Sprintf("/admin/disputes/%d/resolve", intField(t, got, "disputeId")),
+				data.TradeDisputeResolution{Resolution: data.DisputeResolutionRevert, PenaliseUserID: bob})
+		}
+
+		game := api.createGame(t, alice, "One more")
+		spare := api.createGame(t, bob, "Spare")
+		api.expect(t, http.StatusForbidden, userID(bob), http.MethodPost, "/offers", data.TradeOfferCreateRequest{
+			GameRequestedIDs: []int{game}, GameOfferedIDs: []int{spare},
+		})
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		tr := api.newTrade(t)
+		boss := api.createUser(t, "boss")
+		mod := api.createUser(t, "mod")
+
+		t.Run("permissions", func(t *testing.T) {
+			api.expect(t, http.StatusUnauthorized, anonymous, http.MethodGet, "/admin/users", nil)
+			api.expect(t, http.StatusForbidden, userID(tr.alice), http.MethodGet, "/admin/users", nil)
+			api.expect(t, http.StatusForbidden, moderator(mod), http.MethodGet, "/admin/users", nil)
+			api.expect(t, http.StatusForbidden, userID(tr.alice), http.MethodGet, "/admin/disputes", nil)
+			api.expect(t, http.StatusNotFound, admin(boss), http.MethodGet, "/admin/nope", nil)
+			api.expect(t, http.StatusMethodNotAllowed, admin(boss), http.MethodPost, "/admin/users", nil)
+			api.expect(t, http.StatusBadRequest, admin(boss), http.MethodPatch, "/admin/users/abc", nil)
+		})
+
+		t.Run("users", func(t *testing.T) {
+			page := decode[map[string]any](t, api.expect(t, http.StatusOK, admin(boss), http.MethodGet, "/admin/users?limit=2", nil))
+			if intField(t, page, "count") != 2 || page["_links"].(map[string]any)["next"] == nil {
+				t.Fatalf("got %v", page)
+			}
+			api.expect(t, http.StatusBadRequest, admin(boss), http.MethodGet, "/admin/users?role=king", nil)
+			api.expect(t, http.StatusBadRequest, admin(boss), http.MethodGet, "/admin/users?limit=0", nil)
+
+			path := fmt.Sprintf("/admin/users/%d", mod)
+			api.expect(t, http.StatusBadRequest, admin(boss), http.MethodPatch, path, map[string]string{})
+			api.expect(t, http.StatusBadRequest, admin(boss), http.MethodPatch, path, map[string]string{"role": "king"})
+			api.expect(t, http.StatusBadRequest, admin(boss), http.MethodPatch, fmt.Sprintf("/admin/users/%d", boss), map[string]string{"role": "user"})
+			api.expect(t, http.StatusNotFound, admin(boss), http.MethodPatch, "/admin/users/999", map[string]string{"role": "user"})
+			api.expect(t, http.StatusNoContent, admin(boss), http.MethodPatch, path, map[string]string{"role": "Moderator"})
+
+			page = decode[map[string]any](t, api.expect(t, http.StatusOK, admin(boss), http.MethodGet, "/admin/users?role=moderator", nil))
+			users := page["users"].([]any)
+			if len(users) != 1 || intField(t, users[0].(map[string]any), "id") != mod {
+				t.Fatalf("got %v", page)
+			}
+		})
+
+		t.Run("offers", func(t *testing.T) {
+			path := fmt.Sprintf("/admin/offers/%d/cancel", tr.offer)
+			api.expect(t, http.StatusForbidden, userID(tr.alice), http.MethodPost, path, nil)
+			api.expect(t, http.StatusNotFound, moderator(mod), http.MethodPost, "/admin/offers/999/cancel", nil)
+			api.expect(t, http.StatusBadRequest, moderator(mod), http.MethodPost, "/admin/offers/abc/cancel", nil)
+			api.expect(t, http.StatusNoContent, moderator(mod), http.MethodPost, path, adminReason{Reason: "spam"})
+			api.expect(t, http.StatusConflict, moderator(mod), http.MethodPost, path, nil)
+
+			got := decode[map[string]any](t, api.expect(t, http.StatusOK, userID(tr.bob), http.MethodGet, fmt.Sprintf("/offers/%d", tr.offer), nil))
+			if got["currentStatus"] != "cancelled" {
+				t.Fatalf("got %v", got)
+			}
+		})
+
+		t.Run("disputes", func(t *testing.T) {
+			offer := api.createOffer(t, tr.bob, tr.aliceGame, tr.bobGame)
+			api.accept(t, trade{alice: tr.alice, offer: offer})
+			api.expect(t, http.StatusCreated, userID(tr.bob), http.MethodPost, fmt.Sprintf("/offers/%d/disputes", offer), data.TradeDisputeRequest{Reason: "scam"})
+
+			page := decode[map[string]any](t, api.expect(t, http.StatusOK, moderator(mod), http.MethodGet, "/admin/disputes?status=open", nil))
+			if intField(t, page, "count") != 1 {
+				t.Fatalf("got %v", page)
+			}
+			page = decode[map[string]any](t, api.expect(t, http.StatusOK, moderator(mod), http.MethodGet, "/admin/disputes?status=resolved", nil))
+			if intField(t, page, "count") != 0 {
+				t.Fatalf("got %v", page)
+			}
+			api.expect(t, http.StatusBadRequest, moderator(mod), http.MethodGet, "/admin/disputes?status=lost", nil)
+			api.expect(t, http.StatusBadRequest, moderator(mod), http.MethodGet, "/admin/disputes?after=-1", nil)
+		})
+
+		t.Run("games", func(t *testing.T) {
+			game := api.createGame(t, tr.alice, "Rule breaker")
+			path := fmt.Sprintf("/admin/games/%d", game)
+			api.expect(t, http.StatusForbidden, userID(tr.bob), http.MethodDelete, path, nil)
+			api.expect(t, http.StatusMethodNotAllowed, moderator(mod), http.MethodGet, path, nil)
+			api.expect(t, http.StatusNoContent, moderator(mod), http.MethodDelete, path+"?reason=counterfeit", nil)
+			api.expect(t, http.StatusNotFound, moderator(mod), http.MethodDelete, path, nil)
+			api.expect(t, http.StatusNotFound, anonymous, http.MethodGet, fmt.Sprintf("/games/%d", game), nil)
+		})
+	})
+}
+
+func TestWishlist(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		alice := api.createUser(t, "alice")
+		bob := api.createUser(t, "bob")
+		path := fmt.Sprintf("/users/%d/wishlist", alice)
+
+		api.expect(t, http.StatusUnauthorized, anonymous, http.MethodGet, path, nil)
+		api.expect(t, http.StatusForbidden, userID(bob), http.MethodGet, path, nil)
+		api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPost, path, data.WishlistRequest{})
+		api.expect(t, http.StatusBadRequest, userID(alice), http.MethodPost, path, data.WishlistRequest{Title: strings.Repeat("x", 41)})
+		api.expect(t, http.StatusCreated, userID(alice), http.MethodPost, path, data.WishlistRequest{Title: strings.Repeat("é", 40)})
+		got := decode[map[string]any](t, api.expect(t, http.StatusCreated, userID(alice), http.MethodPost, path,
+			data.WishlistRequest{Title: "Ocarina of Time", Platform: "N64"}))
+		wish := intField(t, got, "wishId")
+		api.expect(t, http.StatusMethodNotAllowed, userID(alice), http.MethodPut, path, nil)
+
+		list := decode[map[string]any](t, api.expect(t, http.StatusOK, userID(alice), http.MethodGet, path, nil))
+		if intField(t, list, "count") != 2 {
+			t.Fatalf("got %v", list)
+		}
+
+		// Only other people's copies count as matches
+		api.createGame(t, alice, "Ocarina of Time")
+		theirs := api.createGame(t, bob, "Ocarina of Time")
+		api.createGame(t, bob, "Halo 2")
+		matches := decode[map[string]any](t, api.expect(t, http.StatusOK, userID(alice), http.MethodGet, path+"/matches", nil))
+		games := matches["games"].([]any)
+		if len(games) != 1 || intField(t, games[0].(map[string]any), "id") != theirs {
+			t.Fatalf("got %v", matches)
+		}
+		api.expect(t, http.StatusBadRequest, userID(alice), http.MethodGet, path+"/matches?limit=0", nil)
+		api.expect(t, http.StatusMethodNotAllowed, userID(alice), http.MethodPost, path+"/matches", nil)
+
+		wishPath := fmt.Sprintf("%s/%d", path, wish)
+		api.expect(t, http.StatusForbidden, userID(bob), http.MethodDelete, wishPath, nil)
+		api.expect(t, http.StatusBadRequest, userID(alice), http.MethodDelete, path+"/abc", nil)
+		api.expect(t, http.StatusMethodNotAllowed, userID(alice), http.MethodGet, wishPath, nil)
+		api.expect(t, http.StatusNoContent, userID(alice), http.MethodDelete, wishPath, nil)
+		api.expect(t, http.StatusNotFound, userID(alice), http.MethodDelete, wishPath, nil)
+	})
+}
+
+func TestRoot(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		rec := api.expect(t, http.StatusOK, anonymous, http.MethodGet, "/", nil)
+		if !strings.Contains(rec.Body.String(), "Server is running") {
+			t.Fatalf("got %q", rec.Body.String())
+		}
+	})
+}
+
+func TestRequestTimeout(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		tr := api.newTrade(t)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+
+		requests := []struct {
+			who          identity
+			method, path string
+			body         any
+		}{
+			{anonymous, http.MethodGet, fmt.Sprintf("/users/%d", tr.alice), nil},
+			{anonymous, http.MethodGet, "/games?title=ocarina", nil},
+			{userID(tr.alice), http.MethodPatch, fmt.Sprintf("/games/%d", tr.aliceGame), map[string]string{"title": "x"}},
+			{userID(tr.alice), http.MethodPatch, fmt.Sprintf("/offers/%d", tr.offer), map[string]string{"currentStatus": "accepted"}},
+		}
+		for _, req := range requests {
+			rec := api.doContext(t, ctx, req.who, req.method, req.path, req.body)
+			if rec.Code != http.StatusGatewayTimeout {
+				t.Errorf("%s %s: got %d, want %d: %s", req.method, req.path, rec.Code, http.StatusGatewayTimeout, rec.Body.String())
+			}
+		}
+
+		// The offer is still waiting, nothing ran past the deadline
+		got := decode[map[string]any](t, api.expect(t, http.StatusOK, userID(tr.alice), http.MethodGet, fmt.Sprintf("/offers/%d", tr.offer), nil))
+		if got["currentStatus"] != data.TradeStatusPending {
+			t.Fatalf("got %v", got)
+		}
+		if api.db != nil {
+			if inUse := api.db.Stats().InUse; inUse != 0 {
+				t.Fatalf("%d connections still in use", inUse)
+			}
+		}
+	})
+}
+
+// ownedBy is an ownerLookup for a resource that always belongs to ownerID.
+func ownedBy(ownerID int) ownerLookup {
+	return func(ctx context.Context, id int) (int, error) {
+		return ownerID, nil
+	}
+}
+
+func TestMigrateOnBoot(t *testing.T) {
+	pending := func(t *testing.T, store *data.SQLStore) int {
+		t.Helper()
+		status, err := store.MigrationStatus(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, st := range status {
+			if st.AppliedAt == nil {
+				n++
+			}
+		}
+		return n
+	}
+
+	tests := []struct {
+		env     string
+		migrate bool
+	}{
+		{"", false},
+		{"false", false},
+		{"nonsense", false},
+		{"true", true},
+		{"1", true},
+	}
+	for _, tt := range tests {
+		t.Run("MIGRATE_ON_BOOT="+tt.env, func(t *testing.T) {
+			t.Setenv("MIGRATE_ON_BOOT", tt.env)
+			store, _ := newUnmigratedSQLiteStore(t)
+			if err := migrateOnBoot(context.Background(), store); err != nil {
+				t.Fatal(err)
+			}
+			if got := pending(t, store) == 0; got != tt.migrate {
+				t.Fatalf("migrated %v, want %v", got, tt.migrate)
+			}
+			// A second instance booting finds nothing left to do
+			if err := migrateOnBoot(context.Background(), store); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("refuses an unversioned database", func(t *testing.T) {
+		t.Setenv("MIGRATE_ON_BOOT", "true")
+		store, db := newUnmigratedSQLiteStore(t)
+		if _, err := db.Exec(`CREATE TABLE USERS (UserID INTEGER PRIMARY KEY)`); err != nil {
+			t.Fatal(err)
+		}
+		if err := migrateOnBoot(context.Background(), store); err == nil {
+			t.Fatal("migrated over tables it didn't create")
+		}
+	})
+}
+
+func TestRequireOwner(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string
+		role   string
+		owner  ownerLookup
+		want   int
+	}{
+		{"no identity", "", "", ownedBy(1), http.StatusUnauthorized},
+		{"bad identity", "abc", "", ownedBy(1), http.StatusBadRequest},
+		{"owner", "1", "", ownedBy(1), http.StatusOK},
+		{"someone else", "2", "", ownedBy(1), http.StatusForbidden},
+		{"moderator", "2", "moderator", ownedBy(1), http.StatusForbidden},
+		{"admin", "2", "Admin", ownedBy(1), http.StatusOK},
+		{"missing resource", "1", "", func(ctx context.Context, id int) (int, error) {
+			return 0, fmt.Errorf("game %w", data.ErrNotFound)
+		}, http.StatusNotFound},
+		{"lookup failed", "1", "", func(ctx context.Context, id int) (int, error) {
+			return 0, errors.New("connection refused")
+		}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/games/7", nil)
+			if tt.userID != "" {
+				req.Header.Set("X-User-ID", tt.userID)
+			}
+			req.Header.Set("X-User-Role", tt.role)
+			rec := httptest.NewRecorder()
+
+			who, ok := requireOwner(rec, req, 7, tt.owner)
+			if ok != (tt.want == http.StatusOK) {
+				t.Fatalf("got ok=%v, want status %d", ok, tt.want)
+			}
+			if rec.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if ok && strconv.Itoa(who.UserID) != tt.userID {
+				t.Fatalf("got identity %+v for X-User-ID %s", who, tt.userID)
+			}
+		})
+	}
+}
+
+// TestOwnershipRoutes covers every mutating route up to the point it would touch the database.
+func TestOwnershipRoutes(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		userID string
+		want   int
+	}{
+		{http.MethodPut, "/users/1", "", http.StatusUnauthorized},
+		{http.MethodPatch, "/users/1", "", http.StatusUnauthorized},
+		{http.MethodDelete, "/users/1", "", http.StatusUnauthorized},
+		{http.MethodPut, "/users/1", "2", http.StatusForbidden},
+		{http.MethodPatch, "/users/1", "2", http.StatusForbidden},
+		{http.MethodDelete, "/users/1", "2", http.StatusForbidden},
+		{http.MethodDelete, "/users/abc", "1", http.StatusBadRequest},
+		{http.MethodPut, "/games/1", "", http.StatusUnauthorized},
+		{http.MethodPatch, "/games/1", "", http.StatusUnauthorized},
+		{http.MethodDelete, "/games/1", "", http.StatusUnauthorized},
+		{http.MethodDelete, "/games/1", "abc", http.StatusBadRequest},
+		{http.MethodDelete, "/games/abc", "1", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path+" as "+tt.userID, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.userID != "" {
+				req.Header.Set("X-User-ID", tt.userID)
+			}
+			rec := httptest.NewRecorder()
+			newServer(data.NewMemoryStore()).routes().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestAdminRoutes covers who gets past the role checks, which happen before any database access.
+func TestAdminRoutes(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		userID string
+		role   string
+		want   int
+	}{
+		{http.MethodGet, "/admin/users", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/users", "1", "", http.StatusForbidden},
+		{http.MethodGet, "/admin/users", "1", "moderator", http.StatusForbidden},
+		{http.MethodGet, "/admin/users?role=king", "1", "admin", http.StatusBadRequest},
+		{http.MethodPost, "/admin/users", "1", "admin", http.StatusMethodNotAllowed},
+		{http.MethodPatch, "/admin/users/2", "1", "moderator", http.StatusForbidden},
+		{http.MethodPatch, "/admin/users/abc", "1", "admin", http.StatusBadRequest},
+		{http.MethodPost, "/admin/offers/1/cancel", "1", "", http.StatusForbidden},
+		{http.MethodPost, "/admin/offers/abc/cancel", "1", "moderator", http.StatusBadRequest},
+		{http.MethodDelete, "/admin/games/1", "1", "user", http.StatusForbidden},
+		{http.MethodGet, "/admin/games/1", "1", "moderator", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/nope", "1", "admin", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path+" as "+tt.role, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.userID != "" {
+				req.Header.Set("X-User-ID", tt.userID)
+			}
+			req.Header.Set("X-User-Role", tt.role)
+			rec := httptest.NewRecorder()
+			newServer(data.NewMemoryStore()).routes().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestUserPatchPassword covers the password checks that run before the current password is looked up.
+func TestUserPatchPassword(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"empty password", `{"password":""}`, "hasnt been provided"},
+		{"too short", `{"password":"abc1","currentPassword":"penguin123"}`, "at least 8 characters"},
+		{"no digit", `{"password":"penguinpenguin","currentPassword":"penguin123"}`, "one letter and one number"},
+		{"no current password", `{"password":"newpenguin456"}`, "currentPassword is required"},
+		{"empty current password", `{"password":"newpenguin456","currentPassword":""}`, "currentPassword is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(tt.body))
+			req.Header.Set("X-User-ID", "1")
+			rec := httptest.NewRecorder()
+			newServer(data.NewMemoryStore()).routes().ServeHTTP(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("got %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Fatalf("got %s, want it to mention %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
@@ -1,15 +1,37 @@
 package main
 
 import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"gameAPI/config"
 	"gameAPI/data"
+	"gameAPI/kafka"
 	"log"
+	"math"
+	"math/big"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type LoginRequest struct {
@@ -17,25 +39,449 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+	AllDevices   bool   `json:"allDevices"`
+}
+
+// Access tokens are short lived because the only way to kill one early is a TokenVersion bump,
+// refresh tokens live in the database and can be revoked one by one.
+var accessTokenTTL = config.Duration("ACCESS_TOKEN_TTL", 15*time.Minute)
+var refreshTokenTTL = config.Duration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+
+// Signing keys rotate on a schedule, a retired key keeps verifying for keyGrace so tokens it
+// signed dont die early. The grace can never be shorter than an access token lives.
+var signingAlg = signingAlgEnv()
+var keyRotation = config.Duration("JWT_KEY_ROTATION", 24*time.Hour)
+var keyGrace = max(config.Duration("JWT_KEY_GRACE", time.Hour), accessTokenTTL)
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	createdAt time.Time
+	retired   bool
+}
+
+// keyring is the auth service's view of SIGNING_KEYS. Every instance reloads it from the
+// database so tokens signed by one instance validate on all of them.
+type keyring struct {
+	store      data.KeyStore
+	mu         sync.RWMutex
+	keys       map[string]*signingKey
+	signing    *signingKey
+	lastReload time.Time
+}
+
+func signingAlgEnv() string {
+	switch alg := os.Getenv("JWT_SIGNING_ALG"); alg {
+	case "", "EdDSA":
+		return "EdDSA"
+	case "RS256":
+		return alg
+	default:
+		log.Fatalf("JWT_SIGNING_ALG must be EdDSA or RS256, got %q", alg)
+		return ""
+	}
+}
+
+func (k *keyring) reload(ctx context.Context) error {
+	stored, err := k.store.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	loaded := map[string]*signingKey{}
+	var signing *signingKey
+	for _, sk := range stored {
+		key, err := parseSigningKey(sk)
+		if err != nil {
+			log.Printf("skipping signing key %s: %v", sk.KeyID, err)
+			continue
+		}
+		loaded[key.id] = key
+		// Stored newest first, so the first active key wins
+		if !key.retired && signing == nil {
+			signing = key
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = loaded
+	k.signing = signing
+	k.lastReload = time.Now()
+	return nil
+}
+
+// rotateIfDue creates a new signing key when there is none or the active one is older than keyRotation.
+func (k *keyring) rotateIfDue(ctx context.Context) error {
+	k.mu.RLock()
+	current := k.signing
+	k.mu.RUnlock()
+
+	if current != nil && time.Since(current.createdAt) < keyRotation {
+		return nil
+	}
+	notBefore := time.Now().Add(-keyRotation)
+	if current == nil {
+		// Nothing here can sign, the stored keys (if any) didn't decrypt, so only a key
+		// another instance makes from now on is worth keeping
+		notBefore = time.Now()
+	}
+
+	sk, err := generateSigningKey(signingAlg)
+	if err != nil {
+		return err
+	}
+	rotated, err := k.store.RotateSigningKey(ctx, sk, notBefore, keyGrace)
+	if err != nil {
+		return err
+	}
+	if rotated {
+		log.Println("rotated JWT signing key, new kid:", sk.KeyID)
+	}
+	return k.reload(ctx)
+}
+
+func (k *keyring) current() *signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.signing
+}
+
+// lookup finds a key by kid. A miss triggers one reload (at most every few seconds)
+// in case another auth instance just rotated.
+func (k *keyring) lookup(ctx context.Context, kid string) *signingKey {
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	stale := time.Since(k.lastReload) > 5*time.Second
+	k.mu.RUnlock()
+	if ok || !stale {
+		return key
+	}
+
+	if err := k.reload(ctx); err != nil {
+		log.Println("reloading signing keys failed:", err)
+		return nil
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[kid]
+}
+
+func (k *keyring) all() []*signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	out := make([]*signingKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		out = append(out, key)
+	}
+	return out
+}
+
+func generateSigningKey(alg string) (data.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return data.SigningKey{}, fmt.Errorf("error generating signing key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return data.SigningKey{}, fmt.Errorf("error encoding signing key: %w", err)
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return data.SigningKey{}, err
+	}
+
+	kid := base64.RawURLEncoding.EncodeToString(id)
+	sealed, err := sealPrivateKey(kid, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		return data.SigningKey{}, err
+	}
+	return data.SigningKey{KeyID: kid, Algorithm: alg, PrivateKey: sealed}, nil
+}
+
+func parseSigningKey(sk data.SigningKey) (*signingKey, error) {
+	plain, err := openPrivateKey(sk.KeyID, sk.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(plain)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{id: sk.KeyID, createdAt: sk.CreatedAt, retired: sk.RetiredAt.Valid}
+	switch private := parsed.(type) {
+	case ed25519.PrivateKey:
+		if sk.Algorithm != "EdDSA" {
+			return nil, fmt.Errorf("algorithm %s doesnt match an Ed25519 key", sk.Algorithm)
+		}
+		key.method, key.private = jwt.SigningMethodEdDSA, private
+	case *rsa.PrivateKey:
+		if sk.Algorithm != "RS256" {
+			return nil, fmt.Errorf("algorithm %s doesnt match an RSA key", sk.Algorithm)
+		}
+		key.method, key.private = jwt.SigningMethodRS256, private
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
+
+// minSigningKeySecret is the shortest SIGNING_KEY_SECRET accepted, it is hashed into an AES-256 key.
+const minSigningKeySecret = 32
+
+// keySealer encrypts private keys before they go into SIGNING_KEYS, so a database dump or backup
+// on its own can't be used to sign tokens. main refuses to start without it.
+var keySealer cipher.AEAD
+
+func loadKeySealer() (cipher.AEAD, error) {
+	secret := os.Getenv("SIGNING_KEY_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("SIGNING_KEY_SECRET is not set")
+	}
+	if len(secret) < minSigningKeySecret {
+		return nil, fmt.Errorf("SIGNING_KEY_SECRET must be at least %d bytes", minSigningKeySecret)
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealPrivateKey encrypts a PEM key with AES-GCM as base64(nonce + ciphertext). The kid is
+// authenticated with it, so a sealed key copied onto another row won't open.
+func sealPrivateKey(kid string, pemKey []byte) (string, error) {
+	nonce := make([]byte, keySealer.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error sealing signing key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(keySealer.Seal(nonce, nonce, pemKey, []byte(kid))), nil
+}
+
+func openPrivateKey(kid, sealed string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < keySealer.NonceSize() {
+		return nil, fmt.Errorf("not an encrypted key")
+	}
+	nonce, ciphertext := raw[:keySealer.NonceSize()], raw[keySealer.NonceSize():]
+	plain, err := keySealer.Open(nil, nonce, ciphertext, []byte(kid))
+	if err != nil {
+		return nil, fmt.Errorf("key doesnt decrypt, SIGNING_KEY_SECRET may have changed")
+	}
+	return plain, nil
+}
+
+// jwk is the public half of a signing key as served by the JWKS endpoint (RFC 7517).
+func (key *signingKey) jwk() map[string]string {
+	out := map[string]string{
+		"kid": key.id,
+		"alg": key.method.Alg(),
+		"use": "sig",
+	}
+	switch public := key.private.Public().(type) {
+	case ed25519.PublicKey:
+		out["kty"] = "OKP"
+		out["crv"] = "Ed25519"
+		out["x"] = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		out["kty"] = "RSA"
+		out["n"] = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		out["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+	return out
+}
+
+type loginCount struct {
+	FailedLogins    *prometheus.CounterVec
+	Lockouts        *prometheus.CounterVec
+	ThrottledLogins prometheus.Counter
+}
+
+var register = prometheus.NewRegistry()
+var lc = loginMetrics(register)
+
+// Accounts lock after a handful of misses, a single IP gets more room since NAT can put many users behind it.
+var accountAttempts = newAttemptTracker(5, time.Minute, time.Hour)
+var ipAttempts = newAttemptTracker(20, time.Minute, time.Hour)
+
+// attemptTracker counts failed logins per key and locks the key out with an exponential backoff
+// once it goes over the threshold. State is in memory, restarting the auth service clears it.
+type attemptTracker struct {
+	mu          sync.Mutex
+	entries     map[string]*attemptEntry
+	threshold   int
+	baseLockout time.Duration
+	maxLockout  time.Duration
+}
+
+type attemptEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func newAttemptTracker(threshold int, baseLockout, maxLockout time.Duration) *attemptTracker {
+	return &attemptTracker{
+		entries:     map[string]*attemptEntry{},
+		threshold:   threshold,
+		baseLockout: baseLockout,
+		maxLockout:  maxLockout,
+	}
+}
+
+// retryAfter is how long the key is still locked out for, 0 when it can try again.
+func (t *attemptTracker) retryAfter(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok || !now.Before(e.lockedUntil) {
+		return 0
+	}
+	return e.lockedUntil.Sub(now)
+}
+
+// fail records a failed attempt and returns the lockout it started, 0 when still under the threshold.
+func (t *attemptTracker) fail(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	// Forget old failures once a key has been quiet for longer than the biggest lockout
+	if !ok || now.Sub(e.lastFailure) > t.maxLockout {
+		e = &attemptEntry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+
+	if e.failures < t.threshold {
+		return 0
+	}
+
+	// 1x, 2x, 4x... the base lockout for every failure past the threshold
+	lockout := t.baseLockout << min(e.failures-t.threshold, 30)
+	if lockout <= 0 || lockout > t.maxLockout {
+		lockout = t.maxLockout
+	}
+	e.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+func (t *attemptTracker) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+// prune drops keys that are no longer locked and have been quiet long enough to be forgotten.
+func (t *attemptTracker) prune(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, e := range t.entries {
+		if now.After(e.lockedUntil) && now.Sub(e.lastFailure) > t.maxLockout {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// server holds what the handlers need: the user store for sessions and the signing keyring.
+type server struct {
+	users data.UserStore
+	keys  *keyring
+}
+
+func newServer(store data.Store) *server {
+	return &server{
+		users: store,
+		keys:  &keyring{store: store, keys: map[string]*signingKey{}},
+	}
+}
+
 func main() {
-	if os.Getenv("JWT_SECRET") == "" {
-		log.Fatal("JWT_SECRET environment variable is required")
+	sealer, err := loadKeySealer()
+	if err != nil {
+		log.Fatal(err)
 	}
-	if err := data.ConnectDatabase(); err != nil {
+	keySealer = sealer
+
+	store, err := data.ConnectDatabase()
+	if err != nil {
 		log.Fatalf("Auth service failed to connect to DB: %v", err)
 	}
+	s := newServer(store)
+	if err := s.keys.reload(context.Background()); err != nil {
+		log.Fatalf("Auth service failed to load signing keys: %v", err)
+	}
+	if err := s.keys.rotateIfDue(context.Background()); err != nil {
+		log.Fatalf("Auth service failed to create a signing key: %v", err)
+	}
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := s.keys.rotateIfDue(context.Background()); err != nil {
+				log.Println("signing key rotation failed:", err)
+			}
+			// Pick up keys other instances rotated in and drop ones past their grace window
+			if err := s.keys.reload(context.Background()); err != nil {
+				log.Println("reloading signing keys failed:", err)
+			}
+		}
+	}()
+	kafka.StartupKafkaProducer()
+	setStartingMetrics()
+
+	go func() {
+		for range time.Tick(10 * time.Minute) {
+			now := time.Now()
+			accountAttempts.prune(now)
+			ipAttempts.prune(now)
+		}
+	}()
 
+	fmt.Println("Auth Service listening on port 8081")
+	log.Fatal(http.ListenAndServe(":8081", s.routes()))
+}
+
+func (s *server) routes() *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/users/login", loginHandler)
+	mux.HandleFunc("/users/login", s.loginHandler)
+	mux.HandleFunc("/users/logout", s.logoutHandler)
+	mux.HandleFunc("/token/refresh", s.refreshHandler)
 
-	mux.HandleFunc("/validate", validateHandler)
+	mux.HandleFunc("/validate", s.validateHandler)
+	mux.HandleFunc("/.well-known/jwks.json", s.jwksHandler)
 
-	fmt.Println("Auth Service listening on port 8081")
-	log.Fatal(http.ListenAndServe(":8081", mux))
+	mux.Handle("/metrics", promhttp.HandlerFor(register, promhttp.HandlerOpts{
+		Registry: register,
+	}))
+	return mux
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -47,22 +493,187 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := data.VerifyUser(lr.Email, lr.Password)
+	now := time.Now()
+	ip := clientIP(r)
+	account := strings.ToLower(strings.TrimSpace(lr.Email))
+
+	if wait := max(accountAttempts.retryAfter(account, now), ipAttempts.retryAfter(ip, now)); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+		lc.ThrottledLogins.Inc()
+		return
+	}
+
+	userID := s.users.VerifyUser(r.Context(), lr.Email, lr.Password)
 	if userID == -1 {
+		lc.FailedLogins.With(prometheus.Labels{"reason": "bad credentials"}).Inc()
+		if lockout := ipAttempts.fail(ip, now); lockout > 0 {
+			lc.Lockouts.With(prometheus.Labels{"scope": "ip"}).Inc()
+			log.Printf("login lockout for ip %s for %s", ip, lockout)
+		}
+		if lockout := accountAttempts.fail(account, now); lockout > 0 {
+			lc.Lockouts.With(prometheus.Labels{"scope": "account"}).Inc()
+			s.notifyLockout(r.Context(), account, lockout)
+		}
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
+	accountAttempts.reset(account)
+
+	verified, err := s.users.IsEmailVerified(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Error checking account", http.StatusInternalServerError)
+		return
+	}
+	if !verified {
+		http.Error(w, "Email address not verified", http.StatusForbidden)
+		return
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+	if err := s.users.CreateRefreshToken(r.Context(), userID, data.HashToken(refreshToken), time.Now().Add(refreshTokenTTL)); err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeSession(r.Context(), w, userID, refreshToken)
+}
+
+// clientIP prefers the X-Real-IP header nginx sets, falling back to the socket address.
+func clientIP(r *http.Request) string {
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// notifyLockout emails the account owner, but only when the address belongs to a real account.
+func (s *server) notifyLockout(ctx context.Context, email string, lockout time.Duration) {
+	userID, err := s.users.GetUserIDByEmail(ctx, email)
+	if err != nil {
+		return
+	}
+	to := s.users.GetEmailWithID(ctx, userID)
+	if to == "" {
+		return
+	}
+
+	err = kafka.PushNotification(kafka.Notification{
+		To:        to,
+		Subject:   "Account Locked",
+		Body:      "There were too many failed login attempts on your account so logins are paused for " + lockout.String() + ". If this wasnt you consider resetting your password.",
+		EventType: "users",
+	})
+	if err != nil {
+		log.Println("kafka push FAILED:", err)
+	}
+}
+
+func (s *server) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var rr RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&rr); err != nil || rr.RefreshToken == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
 
-	token, err := generateToken(userID)
+	userID, err := s.users.RotateRefreshToken(r.Context(), data.HashToken(rr.RefreshToken), data.HashToken(refreshToken), time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			log.Println("refresh token reuse detected, all sessions revoked")
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		case errors.Is(err, data.ErrNotFound), errors.Is(err, data.ErrInvalidToken):
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		default:
+			http.Error(w, "Error refreshing token", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.writeSession(r.Context(), w, userID, refreshToken)
+}
+
+func (s *server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var lr LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&lr); err != nil || lr.RefreshToken == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := s.users.RevokeRefreshToken(r.Context(), data.HashToken(lr.RefreshToken))
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			// Logging out twice is not an error
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Error(w, "Error logging out", http.StatusInternalServerError)
+		return
+	}
+
+	if lr.AllDevices {
+		if err := s.users.RevokeUserSessions(r.Context(), userID); err != nil {
+			http.Error(w, "Error logging out", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeSession mints an access token for the user and returns it with the refresh token.
+func (s *server) writeSession(ctx context.Context, w http.ResponseWriter, userID int, refreshToken string) {
+	role, err := s.users.GetUserRole(ctx, userID)
+	if err != nil {
+		http.Error(w, "Error loading user role", http.StatusInternalServerError)
+		return
+	}
+	version, err := s.users.GetTokenVersion(ctx, userID)
+	if err != nil {
+		http.Error(w, "Error loading user session", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := s.generateToken(userID, role, version)
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"token":        token,
+		"tokenType":    "Bearer",
+		"expiresIn":    int(accessTokenTTL.Seconds()),
+		"refreshToken": refreshToken,
+	})
 }
 
-func validateHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) validateHandler(w http.ResponseWriter, r *http.Request) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		w.WriteHeader(http.StatusUnauthorized)
@@ -77,11 +688,17 @@ func validateHandler(w http.ResponseWriter, r *http.Request) {
 	claims := jwt.MapClaims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		key := s.keys.lookup(r.Context(), kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown signing key")
+		}
+		// The key decides the algorithm, never the token header
+		if token.Method.Alg() != key.method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method")
 		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+		return key.private.Public(), nil
+	}, jwt.WithValidMethods([]string{"EdDSA", "RS256"}))
 
 	if err != nil || !token.Valid {
 		w.WriteHeader(http.StatusUnauthorized)
@@ -89,7 +706,24 @@ func validateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if userID, ok := claims["sub"].(float64); ok {
+		// A password change, role change or logout everywhere bumps the version and kills older tokens
+		version, err := s.users.GetTokenVersion(r.Context(), int(userID))
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if tokenVersion, ok := claims["ver"].(float64); !ok || int(tokenVersion) != version {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
 		w.Header().Set("X-User-ID", fmt.Sprintf("%.0f", userID))
+		// Tokens minted before roles existed carry no role claim, treat them as plain users
+		role, _ := claims["role"].(string)
+		if !data.IsValidRole(role) {
+			role = data.RoleUser
+		}
+		w.Header().Set("X-User-Role", role)
 		w.WriteHeader(http.StatusOK)
 		return
 	}
@@ -97,15 +731,77 @@ func validateHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusUnauthorized)
 }
 
-func generateToken(userID int) (string, error) {
+func (s *server) generateToken(userID int, role string, version int) (string, error) {
 
 	claims := jwt.MapClaims{
-		"sub": userID,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
-		"iat": time.Now().Unix(),
+		"sub":  userID,
+		"role": role,
+		"ver":  version,
+		"exp":  time.Now().Add(accessTokenTTL).Unix(),
+		"iat":  time.Now().Unix(),
+	}
+
+	key := s.keys.current()
+	if key == nil {
+		return "", fmt.Errorf("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+
+	return token.SignedString(key.private)
+}
+
+func (s *server) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	set := []map[string]string{}
+	for _, key := range s.keys.all() {
+		set = append(set, key.jwk())
+	}
 
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	w.Header().Set("Content-Type", "application/json")
+	// Keys start signing as soon as they are created, verifiers should refetch when they see an unknown kid
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]any{"keys": set})
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func setStartingMetrics() {
+	lc.FailedLogins.With(prometheus.Labels{"reason": "bad credentials"}).Add(0)
+	lc.Lockouts.With(prometheus.Labels{"scope": "account"}).Add(0)
+	lc.Lockouts.With(prometheus.Labels{"scope": "ip"}).Add(0)
+}
+
+func loginMetrics(register prometheus.Registerer) *loginCount {
+	lgc := &loginCount{
+		FailedLogins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "total_failed_logins",
+			Help: "Failed login attempts and why they failed",
+		},
+			[]string{"reason"},
+		),
+		Lockouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "total_login_lockouts",
+			Help: "Times an account or IP got locked out for too many failed logins",
+		},
+			[]string{"scope"},
+		),
+		ThrottledLogins: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "total_throttled_logins",
+			Help: "Login attempts turned away with a 429 while locked out",
+		}),
+	}
+	register.MustRegister(lgc.FailedLogins, lgc.Lockouts, lgc.ThrottledLogins)
+	return lgc
 }
@@ -0,0 +1,38 @@
+// Package config parses the environment settings the api and auth services read.
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Duration reads key as a Go duration ("15m", "24h"). Unset, unparseable or non positive
+// values fall back, the bad ones with a log line.
+func Duration(key string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		log.Printf("invalid %s %q, using %s", key, raw, fallback)
+		return fallback
+	}
+	return d
+}
+
+// Bool reads key with strconv.ParseBool, anything unset or unparseable is false.
+func Bool(key string) bool {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("invalid %s %q, using false", key, raw)
+		return false
+	}
+	return b
+}
@@ -0,0 +1,235 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingDriver is a database whose every statement hangs until its context is done,
+// standing in for a stuck MySQL so the timeout paths can run without a server.
+type blockingDriver struct{}
+
+type blockingConn struct{}
+
+func (blockingDriver) Open(name string) (driver.Conn, error) { return blockingConn{}, nil }
+
+func (blockingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("blocking driver only runs statements with a context")
+}
+func (blockingConn) Close() error { return nil }
+func (blockingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("blocking driver only starts transactions with a context")
+}
+
+func (blockingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func init() {
+	sql.Register("blocking", blockingDriver{})
+}
+
+// openBlocking is a SQLStore over the blocking driver.
+func openBlocking(t *testing.T, queryTimeout time.Duration) (*SQLStore, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("blocking", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	store, err := NewSQLStore(db, "mysql", queryTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, db
+}
+
+// openSQLite is an empty migrated in-memory database that lives as long as the test.
+func openSQLite(t *testing.T, queryTimeout time.Duration) (*SQLStore, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:?_pragma=foreign_keys(1)&_txlock=immediate&_time_format=sqlite&_timezone=UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: gets its own database, keep it to one
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	store, err := NewSQLStore(db, "sqlite", queryTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.MigrateUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return store, db
+}
+
+// seed gives the calls below something to find: two users with a game each and a pending offer.
+func seed(t *testing.T, store Store) (userID, gameID, offerID int) {
+	t.Helper()
+	ctx := context.Background()
+	alice, err := store.CreateUser(ctx, User{Username: "alice", Password: "penguin123", Email: "alice@example.com", StreetAddress: "1 Ice Floe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := store.CreateUser(ctx, User{Username: "bob", Password: "penguin123", Email: "bob@example.com", StreetAddress: "2 Ice Floe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceGame, err := store.CreateGame(ctx, Game{Title: "Ocarina of Time", Publisher: "Nintendo", Description: "Boxed", Year: 1998, Condition: "good"}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobGame, err := store.CreateGame(ctx, Game{Title: "Super Mario 64", Publisher: "Nintendo", Description: "Loose", Year: 1996, Condition: "fair"}, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer, err := store.CreateTradeOffer(ctx, TradeOffer{
+		RequesterID:      bob,
+		OwnerUserID:      alice,
+		GameRequestedIDs: []int{aliceGame},
+		GameOfferedIDs:   []int{bobGame},
+		CurrentStatus:    TradeStatusPending,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return alice, aliceGame, offer
+}
+
+// storeCalls is a read, a plain write and a transaction from each part of the store.
+func storeCalls(userID, gameID, offerID int) map[string]func(ctx context.Context, store Store) error {
+	return map[string]func(ctx context.Context, store Store) error{
+		"GetUser": func(ctx context.Context, store Store) error {
+			_, err := store.GetUser(ctx, userID)
+			return err
+		},
+		"UpdateUsername": func(ctx context.Context, store Store) error {
+			return store.UpdateUsername(ctx, userID, "alice2")
+		},
+		"DeleteUserByID": func(ctx context.Context, store Store) error {
+			return store.DeleteUserByID(ctx, userID)
+		},
+		"SearchGames": func(ctx context.Context, store Store) error {
+			_, err := store.SearchGames(ctx, GameSearch{Title: "ocarina", Limit: 10})
+			return err
+		},
+		"UpdateGameTitle": func(ctx context.Context, store Store) error {
+			return store.UpdateGameTitle(ctx, gameID, "Majoras Mask")
+		},
+		"DeleteGameByID": func(ctx context.Context, store Store) error {
+			return store.DeleteGameByID(ctx, gameID)
+		},
+		"GetTradeOfferByID": func(ctx context.Context, store Store) error {
+			_, err := store.GetTradeOfferByID(ctx, offerID)
+			return err
+		},
+		"AcceptTradeOffer": func(ctx context.Context, store Store) error {
+			_, err := store.AcceptTradeOffer(ctx, offerID, userID)
+			return err
+		},
+		"Ping": func(ctx context.Context, store Store) error {
+			return store.Ping(ctx)
+		},
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sqliteStore, sqliteDB := openSQLite(t, 5*time.Second)
+	stores := map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": sqliteStore,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			userID, gameID, offerID := seed(t, store)
+			for call, fn := range storeCalls(userID, gameID, offerID) {
+				if err := fn(ctx, store); !errors.Is(err, context.Canceled) {
+					t.Errorf("%s: got %v, want context.Canceled", call, err)
+				}
+			}
+
+			// Nothing was changed by the calls that never ran
+			if user, err := store.GetUser(context.Background(), userID); err != nil || user.Username != "alice" {
+				t.Errorf("got %+v, %v, want alice untouched", user, err)
+			}
+		})
+	}
+	if inUse := sqliteDB.Stats().InUse; inUse != 0 {
+		t.Fatalf("%d sqlite connections still in use", inUse)
+	}
+
+	t.Run("sql", func(t *testing.T) {
+		store, db := openBlocking(t, 5*time.Second)
+		for call, fn := range storeCalls(1, 1, 1) {
+			if err := fn(ctx, store); !errors.Is(err, context.Canceled) {
+				t.Errorf("%s: got %v, want context.Canceled", call, err)
+			}
+		}
+		if inUse := db.Stats().InUse; inUse != 0 {
+			t.Fatalf("%d connections still in use", inUse)
+		}
+	})
+}
+
+func TestCancelDuringQuery(t *testing.T) {
+	store, db := openBlocking(t, 5*time.Second)
+	for call, fn := range storeCalls(1, 1, 1) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		if err := fn(ctx, store); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v, want context.Canceled", call, err)
+		}
+	}
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Fatalf("%d connections still in use", inUse)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	store, db := openBlocking(t, 20*time.Millisecond)
+	start := time.Now()
+	for call, fn := range storeCalls(1, 1, 1) {
+		if err := fn(context.Background(), store); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: got %v, want context.DeadlineExceeded", call, err)
+		}
+	}
+	// Nine calls that each hang until their own deadline, nowhere near a stuck test
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("calls took %s, the query timeout didnt stop them", elapsed)
+	}
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Fatalf("%d connections still in use", inUse)
+	}
+}
+
+func TestSQLiteQueryTimeout(t *testing.T) {
+	// Short enough that every query has run out of time before it starts
+	store, db := openSQLite(t, time.Nanosecond)
+	_, err := store.GetUser(context.Background(), 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Fatalf("%d connections still in use", inUse)
+	}
+}
@@ -1,10 +1,18 @@
 package data
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -16,25 +24,43 @@ type Game struct {
 	Title       string `json:"title"`
 	Publisher   string `json:"publisher"`
 	Description string `json:"description"`
+	Platform    string `json:"platform"`
 	Year        int    `json:"year"`
 	Condition   string `json:"condition"`
 	ID          int    `json:"id"`
 }
 
+// MaxPlatformLength is the size of GAMES.Platform and WISHLIST.Platform.
+const MaxPlatformLength = 40
+
 type GamePatch struct {
 	Title       *string `json:"title"`
 	Description *string `json:"description"`
+	Platform    *string `json:"platform"`
 	Condition   *string `json:"condition"`
 }
 
 type OwnedGame struct {
-	OwnerUserID int    `json:"ownerUserId"`
-	ID          int    `json:"id"`
-	Title       string `json:"title"`
-	Publisher   string `json:"publisher"`
-	Description string `json:"description"`
-	Year        int    `json:"year"`
-	Condition   string `json:"condition"`
+	OwnerUserID    int    `json:"ownerUserId"`
+	ID             int    `json:"id"`
+	Title          string `json:"title"`
+	Publisher      string `json:"publisher"`
+	Description    string `json:"description"`
+	Platform       string `json:"platform"`
+	Year           int    `json:"year"`
+	Condition      string `json:"condition"`
+	PreviousOwners int    `json:"previousOwners"`
+}
+
+// GameTransfer is one link in a game's chain of custody. The first one has no FromUserID,
+// it is the game being listed. Users that have since been deleted show up as nil.
+type GameTransfer struct {
+	TransferID    int       `json:"transferId"`
+	GameID        int       `json:"gameId"`
+	FromUserID    *int      `json:"fromUserId"`
+	ToUserID      *int      `json:"toUserId"`
+	OfferID       *int      `json:"offerId"`
+	TransferredAt time.Time `json:"transferredAt"`
 }
 
 type GameCreateRequest struct {
@@ -42,6 +68,7 @@ type GameCreateRequest struct {
 	Title       string `json:"title"`
 	Publisher   string `json:"publisher"`
 	Description string `json:"description"`
+	Platform    string `json:"platform"`
 	Year        int    `json:"year"`
 	Condition   string `json:"condition"`
 }
@@ -51,9 +78,51 @@ type User struct {
 	Password      string `json:"password"`
 	Email         string `json:"email"`
 	StreetAddress string `json:"streetAddress"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"emailVerified"`
+	Strikes       int    `json:"strikes"`
 	ID            int    `json:"id"`
 }
 
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+func IsValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type PasswordResetConfirm struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type EmailVerificationRequest struct {
+	Token string `json:"token"`
+}
+
+// SigningKey is a JWT signing key pair. RetiredAt stops it signing new tokens,
+// ExpiresAt stops it verifying old ones once the grace window is over.
+type SigningKey struct {
+	KeyID     string
+	Algorithm string
+	// PrivateKey comes already encrypted by the auth service, it is never stored in the clear
+	PrivateKey string
+	CreatedAt  time.Time
+	RetiredAt  sql.NullTime
+	ExpiresAt  sql.NullTime
+}
+
+type UserRolePatch struct {
+	Role *string `json:"role"`
+}
+
 type NewUserRequest struct {
 	Username      string `json:"username"`
 	Password      string `json:"password"`
@@ -70,42 +139,336 @@ type GamePutRequest struct {
 	Title       string `json:"title"`
 	Publisher   string `json:"publisher"`
 	Description string `json:"description"`
+	Platform    string `json:"platform"`
 	Year        int    `json:"year"`
 	Condition   string `json:"condition"`
 }
 
 type UserPatch struct {
-	Username      *string `json:"username"`
-	StreetAddress *string `json:"streetAddress"`
-	Password      *string `json:"password"`
+	Username        *string `json:"username"`
+	StreetAddress   *string `json:"streetAddress"`
+	Password        *string `json:"password"`
+	CurrentPassword *string `json:"currentPassword"`
 }
 
+// TradeOffer is one offer of N games from the requester for M games from the owner.
+// The games live in TRADE_ITEMS, one row per game and side.
 type TradeOffer struct {
-	OfferID         int    `json:"offerId"`
-	RequesterID     int    `json:"requesterId"`
-	OwnerUserID     int    `json:"ownerUserId"`
-	GameRequestedID int    `json:"gameRequestedId"`
-	GameOfferedID   int    `json:"gameOfferedId"`
-	CurrentStatus   string `json:"currentStatus"`
+	OfferID          int        `json:"offerId"`
+	RequesterID      int        `json:"requesterId"`
+	OwnerUserID      int        `json:"ownerUserId"`
+	GameRequestedIDs []int      `json:"gameRequestedIds"`
+	GameOfferedIDs   []int      `json:"gameOfferedIds"`
+	CurrentStatus    string     `json:"currentStatus"`
+	ParentOfferID    *int       `json:"parentOfferId,omitempty"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
+	Shipment         TradeShipment
+}
+
+// TradeShipment tracks the physical exchange after an offer is accepted. Each party ships their
+// own games, so RequesterTracking is the requester's parcel and RequesterReceivedAt is when the
+// requester confirmed getting the owner's parcel.
+type TradeShipment struct {
+	RequesterTracking   string     `json:"requesterTracking,omitempty"`
+	RequesterShippedAt  *time.Time `json:"requesterShippedAt,omitempty"`
+	RequesterReceivedAt *time.Time `json:"requesterReceivedAt,omitempty"`
+	OwnerTracking       string     `json:"ownerTracking,omitempty"`
+	OwnerShippedAt      *time.Time `json:"ownerShippedAt,omitempty"`
+	OwnerReceivedAt     *time.Time `json:"ownerReceivedAt,omitempty"`
+}
+
+type TradeShipmentRequest struct {
+	TrackingNumber string `json:"trackingNumber"`
+}
+
+const (
+	TradeStatusPending          = "pending"
+	TradeStatusAwaitingShipment = "awaiting_shipment"
+	TradeStatusShipped          = "shipped"
+	TradeStatusReceived         = "received"
+	TradeStatusCompleted        = "completed"
+	TradeStatusDisputed         = "disputed"
+	TradeStatusReverted         = "reverted"
+)
+
+// activeTradeStatuses are the ones where the games are promised to someone and in the post.
+var activeTradeStatuses = []string{TradeStatusAwaitingShipment, TradeStatusShipped, TradeStatusReceived, TradeStatusDisputed}
+
+var tradeStatuses = map[string]bool{
+	TradeStatusPending:          true,
+	"accepted":                  true,
+	"rejected":                  true,
+	"cancelled":                 true,
+	"countered":                 true,
+	"void":                      true,
+	"expired":                   true,
+	TradeStatusAwaitingShipment: true,
+	TradeStatusShipped:          true,
+	TradeStatusReceived:         true,
+	TradeStatusCompleted:        true,
+	TradeStatusDisputed:         true,
+	TradeStatusReverted:         true,
+}
+
+// WishlistItem is a title a user is looking for. Platform and Condition are optional, an empty
+// value matches anything, otherwise they have to equal the game's (ignoring case).
+type WishlistItem struct {
+	WishID    int       `json:"wishId"`
+	UserID    int       `json:"userId"`
+	Title     string    `json:"title"`
+	Platform  string    `json:"platform,omitempty"`
+	Condition string    `json:"condition,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WishlistRequest struct {
+	Title     string `json:"title"`
+	Platform  string `json:"platform"`
+	Condition string `json:"condition"`
+}
+
+// TradeReview is one party rating the other after a completed trade.
+type TradeReview struct {
+	ReviewID   int       `json:"reviewId"`
+	OfferID    int       `json:"offerId"`
+	ReviewerID int       `json:"reviewerId"`
+	RevieweeID int       `json:"revieweeId"`
+	Rating     int       `json:"rating"`
+	Comment    string    `json:"comment"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type TradeReviewRequest struct {
+	Rating  int    `json:"rating"`
+	Comment string `json:"comment"`
+}
+
+// Reputation is the aggregate of every review a user has received.
+type Reputation struct {
+	AverageRating float64 `json:"averageRating"`
+	ReviewCount   int     `json:"reviewCount"`
+}
+
+const (
+	MinReviewRating        = 1
+	MaxReviewRating        = 5
+	MaxReviewCommentLength = 1000
+)
+
+// TradeDispute is a complaint about an accepted trade. PreviousStatus is where the offer was
+// when the dispute was opened, closing a dispute without action puts it back there.
+type TradeDispute struct {
+	DisputeID       int        `json:"disputeId"`
+	OfferID         int        `json:"offerId"`
+	OpenedBy        int        `json:"openedBy"`
+	Reason          string     `json:"reason"`
+	Evidence        string     `json:"evidence"`
+	Status          string     `json:"status"`
+	PreviousStatus  string     `json:"previousStatus"`
+	Resolution      string     `json:"resolution,omitempty"`
+	ResolutionNote  string     `json:"resolutionNote,omitempty"`
+	PenalisedUserID *int       `json:"penalisedUserId,omitempty"`
+	ResolvedBy      *int       `json:"resolvedBy,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	ResolvedAt      *time.Time `json:"resolvedAt,omitempty"`
+}
+
+type TradeDisputeRequest struct {
+	Reason   string `json:"reason"`
+	Evidence string `json:"evidence"`
+}
+
+// TradeDisputeResolution is a moderator's ruling. Resolution is revert (undo the trade and hand
+// every game back) or close (dismiss the dispute). PenaliseUserID adds a strike to one of the parties.
+type TradeDisputeResolution struct {
+	Resolution     string `json:"resolution"`
+	Note           string `json:"note"`
+	PenaliseUserID int    `json:"penaliseUserId"`
+}
+
+const (
+	DisputeStatusOpen     = "open"
+	DisputeStatusResolved = "resolved"
+
+	DisputeResolutionRevert = "revert"
+	DisputeResolutionClose  = "close"
+
+	MaxDisputeReasonLength   = 500
+	MaxDisputeEvidenceLength = 2000
+
+	// MaxStrikes is how many penalties a user can collect before they can no longer make offers
+	MaxStrikes = 3
+)
+
+// disputableStatuses are where a trade can be disputed: after acceptance, including after completion
+// since a damaged game may only be noticed once it has been received.
+var disputableStatuses = map[string]bool{
+	TradeStatusAwaitingShipment: true,
+	TradeStatusShipped:          true,
+	TradeStatusReceived:         true,
+	TradeStatusCompleted:        true,
+}
+
+func IsValidTradeStatus(status string) bool {
+	return tradeStatuses[status]
+}
+
+const MaxTrackingLength = 64
+
+// TradeEvent is one entry in an offer's append-only history. ActorID is nil when the
+// system made the change, e.g. the expiry sweeper or an offer being voided.
+type TradeEvent struct {
+	EventID    int       `json:"eventId"`
+	OfferID    int       `json:"offerId"`
+	ActorID    *int      `json:"actorId"`
+	EventType  string    `json:"eventType"`
+	FromStatus string    `json:"fromStatus,omitempty"`
+	ToStatus   string    `json:"toStatus"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+const TradeEventCreated = "created"
+
+// TradeOfferCounterRequest is the recipient's reply to an offer. The roles swap, so the
+// requested games belong to the original requester and the offered games to the original owner.
+type TradeOfferCounterRequest struct {
+	GameRequestedIDs []int      `json:"gameRequestedIds"`
+	GameOfferedIDs   []int      `json:"gameOfferedIds"`
+	ExpiresAt        *time.Time `json:"expiresAt"`
 }
 
+// TradeOfferCreateRequest takes the id lists, the single id fields are still accepted
+// from clients written before bundles existed.
 type TradeOfferCreateRequest struct {
-	RequesterID     int `json:"requesterId"`
-	GameRequestedID int `json:"gameRequestedId"`
-	GameOfferedID   int `json:"gameOfferedId"`
+	RequesterID      int   `json:"requesterId"`
+	GameRequestedID  int   `json:"gameRequestedId"`
+	GameOfferedID    int   `json:"gameOfferedId"`
+	GameRequestedIDs []int `json:"gameRequestedIds"`
+	GameOfferedIDs   []int `json:"gameOfferedIds"`
+	// ExpiresAt overrides the default offer lifetime
+	ExpiresAt *time.Time `json:"expiresAt"`
 }
 
+// TradeMessage is one line of the chat between the two parties of an offer.
+type TradeMessage struct {
+	MessageID int       `json:"messageId"`
+	OfferID   int       `json:"offerId"`
+	SenderID  int       `json:"senderId"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type TradeMessageRequest struct {
+	Body string `json:"body"`
+}
+
+const MaxMessageLength = 2000
+
+const (
+	TradeSideRequested = "requested"
+	TradeSideOffered   = "offered"
+)
+
 type TradeOfferPatch struct {
 	OwnerUserID   *int    `json:"ownerUserId"`
 	CurrentStatus *string `json:"currentStatus"`
 }
 
-var Db *sql.DB
+// GameSearch holds the catalog filters for SearchGames. Zero values mean "no filter".
+type GameSearch struct {
+	Title          string
+	Publisher      string
+	Platform       string
+	Condition      string
+	YearFrom       int
+	YearTo         int
+	OwnerID        int
+	ExcludeOwnerID int
+	Sort           string
+	Limit          int
+	Cursor         *GameCursor
+}
+
+// GameCursor marks the row a page starts after (or before, when Before is set).
+type GameCursor struct {
+	Value  string `json:"v"`
+	ID     int    `json:"id"`
+	Before bool   `json:"b,omitempty"`
+}
+
+type GamePage struct {
+	Games []OwnedGame
+	Next  *GameCursor
+	Prev  *GameCursor
+}
+
+// gameSortColumns maps the public sort keys to GAMES columns.
+var gameSortColumns = map[string]string{
+	"id":        "GameID",
+	"title":     "Title",
+	"publisher": "Publisher",
+	"year":      "Year",
+	"condition": "Quality",
+}
+
+// SQLStore keeps everything in MySQL or SQLite. It implements UserStore, GameStore, TradeStore and KeyStore.
+type SQLStore struct {
+	db *dialectDB
+	// queryTimeout bounds every store call, transactions included. Zero leaves only the
+	// caller's context to stop a slow query.
+	queryTimeout time.Duration
+}
+
+// NewSQLStore wraps an open database, driver is the name it was opened with, "mysql" or "sqlite".
+func NewSQLStore(db *sql.DB, driver string, queryTimeout time.Duration) (*SQLStore, error) {
+	var d dialect
+	switch driver {
+	case mysqlDialect.driver:
+		d = mysqlDialect
+	case sqliteDialect.driver:
+		d = sqliteDialect
+	default:
+		return nil, fmt.Errorf("no SQL dialect for driver %q", driver)
+	}
+	return &SQLStore{db: &dialectDB{DB: db, dialect: d}, queryTimeout: queryTimeout}, nil
+}
+
+// withTimeout gives a store call its own deadline on top of the caller's context, cancelling
+// either one aborts the query and hands the connection back to the pool.
+func (s *SQLStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common, so helpers work inside or outside a transaction.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
 
-func ConnectDatabase() error {
+// ConnectDatabase opens the backend DB_DRIVER names, "mysql" (the default) or "sqlite".
+func ConnectDatabase() (*SQLStore, error) {
 	if err := godotenv.Load(); err != nil {
 		log.Println(".env file not found")
 	}
+
+	switch driver := os.Getenv("DB_DRIVER"); driver {
+	case "", mysqlDialect.driver:
+		return connectMySQL()
+	case sqliteDialect.driver:
+		return connectSQLite()
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q, want mysql or sqlite", driver)
+	}
+}
+
+func connectMySQL() (*SQLStore, error) {
 	dbHost := os.Getenv("DB_HOST")
 	if dbHost == "" {
 		dbHost = "127.0.0.1"
@@ -118,33 +481,103 @@ func ConnectDatabase() error {
 
 	ConnectionRequirements := root + ":" + password + "@tcp(" + dbHost + ":" + port + ")/" + database + "?parseTime=true"
 	fmt.Println(ConnectionRequirements)
-	var err error
 
-	Db, err = sql.Open("mysql", ConnectionRequirements)
+	db, err := sql.Open(mysqlDialect.driver, ConnectionRequirements)
 	if err != nil {
-		return fmt.Errorf("database didnt connect: %w", err)
+		return nil, fmt.Errorf("database didnt connect: %w", err)
 	}
 
-	if err := Db.Ping(); err != nil {
-		return fmt.Errorf("connection test failed: %w", err)
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("connection test failed: %w", err)
 	}
 
 	fmt.Println("Successfully connected to database")
+	return NewSQLStore(db, mysqlDialect.driver, queryTimeout())
+}
+
+// connectSQLite opens SQLITE_PATH, a file (gameAPI.db when unset) or ":memory:". An in-memory
+// database starts out empty every time, so it gets the migrations straight away. It also belongs
+// to the one process that opened it, the api and auth services only see the same users when
+// SQLITE_PATH points both at the same file, so ":memory:" is for tests and single-process runs.
+func connectSQLite() (*SQLStore, error) {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = "gameAPI.db"
+	}
+
+	// Foreign keys are off in SQLite unless asked for, busy_timeout makes the api and auth
+	// processes wait on each other's writes instead of failing, and times are written in UTC
+	// in a format that sorts the same as it compares.
+	dsn := path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate&_time_format=sqlite&_timezone=UTC"
+	db, err := sql.Open(sqliteDialect.driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("database didnt connect: %w", err)
+	}
+	// An in-memory database only lives as long as its connection, and a file only takes
+	// one writer at a time anyway
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("connection test failed: %w", err)
+	}
+
+	store, err := NewSQLStore(db, sqliteDialect.driver, queryTimeout())
+	if err != nil {
+		return nil, err
+	}
+	if path == ":memory:" {
+		if _, err := store.MigrateUp(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Println("Successfully connected to SQLite database", path)
+	return store, nil
+}
+
+// defaultQueryTimeout applies when DB_QUERY_TIMEOUT is unset, long enough for the trade
+// transactions but short enough that a stuck query doesn't pin a connection for good.
+const defaultQueryTimeout = 5 * time.Second
+
+// queryTimeout reads DB_QUERY_TIMEOUT as a Go duration ("2s", "500ms"), "0" turns it off.
+func queryTimeout() time.Duration {
+	raw := os.Getenv("DB_QUERY_TIMEOUT")
+	if raw == "" {
+		return defaultQueryTimeout
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		log.Printf("invalid DB_QUERY_TIMEOUT %q, using %s", raw, defaultQueryTimeout)
+		return defaultQueryTimeout
+	}
+	return d
+}
+
+func (s *SQLStore) Ping(ctx context.Context) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var one int
+	if err := s.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("database test query failed: %w", err)
+	}
 	return nil
 }
 
-func CreateUser(user User) (int, error) {
+func (s *SQLStore) CreateUser(ctx context.Context, user User) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	query := ` INSERT INTO USERS (Name, Email, PasswordHash, StreetAddress)
      VALUES (?, ?, ?, ?)`
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	Stringed, err := hashPassword(user.Password)
 	if err != nil {
 		return 0, err
 	}
 
-	Stringed := string(hashed)
-
-	result, err := Db.Exec(query, user.Username, user.Email, Stringed, user.StreetAddress)
+	result, err := s.db.ExecContext(ctx, query, user.Username, user.Email, Stringed, user.StreetAddress)
+	if isDuplicateKey(err) {
+		return 0, ErrDuplicateEmail
+	}
 	if err != nil {
 		return 0, fmt.Errorf("error inserting user: %w", err)
 	}
@@ -157,11 +590,19 @@ func CreateUser(user User) (int, error) {
 	return int(id), nil
 }
 
-func CreateGame(game Game, userID int) (int, error) {
-	query := `INSERT INTO GAMES (OwnerUserID, Title, Publisher, Description, Year, Quality)
-    VALUES (?, ?, ?, ?, ?, ?)`
+func (s *SQLStore) CreateGame(ctx context.Context, game Game, userID int) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `INSERT INTO GAMES (OwnerUserID, Title, Publisher, Description, Platform, Year, Quality)
+    VALUES (?, ?, ?, ?, ?, ?, ?)`
 
-	result, err := Db.Exec(query, userID, game.Title, game.Publisher, game.Description, game.Year, game.Condition)
+	result, err := tx.ExecContext(ctx, query, userID, game.Title, game.Publisher, game.Description, game.Platform, game.Year, game.Condition)
 	if err != nil {
 		return 0, fmt.Errorf("error inserting game: %w", err)
 	}
@@ -170,17 +611,94 @@ func CreateGame(game Game, userID int) (int, error) {
 		return 0, fmt.Errorf("error getting last insert ID: %w", err)
 	}
 
+	// The lister is the first owner on record
+	if err := recordGameTransfer(ctx, tx, int(id), 0, userID, 0); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
 	return int(id), nil
 }
 
-func GetUser(userID int) (User, error) {
+// transferGame hands a game to a new owner as part of an offer and adds it to the provenance.
+func transferGame(ctx context.Context, q queryer, gameID int, fromUserID int, toUserID int, offerID int) error {
+	query := `UPDATE GAMES SET OwnerUserID=?, PreviousOwners=PreviousOwners+1 WHERE GameID=?`
+	if _, err := q.ExecContext(ctx, query, toUserID, gameID); err != nil {
+		return fmt.Errorf("error updating game owner: %w", err)
+	}
+	return recordGameTransfer(ctx, q, gameID, fromUserID, toUserID, offerID)
+}
+
+// recordGameTransfer appends to GAME_OWNERSHIP, zero IDs are stored as NULL.
+func recordGameTransfer(ctx context.Context, q queryer, gameID int, fromUserID int, toUserID int, offerID int) error {
+	var from, offer any
+	if fromUserID != 0 {
+		from = fromUserID
+	}
+	if offerID != 0 {
+		offer = offerID
+	}
+	query := `INSERT INTO GAME_OWNERSHIP (GameID, FromUserID, ToUserID, OfferID, TransferredAt) VALUES (?, ?, ?, ?, ?)`
+	if _, err := q.ExecContext(ctx, query, gameID, from, toUserID, offer, time.Now().UTC()); err != nil {
+		return fmt.Errorf("error recording game transfer: %w", err)
+	}
+	return nil
+}
+
+// GetGameProvenance returns every owner change for a game, oldest first.
+func (s *SQLStore) GetGameProvenance(ctx context.Context, gameID int) ([]GameTransfer, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	transfers := []GameTransfer{}
+	query := `
+		SELECT TransferID, GameID, FromUserID, ToUserID, OfferID, TransferredAt
+		FROM GAME_OWNERSHIP
+		WHERE GameID = ?
+		ORDER BY TransferID
+	`
+	rows, err := s.db.QueryContext(ctx, query, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying game provenance: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t GameTransfer
+		var from, to, offer sql.NullInt64
+		if err := rows.Scan(&t.TransferID, &t.GameID, &from, &to, &offer, &t.TransferredAt); err != nil {
+			return nil, fmt.Errorf("error scanning game transfer row: %w", err)
+		}
+		t.FromUserID = nullIntPtr(from)
+		t.ToUserID = nullIntPtr(to)
+		t.OfferID = nullIntPtr(offer)
+		transfers = append(transfers, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating game provenance: %w", err)
+	}
+	return transfers, nil
+}
+
+func nullIntPtr(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int64)
+	return &v
+}
+
+func (s *SQLStore) GetUser(ctx context.Context, userID int) (User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	var user User
 
-	query := ` SELECT UserID, Name, Email, PasswordHash, StreetAddress  FROM USERS WHERE UserID=?`
+	query := ` SELECT UserID, Name, Email, PasswordHash, StreetAddress, Role, EmailVerified, Strikes  FROM USERS WHERE UserID=?`
 
-	err := Db.QueryRow(query, userID).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.StreetAddress)
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.StreetAddress, &user.Role, &user.EmailVerified, &user.Strikes)
 	if err == sql.ErrNoRows {
-		return User{}, fmt.Errorf("user not found in database")
+		return User{}, notFound("user")
 	}
 
 	if err != nil {
@@ -190,29 +708,78 @@ func GetUser(userID int) (User, error) {
 	return user, nil
 }
 
-func GetGameBYName(GameTitle string) (Game, error) {
-	var game Game
-	query := ` SELECT GameID, Title, Publisher, Description, Year, Quality FROM GAMES WHERE Title = ?`
+// ListUsers pages through USERS by ID for the admin API. An empty role lists everyone.
+func (s *SQLStore) ListUsers(ctx context.Context, role string, afterID int, limit int) ([]User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	users := []User{}
+	query := `SELECT UserID, Name, Email, StreetAddress, Role, Strikes FROM USERS WHERE UserID > ?`
+	args := []any{afterID}
+	if role != "" {
+		query += ` AND Role = ?`
+		args = append(args, role)
+	}
+	query += ` ORDER BY UserID LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying users: %w", err)
+	}
+	defer rows.Close()
 
-	err := Db.QueryRow(query, GameTitle).Scan(&game.ID, &game.Title, &game.Publisher, &game.Description, &game.Year, &game.Condition)
-	if err == sql.ErrNoRows {
-		return Game{}, fmt.Errorf("game not found in database")
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.StreetAddress, &u.Role, &u.Strikes); err != nil {
+			return nil, fmt.Errorf("error scanning user row: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %w", err)
 	}
+	return users, nil
+}
 
+func (s *SQLStore) GetUserRole(ctx context.Context, userID int) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var role string
+	err := s.db.QueryRowContext(ctx, `SELECT Role FROM USERS WHERE UserID=?`, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", notFound("user")
+	}
 	if err != nil {
-		return Game{}, fmt.Errorf("error getting game: %w", err)
+		return "", fmt.Errorf("error getting user role: %w", err)
 	}
+	return role, nil
+}
 
-	return game, nil
-
+// UpdateUserRole also bumps TokenVersion so tokens carrying the old role stop validating.
+func (s *SQLStore) UpdateUserRole(ctx context.Context, userID int, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `UPDATE USERS SET Role=?, TokenVersion=TokenVersion+1 WHERE UserID=?`
+	result, err := s.db.ExecContext(ctx, query, role, userID)
+	if err != nil {
+		return fmt.Errorf("error updating user role: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return notFound("user")
+	}
+	return nil
 }
-func GetGameBYID(GameId int) (Game, error) {
+
+func (s *SQLStore) GetGameBYID(ctx context.Context, GameId int) (Game, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	var game Game
-	query := ` SELECT GameID, Title, Publisher, Description, Year, Quality FROM GAMES WHERE GameID=?`
+	query := ` SELECT GameID, Title, Publisher, Description, Platform, Year, Quality FROM GAMES WHERE GameID=?`
 
-	err := Db.QueryRow(query, GameId).Scan(&game.ID, &game.Title, &game.Publisher, &game.Description, &game.Year, &game.Condition)
+	err := s.db.QueryRowContext(ctx, query, GameId).Scan(&game.ID, &game.Title, &game.Publisher, &game.Description, &game.Platform, &game.Year, &game.Condition)
 	if err == sql.ErrNoRows {
-		return Game{}, fmt.Errorf("game not found in database")
+		return Game{}, notFound("game")
 	}
 	if err != nil {
 		return Game{}, fmt.Errorf("error getting game: %w", err)
@@ -220,13 +787,15 @@ func GetGameBYID(GameId int) (Game, error) {
 	return game, nil
 }
 
-func GetOwnedGameBYID(GameId int) (OwnedGame, error) {
+func (s *SQLStore) GetOwnedGameBYID(ctx context.Context, GameId int) (OwnedGame, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	var game OwnedGame
-	query := ` SELECT GameID, Title, Publisher, Description, Year, Quality,OwnerUserID FROM GAMES WHERE GameID=?`
+	query := ` SELECT GameID, Title, Publisher, Description, Platform, Year, Quality, OwnerUserID, PreviousOwners FROM GAMES WHERE GameID=?`
 
-	err := Db.QueryRow(query, GameId).Scan(&game.ID, &game.Title, &game.Publisher, &game.Description, &game.Year, &game.Condition, &game.OwnerUserID)
+	err := s.db.QueryRowContext(ctx, query, GameId).Scan(&game.ID, &game.Title, &game.Publisher, &game.Description, &game.Platform, &game.Year, &game.Condition, &game.OwnerUserID, &game.PreviousOwners)
 	if err == sql.ErrNoRows {
-		return OwnedGame{}, fmt.Errorf("game not found in database")
+		return OwnedGame{}, notFound("game")
 	}
 	if err != nil {
 		return OwnedGame{}, fmt.Errorf("error getting game: %w", err)
@@ -234,250 +803,756 @@ func GetOwnedGameBYID(GameId int) (OwnedGame, error) {
 	return game, nil
 }
 
-func GetGamesNotOwnedByID(userID int) ([]Game, error) {
-	var games []Game
-	query := ` SELECT GameID, Title, Publisher, Description, Year, Quality FROM GAMES WHERE OwnerUserID <> ?`
+func IsValidGameSort(sort string) bool {
+	_, ok := gameSortColumns[strings.TrimPrefix(sort, "-")]
+	return ok
+}
+
+// escapeLike escapes with ! (LIKE ... ESCAPE '!'), a backslash means something different inside
+// a MySQL string than a SQLite one.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(s)
+}
+
+// SearchGames returns one page of the catalog using keyset pagination on the
+// sort column with GameID as the tie breaker, so pages stay stable while rows are added.
+func (s *SQLStore) SearchGames(ctx context.Context, search GameSearch) (GamePage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var where []string
+	var args []any
+
+	if search.Title != "" {
+		where = append(where, "Title LIKE ? ESCAPE '!'")
+		args = append(args, "%"+escapeLike(search.Title)+"%")
+	}
+	if search.Publisher != "" {
+		where = append(where, "Publisher LIKE ? ESCAPE '!'")
+		args = append(args, "%"+escapeLike(search.Publisher)+"%")
+	}
+	if search.Platform != "" {
+		where = append(where, "LOWER(Platform) = LOWER(?)")
+		args = append(args, search.Platform)
+	}
+	if search.Condition != "" {
+		where = append(where, "Quality = ?")
+		args = append(args, search.Condition)
+	}
+	if search.YearFrom > 0 {
+		where = append(where, "Year >= ?")
+		args = append(args, search.YearFrom)
+	}
+	if search.YearTo > 0 {
+		where = append(where, "Year <= ?")
+		args = append(args, search.YearTo)
+	}
+	if search.OwnerID > 0 {
+		where = append(where, "OwnerUserID = ?")
+		args = append(args, search.OwnerID)
+	}
+	if search.ExcludeOwnerID > 0 {
+		where = append(where, "OwnerUserID <> ?")
+		args = append(args, search.ExcludeOwnerID)
+	}
+
+	column, desc, err := gameSortColumn(search.Sort)
+	if err != nil {
+		return GamePage{}, err
+	}
+
+	backwards := search.Cursor != nil && search.Cursor.Before
+	// Walking backwards flips the comparison and the ORDER BY, the rows are reversed again in gamePage.
+	ascending := desc == backwards
+	cmp, dir := ">", "ASC"
+	if !ascending {
+		cmp, dir = "<", "DESC"
+	}
 
-	rows, err := Db.Query(query, userID)
+	if search.Cursor != nil {
+		if column == "GameID" {
+			where = append(where, "GameID "+cmp+" ?")
+			args = append(args, search.Cursor.ID)
+		} else {
+			where = append(where, "("+column+" "+cmp+" ? OR ("+column+" = ? AND GameID "+cmp+" ?))")
+			args = append(args, search.Cursor.Value, search.Cursor.Value, search.Cursor.ID)
+		}
+	}
+
+	query := `SELECT GameID, Title, Publisher, Description, Platform, Year, Quality, OwnerUserID, PreviousOwners FROM GAMES`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if column == "GameID" {
+		query += " ORDER BY GameID " + dir
+	} else {
+		query += " ORDER BY " + column + " " + dir + ", GameID " + dir
+	}
+	query += " LIMIT ?"
+	args = append(args, search.Limit+1)
 
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error getting games: %w", err)
+		return GamePage{}, fmt.Errorf("error searching games: %w", err)
 	}
 	defer rows.Close()
+
+	games := []OwnedGame{}
 	for rows.Next() {
-		var game Game
-		err := rows.Scan(&game.ID, &game.Title, &game.Publisher, &game.Description, &game.Year, &game.Condition)
-		if err != nil {
-			return nil, fmt.Errorf("error getting games info from scanned rows: %w", err)
+		var game OwnedGame
+		if err := rows.Scan(&game.ID, &game.Title, &game.Publisher, &game.Description, &game.Platform, &game.Year, &game.Condition, &game.OwnerUserID, &game.PreviousOwners); err != nil {
+			return GamePage{}, fmt.Errorf("error scanning game row: %w", err)
 		}
 		games = append(games, game)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error getting games info from rows: %w", err)
+		return GamePage{}, fmt.Errorf("error iterating games: %w", err)
+	}
+	return gamePage(games, search, column), nil
+}
+
+// gameSortColumn turns a public sort key like "-year" into its GAMES column and direction.
+func gameSortColumn(sort string) (string, bool, error) {
+	if sort == "" {
+		sort = "id"
+	}
+	column, ok := gameSortColumns[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return "", false, newError(ErrInvalid, "invalid sort field")
+	}
+	return column, strings.HasPrefix(sort, "-"), nil
+}
+
+// gamePage builds the page and its cursors from up to search.Limit+1 rows in walking order.
+func gamePage(games []OwnedGame, search GameSearch, column string) GamePage {
+	backwards := search.Cursor != nil && search.Cursor.Before
+	hasMore := len(games) > search.Limit
+	if hasMore {
+		games = games[:search.Limit]
+	}
+	if backwards {
+		for i, j := 0, len(games)-1; i < j; i, j = i+1, j-1 {
+			games[i], games[j] = games[j], games[i]
+		}
+	}
+
+	page := GamePage{Games: games}
+	if len(games) == 0 {
+		return page
+	}
+	first, last := games[0], games[len(games)-1]
+	if hasMore || backwards {
+		page.Next = &GameCursor{Value: gameSortValue(last, column), ID: last.ID}
+	}
+	if (hasMore && backwards) || (search.Cursor != nil && !backwards) {
+		page.Prev = &GameCursor{Value: gameSortValue(first, column), ID: first.ID, Before: true}
+	}
+	return page
+}
+
+func gameSortValue(game OwnedGame, column string) string {
+	switch column {
+	case "Title":
+		return game.Title
+	case "Publisher":
+		return game.Publisher
+	case "Year":
+		return strconv.Itoa(game.Year)
+	case "Quality":
+		return game.Condition
+	default:
+		return strconv.Itoa(game.ID)
 	}
-	return games, nil
 }
 
-func UpdateUsername(userId int, username string) error {
+func (s *SQLStore) UpdateUsername(ctx context.Context, userId int, username string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	query := `UPDATE USERS SET Name=? WHERE UserID=?`
-	result, err := Db.Exec(query, username, userId)
+	result, err := s.db.ExecContext(ctx, query, username, userId)
 	if err != nil {
 		return fmt.Errorf("error updating username: %w", err)
 	}
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("user not found in database")
+		return notFound("user")
 	}
 	return nil
 }
 
-func UpdateStreetAddress(userId int, streetAddress string) error {
+func (s *SQLStore) UpdateStreetAddress(ctx context.Context, userId int, streetAddress string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	query := `UPDATE USERS SET StreetAddress=? WHERE UserID=?`
-	result, err := Db.Exec(query, streetAddress, userId)
+	result, err := s.db.ExecContext(ctx, query, streetAddress, userId)
 	if err != nil {
 		return fmt.Errorf("error updating street address: %w", err)
 	}
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("user not found in database")
+		return notFound("user")
 	}
 	return nil
 }
 
-func UpdateFullGame(GameID int, game GamePutRequest) error {
-	query := `UPDATE GAMES SET Title = ?, Publisher = ?, Description = ?, Year = ?, Quality = ? WHERE GameID=?`
+func (s *SQLStore) UpdateFullGame(ctx context.Context, GameID int, game GamePutRequest) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `UPDATE GAMES SET Title = ?, Publisher = ?, Description = ?, Platform = ?, Year = ?, Quality = ? WHERE GameID=?`
 
-	result, err := Db.Exec(query, game.Title, game.Publisher, game.Description, game.Year, game.Condition, GameID)
+	result, err := s.db.ExecContext(ctx, query, game.Title, game.Publisher, game.Description, game.Platform, game.Year, game.Condition, GameID)
 	if err != nil {
 		return fmt.Errorf("error updating full game: %w", err)
 	}
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("game not found in database")
+		return notFound("game")
 	}
 
 	return nil
 }
 
-func UpdateGameTitle(GameID int, Title string) error {
+func (s *SQLStore) UpdateGameTitle(ctx context.Context, GameID int, Title string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	query := `UPDATE GAMES SET Title = ? WHERE GameID=?`
-	result, err := Db.Exec(query, Title, GameID)
+	result, err := s.db.ExecContext(ctx, query, Title, GameID)
 	if err != nil {
 		return fmt.Errorf("error updating game title: %w", err)
 	}
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("game not found in database")
+		return notFound("game")
 	}
 	return nil
 }
 
-func UpdateGameCondition(GameID int, Condition string) error {
+func (s *SQLStore) UpdateGameCondition(ctx context.Context, GameID int, Condition string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	query := `UPDATE GAMES SET Quality = ? WHERE GameID=?`
-	result, err := Db.Exec(query, Condition, GameID)
+	result, err := s.db.ExecContext(ctx, query, Condition, GameID)
 	if err != nil {
 		return fmt.Errorf("error updating game condition: %w", err)
 	}
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("game not found in database")
+		return notFound("game")
 	}
 	return nil
 }
 
-func UpdateGameDescription(GameID int, Description string) error {
+func (s *SQLStore) UpdateGameDescription(ctx context.Context, GameID int, Description string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	query := `UPDATE GAMES SET Description = ? WHERE GameID=?`
-	result, err := Db.Exec(query, Description, GameID)
+	result, err := s.db.ExecContext(ctx, query, Description, GameID)
 	if err != nil {
 		return fmt.Errorf("error updating game description: %w", err)
 	}
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("game not found in database")
+		return notFound("game")
 	}
 	return nil
 }
 
-func DeleteUserByID(userID int) error {
-	query := `DELETE FROM USERS WHERE UserID=?`
-	result, err := Db.Exec(query, userID)
+func (s *SQLStore) UpdateGamePlatform(ctx context.Context, GameID int, Platform string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `UPDATE GAMES SET Platform = ? WHERE GameID=?`
+	result, err := s.db.ExecContext(ctx, query, Platform, GameID)
 	if err != nil {
-		return fmt.Errorf("error deleting user: %w", err)
+		return fmt.Errorf("error updating game platform: %w", err)
 	}
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("user not found in database")
+		return notFound("game")
 	}
 	return nil
 }
 
-func DeleteUserByUsername(username string) error {
-	query := `DELETE FROM USERS WHERE Name=?`
-	result, err := Db.Exec(query, username)
+// DeleteUserByID removes a user and, through the cascades, their games and trades. While one of
+// those trades has an open dispute it returns ErrConflict instead, the moderator needs the case.
+// TRADE_EVENTS doesn't cascade so nothing else can wipe an audit trail by accident, erasing an
+// account is the one place it goes, and it is deleted here first.
+func (s *SQLStore) DeleteUserByID(ctx context.Context, userID int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var open int
+	query := `
+		SELECT COUNT(*)
+		FROM TRADE_DISPUTES d
+		JOIN TRADE t ON t.OfferID = d.OfferID
+		WHERE d.Status = ? AND (t.RequesterID = ? OR t.OwnerUserID = ?)`
+	if err := tx.QueryRowContext(ctx, query, DisputeStatusOpen, userID, userID).Scan(&open); err != nil {
+		return fmt.Errorf("error checking open disputes: %w", err)
+	}
+	if open > 0 {
+		return newError(ErrConflict, "User has an open dispute")
+	}
+
+	query = `DELETE FROM TRADE_EVENTS WHERE OfferID IN (SELECT OfferID FROM TRADE WHERE RequesterID = ? OR OwnerUserID = ?)`
+	if _, err := tx.ExecContext(ctx, query, userID, userID); err != nil {
+		return fmt.Errorf("error deleting user trade events: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM USERS WHERE UserID=?`, userID)
 	if err != nil {
 		return fmt.Errorf("error deleting user: %w", err)
 	}
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("user not found in database")
+		return notFound("user")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
 	}
 	return nil
 }
 
-func DeleteGameByID(GameID int) error {
-	query := `DELETE FROM GAMES WHERE GameID=?`
-	result, err := Db.Exec(query, GameID)
+// DeleteGameByID removes a game and voids the pending offers for it. Every offer stays in the
+// trade history, and a game that is in an active trade can't be deleted at all.
+func (s *SQLStore) DeleteGameByID(ctx context.Context, GameID int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("error deleting game: %w", err)
+		return fmt.Errorf("error starting transaction: %w", err)
 	}
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("game not found in database")
+	defer func() { _ = tx.Rollback() }()
+
+	if err := deleteGame(ctx, tx, GameID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
 	}
 	return nil
 }
 
-func DeleteGameByTitle(GameTitle string) error {
-	query := `DELETE FROM GAMES WHERE Title = ?`
-	result, err := Db.Exec(query, GameTitle)
+// deleteGame is the body of DeleteGameByID. The pending offers for the game can never go through
+// now, they are voided so their TRADE_EVENTS record why. Its TRADE_ITEMS rows cascade, so every
+// offer that listed the game keeps its history without it.
+func deleteGame(ctx context.Context, tx *dialectTx, gameID int) error {
+	busy, err := gamesInActiveTrade(ctx, tx, []int{gameID}, 0)
+	if err != nil {
+		return err
+	}
+	if len(busy) > 0 {
+		return newError(ErrConflict, "Game is in an active trade")
+	}
+
+	query := `SELECT OfferID FROM TRADE WHERE CurrentStatus=? AND OfferID IN (SELECT OfferID FROM TRADE_ITEMS WHERE GameID=?) FOR UPDATE`
+	offers, err := tx.QueryContext(ctx, query, TradeStatusPending, gameID)
+	if err != nil {
+		return fmt.Errorf("error finding game trade offers: %w", err)
+	}
+	var pending []int
+	for offers.Next() {
+		var id int
+		if err := offers.Scan(&id); err != nil {
+			offers.Close()
+			return fmt.Errorf("error scanning game trade offers: %w", err)
+		}
+		pending = append(pending, id)
+	}
+	offers.Close()
+	if err := offers.Err(); err != nil {
+		return fmt.Errorf("error finding game trade offers: %w", err)
+	}
+
+	note := fmt.Sprintf("Game #%d in this offer was deleted", gameID)
+	for _, id := range pending {
+		if _, err := setTradeStatus(ctx, tx, id, TradeStatusPending, "void", 0, note); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM GAMES WHERE GameID=?`, gameID)
 	if err != nil {
 		return fmt.Errorf("error deleting game: %w", err)
 	}
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("game not found in database")
+		return notFound("game")
 	}
 	return nil
 }
 
-func CreateTradeOffer(offer TradeOffer) (int, error) {
-	query := `INSERT INTO TRADE(RequesterID, OwnerUserID,GameRequestedID,GameOfferedID,CurrentStatus) VALUES(?, ?, ?, ?, ?)`
-	result, err := Db.Exec(query,
-		offer.RequesterID,
-		offer.OwnerUserID,
-		offer.GameRequestedID,
-		offer.GameOfferedID,
-		offer.CurrentStatus,
-	)
+func (s *SQLStore) CreateTradeOffer(ctx context.Context, offer TradeOffer) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, fmt.Errorf("error inserting trade offer: %w", err)
+		return 0, fmt.Errorf("error starting transaction: %w", err)
 	}
-	id, err := result.LastInsertId()
+	defer func() { _ = tx.Rollback() }()
+
+	id, err := insertTradeOffer(ctx, tx, offer)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return id, nil
+}
+
+// CounterTradeOffer supersedes a pending offer with the recipient's counter in one transaction.
+// The parent is locked so it cannot be accepted and countered at the same time.
+func (s *SQLStore) CounterTradeOffer(ctx context.Context, parentID int, counter TradeOffer) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	parent, err := scanTradeOffer(tx.QueryRowContext(ctx, `SELECT `+tradeColumns+` FROM TRADE WHERE OfferID=? FOR UPDATE`, parentID))
+	if err == sql.ErrNoRows {
+		return 0, notFound("trade offer")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading trade offer: %w", err)
+	}
+	if parent.CurrentStatus != "pending" {
+		return 0, newError(ErrConflict, "Offer is not pending")
+	}
+	if parent.Expired(time.Now()) {
+		return 0, newError(ErrConflict, "Offer has expired")
+	}
+
+	if _, err := setTradeStatus(ctx, tx, parentID, "pending", "countered", counter.RequesterID, ""); err != nil {
+		return 0, err
+	}
+
+	counter.ParentOfferID = &parentID
+	id, err := insertTradeOffer(ctx, tx, counter)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return id, nil
+}
+
+// insertTradeOffer writes the offer, its games and the created event.
+func insertTradeOffer(ctx context.Context, q queryer, offer TradeOffer) (int, error) {
+	var expires any
+	if offer.ExpiresAt != nil {
+		expires = offer.ExpiresAt.UTC()
+	}
+	now := time.Now().UTC()
+	query := `INSERT INTO TRADE(RequesterID, OwnerUserID, CurrentStatus, ParentOfferID, ExpiresAt, CreatedAt, UpdatedAt) VALUES(?, ?, ?, ?, ?, ?, ?)`
+	result, err := q.ExecContext(ctx, query,
+		offer.RequesterID,
+		offer.OwnerUserID,
+		offer.CurrentStatus,
+		offer.ParentOfferID,
+		expires,
+		now,
+		now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error inserting trade offer: %w", err)
+	}
+	id, err := result.LastInsertId()
 	if err != nil {
 		return 0, fmt.Errorf("error getting trade offer: %w", err)
 	}
+
+	if err := insertTradeItems(ctx, q, int(id), offer); err != nil {
+		return 0, err
+	}
+
+	note := ""
+	if offer.ParentOfferID != nil {
+		note = fmt.Sprintf("Counter-offer to #%d", *offer.ParentOfferID)
+	}
+	if err := recordTradeEvent(ctx, q, int(id), offer.RequesterID, TradeEventCreated, "", offer.CurrentStatus, note); err != nil {
+		return 0, err
+	}
 	return int(id), nil
 }
 
-func GetTradeOfferByID(offerID int) (TradeOffer, error) {
-	var o TradeOffer
+// setTradeStatus moves an offer from one status to another and records who did it.
+// It reports false without writing anything when the offer is no longer in the from status.
+func setTradeStatus(ctx context.Context, q queryer, offerID int, from string, to string, actorID int, note string) (bool, error) {
+	result, err := q.ExecContext(ctx, `UPDATE TRADE SET CurrentStatus=?, UpdatedAt=? WHERE OfferID=? AND CurrentStatus=?`, to, time.Now().UTC(), offerID, from)
+	if err != nil {
+		return false, fmt.Errorf("error updating trade status: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if err := recordTradeEvent(ctx, q, offerID, actorID, to, from, to, note); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// recordTradeEvent appends to TRADE_EVENTS, an actorID of 0 is stored as the system.
+func recordTradeEvent(ctx context.Context, q queryer, offerID int, actorID int, eventType string, from string, to string, note string) error {
+	var actor, fromStatus, eventNote any
+	if actorID != 0 {
+		actor = actorID
+	}
+	if from != "" {
+		fromStatus = from
+	}
+	if note != "" {
+		eventNote = note
+	}
+	query := `INSERT INTO TRADE_EVENTS(OfferID, ActorID, EventType, FromStatus, ToStatus, Note, CreatedAt) VALUES(?, ?, ?, ?, ?, ?, ?)`
+	if _, err := q.ExecContext(ctx, query, offerID, actor, eventType, fromStatus, to, eventNote, time.Now().UTC()); err != nil {
+		return fmt.Errorf("error recording trade event: %w", err)
+	}
+	return nil
+}
+
+// GetTradeHistory returns every event recorded for an offer, oldest first.
+func (s *SQLStore) GetTradeHistory(ctx context.Context, offerID int) ([]TradeEvent, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	events := []TradeEvent{}
 	query := `
-		SELECT OfferID, RequesterID, OwnerUserID, GameRequestedID, GameOfferedID, CurrentStatus
-		FROM TRADE
+		SELECT EventID, OfferID, ActorID, EventType, FromStatus, ToStatus, Note, CreatedAt
+		FROM TRADE_EVENTS
 		WHERE OfferID = ?
+		ORDER BY EventID
 	`
-	err := Db.QueryRow(query, offerID).Scan(
-		&o.OfferID,
-		&o.RequesterID,
-		&o.OwnerUserID,
-		&o.GameRequestedID,
-		&o.GameOfferedID,
-		&o.CurrentStatus,
-	)
-	if err == sql.ErrNoRows {
-		return TradeOffer{}, fmt.Errorf("trade offer not found in database")
-	}
+	rows, err := s.db.QueryContext(ctx, query, offerID)
 	if err != nil {
-		return TradeOffer{}, fmt.Errorf("error getting trade offer: %w", err)
+		return nil, fmt.Errorf("error querying trade history: %w", err)
 	}
-	return o, nil
+	defer rows.Close()
+
+	for rows.Next() {
+		var e TradeEvent
+		var actor sql.NullInt64
+		var from, note sql.NullString
+		if err := rows.Scan(&e.EventID, &e.OfferID, &actor, &e.EventType, &from, &e.ToStatus, &note, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning trade event row: %w", err)
+		}
+		if actor.Valid {
+			id := int(actor.Int64)
+			e.ActorID = &id
+		}
+		e.FromStatus = from.String
+		e.Note = note.String
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating trade history: %w", err)
+	}
+	return events, nil
 }
 
-func GetIncomingTradeOffers(ownerID int) ([]TradeOffer, error) {
-	offers := []TradeOffer{}
-	query := `
-		SELECT OfferID, RequesterID, OwnerUserID, GameRequestedID, GameOfferedID, CurrentStatus
-		FROM TRADE
-		WHERE OwnerUserID = ?
-		ORDER BY OfferID DESC
-	`
-	rows, err := Db.Query(query, ownerID)
+// GetTradeThread returns the whole negotiation an offer belongs to, oldest first.
+// A countered offer can't be countered again, so a thread is always a straight line.
+func (s *SQLStore) GetTradeThread(ctx context.Context, offerID int) ([]TradeOffer, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	rootID := offerID
+	for {
+		var parent sql.NullInt64
+		err := s.db.QueryRowContext(ctx, `SELECT ParentOfferID FROM TRADE WHERE OfferID=?`, rootID).Scan(&parent)
+		if err == sql.ErrNoRows {
+			return nil, notFound("trade offer")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading trade thread: %w", err)
+		}
+		if !parent.Valid {
+			break
+		}
+		rootID = int(parent.Int64)
+	}
+
+	thread := []TradeOffer{}
+	query := `SELECT ` + tradeColumns + ` FROM TRADE WHERE OfferID = ?`
+	next := rootID
+	for {
+		o, err := scanTradeOffer(s.db.QueryRowContext(ctx, query, next))
+		if err != nil {
+			return nil, fmt.Errorf("error reading trade thread: %w", err)
+		}
+		thread = append(thread, o)
+
+		var child int
+		err = s.db.QueryRowContext(ctx, `SELECT OfferID FROM TRADE WHERE ParentOfferID=? ORDER BY OfferID LIMIT 1`, next).Scan(&child)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading trade thread: %w", err)
+		}
+		next = child
+	}
+
+	if err := loadTradeItems(ctx, s.db, thread); err != nil {
+		return nil, err
+	}
+	return thread, nil
+}
+
+func insertTradeItems(ctx context.Context, q queryer, offerID int, offer TradeOffer) error {
+	for _, gameID := range offer.GameRequestedIDs {
+		if _, err := q.ExecContext(ctx, `INSERT INTO TRADE_ITEMS(OfferID, GameID, Side) VALUES(?, ?, ?)`, offerID, gameID, TradeSideRequested); err != nil {
+			return fmt.Errorf("error inserting requested trade item: %w", err)
+		}
+	}
+	for _, gameID := range offer.GameOfferedIDs {
+		if _, err := q.ExecContext(ctx, `INSERT INTO TRADE_ITEMS(OfferID, GameID, Side) VALUES(?, ?, ?)`, offerID, gameID, TradeSideOffered); err != nil {
+			return fmt.Errorf("error inserting offered trade item: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadTradeItems fills in the game lists for every offer with one query.
+func loadTradeItems(ctx context.Context, q queryer, offers []TradeOffer) error {
+	if len(offers) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(offers))
+	placeholders := make([]string, 0, len(offers))
+	args := make([]any, 0, len(offers))
+	for i := range offers {
+		index[offers[i].OfferID] = i
+		offers[i].GameRequestedIDs = []int{}
+		offers[i].GameOfferedIDs = []int{}
+		placeholders = append(placeholders, "?")
+		args = append(args, offers[i].OfferID)
+	}
+
+	query := `SELECT OfferID, GameID, Side FROM TRADE_ITEMS WHERE OfferID IN (` + strings.Join(placeholders, ",") + `) ORDER BY GameID`
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error querying trade offers: %w", err)
+		return fmt.Errorf("error querying trade items: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var o TradeOffer
-		if err := rows.Scan(&o.OfferID, &o.RequesterID, &o.OwnerUserID, &o.GameRequestedID, &o.GameOfferedID, &o.CurrentStatus); err != nil {
-			return nil, fmt.Errorf("error scanning trade offer row: %w", err)
+		var offerID, gameID int
+		var side string
+		if err := rows.Scan(&offerID, &gameID, &side); err != nil {
+			return fmt.Errorf("error scanning trade item row: %w", err)
+		}
+		o := &offers[index[offerID]]
+		if side == TradeSideRequested {
+			o.GameRequestedIDs = append(o.GameRequestedIDs, gameID)
+		} else {
+			o.GameOfferedIDs = append(o.GameOfferedIDs, gameID)
 		}
-		offers = append(offers, o)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating trade offers: %w", err)
+		return fmt.Errorf("error iterating trade items: %w", err)
 	}
+	return nil
+}
 
-	return offers, nil
+// tradeColumns is the column list scanTradeOffer expects, in order.
+const tradeColumns = `OfferID, RequesterID, OwnerUserID, CurrentStatus, ParentOfferID, ExpiresAt, CreatedAt, UpdatedAt,
+	RequesterTracking, RequesterShippedAt, RequesterReceivedAt, OwnerTracking, OwnerShippedAt, OwnerReceivedAt`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTradeOffer(row rowScanner) (TradeOffer, error) {
+	var o TradeOffer
+	var parent sql.NullInt64
+	var expires sql.NullTime
+	var reqTracking, ownerTracking sql.NullString
+	var reqShipped, reqReceived, ownerShipped, ownerReceived sql.NullTime
+	if err := row.Scan(&o.OfferID, &o.RequesterID, &o.OwnerUserID, &o.CurrentStatus, &parent, &expires, &o.CreatedAt, &o.UpdatedAt,
+		&reqTracking, &reqShipped, &reqReceived, &ownerTracking, &ownerShipped, &ownerReceived); err != nil {
+		return TradeOffer{}, err
+	}
+	o.ParentOfferID = nullIntPtr(parent)
+	o.ExpiresAt = nullTimePtr(expires)
+	o.Shipment = TradeShipment{
+		RequesterTracking:   reqTracking.String,
+		RequesterShippedAt:  nullTimePtr(reqShipped),
+		RequesterReceivedAt: nullTimePtr(reqReceived),
+		OwnerTracking:       ownerTracking.String,
+		OwnerShippedAt:      nullTimePtr(ownerShipped),
+		OwnerReceivedAt:     nullTimePtr(ownerReceived),
+	}
+	return o, nil
+}
+
+func nullTimePtr(n sql.NullTime) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := n.Time
+	return &t
+}
+
+// Expired reports whether a pending offer has run past its deadline but not been swept yet.
+func (o TradeOffer) Expired(now time.Time) bool {
+	return o.ExpiresAt != nil && !now.Before(*o.ExpiresAt)
+}
+
+func (s *SQLStore) GetTradeOfferByID(ctx context.Context, offerID int) (TradeOffer, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + tradeColumns + ` FROM TRADE WHERE OfferID = ?`
+	o, err := scanTradeOffer(s.db.QueryRowContext(ctx, query, offerID))
+	if err == sql.ErrNoRows {
+		return TradeOffer{}, notFound("trade offer")
+	}
+	if err != nil {
+		return TradeOffer{}, fmt.Errorf("error getting trade offer: %w", err)
+	}
+
+	offers := []TradeOffer{o}
+	if err := loadTradeItems(ctx, s.db, offers); err != nil {
+		return TradeOffer{}, err
+	}
+	return offers[0], nil
 }
 
-func GetOutgoingTradeOffers(requesterID int) ([]TradeOffer, error) {
+func (s *SQLStore) GetIncomingTradeOffers(ctx context.Context, ownerID int) ([]TradeOffer, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + tradeColumns + ` FROM TRADE WHERE OwnerUserID = ? ORDER BY OfferID DESC`
+	return queryTradeOffers(ctx, s.db, query, ownerID)
+}
+
+func (s *SQLStore) GetOutgoingTradeOffers(ctx context.Context, requesterID int) ([]TradeOffer, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + tradeColumns + ` FROM TRADE WHERE RequesterID = ? ORDER BY OfferID DESC`
+	return queryTradeOffers(ctx, s.db, query, requesterID)
+}
+
+func queryTradeOffers(ctx context.Context, q queryer, query string, args ...any) ([]TradeOffer, error) {
 	offers := []TradeOffer{}
-	query := `
-		SELECT OfferID, RequesterID, OwnerUserID, GameRequestedID, GameOfferedID, CurrentStatus
-		FROM TRADE
-		WHERE RequesterID = ?
-		ORDER BY OfferID DESC
-	`
-	rows, err := Db.Query(query, requesterID)
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying trade offers: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var o TradeOffer
-		if err := rows.Scan(&o.OfferID, &o.RequesterID, &o.OwnerUserID, &o.GameRequestedID, &o.GameOfferedID, &o.CurrentStatus); err != nil {
+		o, err := scanTradeOffer(rows)
+		if err != nil {
 			return nil, fmt.Errorf("error scanning trade offer row: %w", err)
 		}
 		offers = append(offers, o)
@@ -485,29 +1560,65 @@ func GetOutgoingTradeOffers(requesterID int) ([]TradeOffer, error) {
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating trade offers: %w", err)
 	}
+	rows.Close()
 
+	if err := loadTradeItems(ctx, q, offers); err != nil {
+		return nil, err
+	}
 	return offers, nil
 }
 
-func UpdateTradeOfferStatus(offerID int, status string) error {
-	query := `UPDATE TRADE SET CurrentStatus=? WHERE OfferID=?`
-	result, err := Db.Exec(query, status, offerID)
+// UpdateTradeOfferStatus moves a pending offer to status and records actorID as the one who changed
+// it. Any other offer is past the point where it can be rejected or cancelled, that is ErrConflict.
+func (s *SQLStore) UpdateTradeOfferStatus(ctx context.Context, offerID int, status string, actorID int, note string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("error updating trade offer: %w", err)
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var current string
+	err = tx.QueryRowContext(ctx, `SELECT CurrentStatus FROM TRADE WHERE OfferID=? FOR UPDATE`, offerID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return notFound("trade offer")
 	}
-	aff, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("error checking rows affected: %w", err)
+		return fmt.Errorf("error reading trade offer: %w", err)
 	}
-	if aff == 0 {
-		return fmt.Errorf("trade offer not found in database")
+
+	changed, err := setTradeStatus(ctx, tx, offerID, TradeStatusPending, status, actorID, note)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return newError(ErrConflict, "Offer is not pending")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
 	}
 	return nil
 }
 
-func UpdateUserPassword(userID int, password string) error {
+// UpdateUserPassword hashes the new plaintext password and ends every existing session for the user.
+func (s *SQLStore) UpdateUserPassword(ctx context.Context, userID int, password string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
 	query := `UPDATE USERS SET PasswordHash=? WHERE UserID=?`
-	result, err := Db.Exec(query, password, userID)
+	result, err := tx.ExecContext(ctx, query, hashed, userID)
 	if err != nil {
 		return fmt.Errorf("error updating user password: %w", err)
 	}
@@ -516,101 +1627,1510 @@ func UpdateUserPassword(userID int, password string) error {
 		return fmt.Errorf("error checking rows affected: %w", err)
 	}
 	if aff == 0 {
-		return fmt.Errorf("user not found in database(DBPasswordUpdate)")
+		return notFound("user")
+	}
+
+	if err := revokeUserSessions(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
 	}
 	return nil
 }
 
-func GetEmailWithID(id int) string {
-	var email string
-	query := `SELECT Email From USERS WHERE UserID=?`
-	result := Db.QueryRow(query, id).Scan(&email)
-	if result != nil {
-		return ""
+const (
+	MinPasswordLength = 8
+	// bcrypt silently ignores everything past 72 bytes
+	MaxPasswordBytes = 72
+)
+
+// CheckPasswordPolicy returns a message for the user when the password is too weak, or "" when it is fine.
+func CheckPasswordPolicy(password string) string {
+	if len([]rune(password)) < MinPasswordLength {
+		return fmt.Sprintf("password must be at least %d characters", MinPasswordLength)
 	}
-	return email
+	if len(password) > MaxPasswordBytes {
+		return fmt.Sprintf("password cant be longer than %d bytes", MaxPasswordBytes)
+	}
+
+	var letter, digit bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLetter(c):
+			letter = true
+		case unicode.IsDigit(c):
+			digit = true
+		}
+	}
+	if !letter || !digit {
+		return "password needs at least one letter and one number"
+	}
+	return ""
 }
 
-func AcceptTradeOffer(offerID int) error {
-	tx, err := Db.Begin()
+func hashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return fmt.Errorf("error starting transaction: %w", err)
+		return "", fmt.Errorf("error hashing password: %w", err)
 	}
-	defer func() { _ = tx.Rollback() }()
+	return string(hashed), nil
+}
 
-	var o TradeOffer
-	err = tx.QueryRow(`
-		SELECT OfferID, RequesterID, OwnerUserID, GameRequestedID, GameOfferedID, CurrentStatus
-		FROM TRADE
-		WHERE OfferID=?
-	`, offerID).Scan(&o.OfferID, &o.RequesterID, &o.OwnerUserID, &o.GameRequestedID, &o.GameOfferedID, &o.CurrentStatus)
+// CheckUserPassword reports whether password matches what is stored for the user.
+func (s *SQLStore) CheckUserPassword(ctx context.Context, userID int, password string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var hashedPassword string
+	err := s.db.QueryRowContext(ctx, `SELECT PasswordHash FROM USERS WHERE UserID=?`, userID).Scan(&hashedPassword)
 	if err == sql.ErrNoRows {
-		return fmt.Errorf("trade offer not found in database")
+		return false, notFound("user")
 	}
 	if err != nil {
-		return fmt.Errorf("error reading trade offer: %w", err)
+		return false, fmt.Errorf("error checking password: %w", err)
 	}
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil, nil
+}
 
-	if o.CurrentStatus != "pending" {
-		return fmt.Errorf("Offer is not pending")
-	}
+// HashToken is how every opaque token (refresh, reset) is stored, so a leaked table cant be replayed.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
 
-	var requestedOwner int
-	err = tx.QueryRow(`SELECT OwnerUserID FROM GAMES WHERE GameID=?`, o.GameRequestedID).Scan(&requestedOwner)
+func (s *SQLStore) GetTokenVersion(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var version int
+	err := s.db.QueryRowContext(ctx, `SELECT TokenVersion FROM USERS WHERE UserID=?`, userID).Scan(&version)
 	if err == sql.ErrNoRows {
-		return fmt.Errorf("game not found in database")
+		return 0, notFound("user")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error getting token version: %w", err)
+	}
+	return version, nil
+}
+
+// RevokeUserSessions invalidates every access token (via TokenVersion) and refresh token the user holds.
+func (s *SQLStore) RevokeUserSessions(ctx context.Context, userID int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := revokeUserSessions(ctx, tx, userID); err != nil {
+		return err
 	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+func revokeUserSessions(ctx context.Context, tx *dialectTx, userID int) error {
+	result, err := tx.ExecContext(ctx, `UPDATE USERS SET TokenVersion=TokenVersion+1 WHERE UserID=?`, userID)
 	if err != nil {
-		return fmt.Errorf("error checking requested game owner: %w", err)
+		return fmt.Errorf("error bumping token version: %w", err)
+	}
+	if aff, _ := result.RowsAffected(); aff == 0 {
+		return notFound("user")
 	}
-	if requestedOwner != o.OwnerUserID {
-		return fmt.Errorf("Requested game owner changed")
+	if _, err := tx.ExecContext(ctx, `UPDATE REFRESH_TOKENS SET RevokedAt=UTC_TIMESTAMP() WHERE UserID=? AND RevokedAt IS NULL`, userID); err != nil {
+		return fmt.Errorf("error revoking refresh tokens: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) CreateRefreshToken(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO REFRESH_TOKENS (UserID, TokenHash, ExpiresAt) VALUES (?, ?, ?)`
+	if _, err := s.db.ExecContext(ctx, query, userID, tokenHash, expiresAt.UTC()); err != nil {
+		return fmt.Errorf("error inserting refresh token: %w", err)
+	}
+	return nil
+}
+
+// RotateRefreshToken swaps a live refresh token for a new one and returns its owner.
+// Presenting a token that was already rotated means it leaked, so every session for that
+// user is revoked as well. A token revoked by logout is only refused.
+func (s *SQLStore) RotateRefreshToken(ctx context.Context, oldHash string, newHash string, expiresAt time.Time) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	var offeredOwner int
-	err = tx.QueryRow(`SELECT OwnerUserID FROM GAMES WHERE GameID=?`, o.GameOfferedID).Scan(&offeredOwner)
+	var tokenID, userID int
+	var tokenExpiry time.Time
+	var revokedAt sql.NullTime
+	var replacedBy sql.NullInt64
+	err = tx.QueryRowContext(ctx, `
+		SELECT TokenID, UserID, ExpiresAt, RevokedAt, ReplacedByID
+		FROM REFRESH_TOKENS
+		WHERE TokenHash=?
+		FOR UPDATE
+	`, oldHash).Scan(&tokenID, &userID, &tokenExpiry, &revokedAt, &replacedBy)
 	if err == sql.ErrNoRows {
-		return fmt.Errorf("game not found in database")
+		return 0, notFound("refresh token")
 	}
 	if err != nil {
-		return fmt.Errorf("error checking offered game owner: %w", err)
+		return 0, fmt.Errorf("error reading refresh token: %w", err)
+	}
+
+	// Only a token that was already rotated counts as reuse. One revoked by logout is just
+	// stale (an old tab), refusing it is enough without logging the user out everywhere.
+	if replacedBy.Valid {
+		if err := revokeUserSessions(ctx, tx, userID); err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, fmt.Errorf("error committing transaction: %w", err)
+		}
+		return 0, ErrTokenReused
 	}
-	if offeredOwner != o.RequesterID {
-		return fmt.Errorf("Offered game owner changed")
+	if revokedAt.Valid {
+		return 0, newError(ErrInvalidToken, "refresh token revoked")
+	}
+	if time.Now().After(tokenExpiry) {
+		return 0, newError(ErrInvalidToken, "refresh token expired")
 	}
 
-	// Swap owners
-	if _, err := tx.Exec(`UPDATE GAMES SET OwnerUserID=? WHERE GameID=?`, o.RequesterID, o.GameRequestedID); err != nil {
-		return fmt.Errorf("error updating requested game owner: %w", err)
+	result, err := tx.ExecContext(ctx, `INSERT INTO REFRESH_TOKENS (UserID, TokenHash, ExpiresAt) VALUES (?, ?, ?)`, userID, newHash, expiresAt.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error inserting refresh token: %w", err)
 	}
-	if _, err := tx.Exec(`UPDATE GAMES SET OwnerUserID=? WHERE GameID=?`, o.OwnerUserID, o.GameOfferedID); err != nil {
-		return fmt.Errorf("error updating offered game owner: %w", err)
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error getting last insert ID: %w", err)
 	}
 
-	// Mark accepted
-	if _, err := tx.Exec(`UPDATE TRADE SET CurrentStatus='accepted' WHERE OfferID=?`, o.OfferID); err != nil {
-		return fmt.Errorf("error updating trade status: %w", err)
+	if _, err := tx.ExecContext(ctx, `UPDATE REFRESH_TOKENS SET RevokedAt=UTC_TIMESTAMP(), ReplacedByID=? WHERE TokenID=?`, newID, tokenID); err != nil {
+		return 0, fmt.Errorf("error revoking refresh token: %w", err)
 	}
 
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error committing transaction: %w", err)
+		return 0, fmt.Errorf("error committing transaction: %w", err)
 	}
+	return userID, nil
+}
 
-	return nil
+// RevokeRefreshToken ends a single session and returns the user it belonged to.
+func (s *SQLStore) RevokeRefreshToken(ctx context.Context, tokenHash string) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var userID int
+	err := s.db.QueryRowContext(ctx, `SELECT UserID FROM REFRESH_TOKENS WHERE TokenHash=?`, tokenHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, notFound("refresh token")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading refresh token: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, `UPDATE REFRESH_TOKENS SET RevokedAt=UTC_TIMESTAMP() WHERE TokenHash=? AND RevokedAt IS NULL`, tokenHash); err != nil {
+		return 0, fmt.Errorf("error revoking refresh token: %w", err)
+	}
+	return userID, nil
 }
 
-func VerifyUser(email string, password string) int {
-	var hashedPassword string
-	var id int
-	query := "SELECT UserID, PasswordHash FROM USERS WHERE Email=?"
-	result := Db.QueryRow(query, email).Scan(&id, &hashedPassword)
+func (s *SQLStore) GetEmailWithID(ctx context.Context, id int) string {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var email string
+	query := `SELECT Email From USERS WHERE UserID=?`
+	result := s.db.QueryRowContext(ctx, query, id).Scan(&email)
 	if result != nil {
-		return -1
+		return ""
 	}
+	return email
+}
 
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+// AcceptTradeOffer commits both parties to the trade and moves it to awaiting_shipment. Ownership only
+// changes once both sides confirm receipt, see ConfirmTradeReceipt. Each game row is locked first so a
+// concurrent accept touching the same game has to wait and then fails the checks.
+// Every other pending offer that includes one of the games can no longer go through, so those
+// are marked void in the same transaction and returned for the caller to notify.
+func (s *SQLStore) AcceptTradeOffer(ctx context.Context, offerID int, actorID int) ([]TradeOffer, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return -1
+		return nil, fmt.Errorf("error starting transaction: %w", err)
 	}
-	return id
+	defer func() { _ = tx.Rollback() }()
+
+	o, err := scanTradeOffer(tx.QueryRowContext(ctx, `SELECT `+tradeColumns+` FROM TRADE WHERE OfferID=? FOR UPDATE`, offerID))
+	if err == sql.ErrNoRows {
+		return nil, notFound("trade offer")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading trade offer: %w", err)
+	}
+
+	if o.CurrentStatus != "pending" {
+		return nil, newError(ErrConflict, "Offer is not pending")
+	}
+	if o.Expired(time.Now()) {
+		return nil, newError(ErrConflict, "Offer has expired")
+	}
+
+	offers := []TradeOffer{o}
+	if err := loadTradeItems(ctx, tx, offers); err != nil {
+		return nil, err
+	}
+	o = offers[0]
+	if len(o.GameRequestedIDs) == 0 || len(o.GameOfferedIDs) == 0 {
+		return nil, newError(ErrConflict, "Offer has no games on one side")
+	}
+
+	for _, gameID := range o.GameRequestedIDs {
+		if err := checkGameOwner(ctx, tx, gameID, o.OwnerUserID); err != nil {
+			if errors.Is(err, ErrOwnershipChanged) {
+				return nil, newError(ErrOwnershipChanged, "Requested game owner changed")
+			}
+			return nil, err
+		}
+	}
+	for _, gameID := range o.GameOfferedIDs {
+		if err := checkGameOwner(ctx, tx, gameID, o.RequesterID); err != nil {
+			if errors.Is(err, ErrOwnershipChanged) {
+				return nil, newError(ErrOwnershipChanged, "Offered game owner changed")
+			}
+			return nil, err
+		}
+	}
+
+	busy, err := gamesInActiveTrade(ctx, tx, append(append([]int{}, o.GameRequestedIDs...), o.GameOfferedIDs...), o.OfferID)
+	if err != nil {
+		return nil, err
+	}
+	if len(busy) > 0 {
+		return nil, newError(ErrConflict, "Game is already in an active trade")
+	}
+
+	// Mark accepted, the games get swapped when both parcels arrive
+	if _, err := setTradeStatus(ctx, tx, o.OfferID, TradeStatusPending, TradeStatusAwaitingShipment, actorID, ""); err != nil {
+		return nil, err
+	}
+
+	voided, err := voidConflictingOffers(ctx, tx, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return voided, nil
+}
+
+// voidConflictingOffers marks every other pending offer sharing a game with o as void.
+func voidConflictingOffers(ctx context.Context, tx *dialectTx, o TradeOffer) ([]TradeOffer, error) {
+	gameIDs := append(append([]int{}, o.GameRequestedIDs...), o.GameOfferedIDs...)
+	placeholders := make([]string, 0, len(gameIDs))
+	args := []any{o.OfferID}
+	for _, id := range gameIDs {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+
+	query := `
+		SELECT ` + tradeColumns + `
+		FROM TRADE
+		WHERE CurrentStatus = 'pending'
+		  AND OfferID <> ?
+		  AND OfferID IN (SELECT OfferID FROM TRADE_ITEMS WHERE GameID IN (` + strings.Join(placeholders, ",") + `))
+		FOR UPDATE
+	`
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying conflicting offers: %w", err)
+	}
+	voided := []TradeOffer{}
+	for rows.Next() {
+		v, err := scanTradeOffer(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning trade offer row: %w", err)
+		}
+		voided = append(voided, v)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("error iterating conflicting offers: %w", err)
+	}
+	rows.Close()
+
+	note := fmt.Sprintf("A game in this offer was traded in offer #%d", o.OfferID)
+	for _, v := range voided {
+		if _, err := setTradeStatus(ctx, tx, v.OfferID, "pending", "void", 0, note); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loadTradeItems(ctx, tx, voided); err != nil {
+		return nil, err
+	}
+	return voided, nil
+}
+
+func (s *SQLStore) CreateTradeMessage(ctx context.Context, offerID int, senderID int, body string) (TradeMessage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	now := time.Now().UTC().Truncate(time.Second)
+	query := `INSERT INTO TRADE_MESSAGES(OfferID, SenderID, Body, CreatedAt) VALUES(?, ?, ?, ?)`
+	result, err := s.db.ExecContext(ctx, query, offerID, senderID, body, now)
+	if err != nil {
+		return TradeMessage{}, fmt.Errorf("error inserting trade message: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return TradeMessage{}, fmt.Errorf("error getting trade message: %w", err)
+	}
+	return TradeMessage{
+		MessageID: int(id),
+		OfferID:   offerID,
+		SenderID:  senderID,
+		Body:      body,
+		CreatedAt: now,
+	}, nil
+}
+
+// ListTradeMessages returns up to limit messages on an offer with an ID above afterID, oldest first.
+func (s *SQLStore) ListTradeMessages(ctx context.Context, offerID int, afterID int, limit int) ([]TradeMessage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	messages := []TradeMessage{}
+	query := `
+		SELECT MessageID, OfferID, SenderID, Body, CreatedAt
+		FROM TRADE_MESSAGES
+		WHERE OfferID = ? AND MessageID > ?
+		ORDER BY MessageID
+		LIMIT ?
+	`
+	rows, err := s.db.QueryContext(ctx, query, offerID, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying trade messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m TradeMessage
+		if err := rows.Scan(&m.MessageID, &m.OfferID, &m.SenderID, &m.Body, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning trade message row: %w", err)
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating trade messages: %w", err)
+	}
+	return messages, nil
+}
+
+// ExpireTradeOffers moves every pending offer past its deadline to expired and returns the ones it changed.
+// Each row is updated on its own with the status in the WHERE clause, so when several api instances
+// sweep at once every offer is only reported by the instance that actually expired it.
+func (s *SQLStore) ExpireTradeOffers(ctx context.Context, now time.Time) ([]TradeOffer, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + tradeColumns + ` FROM TRADE WHERE CurrentStatus = 'pending' AND ExpiresAt <= ?`
+	rows, err := s.db.QueryContext(ctx, query, now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying expired offers: %w", err)
+	}
+	stale := []TradeOffer{}
+	for rows.Next() {
+		o, err := scanTradeOffer(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning trade offer row: %w", err)
+		}
+		stale = append(stale, o)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("error iterating expired offers: %w", err)
+	}
+	rows.Close()
+
+	expired := []TradeOffer{}
+	for _, o := range stale {
+		changed, err := s.expireTradeOffer(ctx, o.OfferID)
+		if err != nil {
+			return expired, err
+		}
+		if changed {
+			o.CurrentStatus = "expired"
+			expired = append(expired, o)
+		}
+	}
+
+	if err := loadTradeItems(ctx, s.db, expired); err != nil {
+		return expired, err
+	}
+	return expired, nil
+}
+
+// GamesInActiveTrade returns which of the given games are part of an accepted trade that hasn't finished.
+func (s *SQLStore) GamesInActiveTrade(ctx context.Context, gameIDs []int) ([]int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return gamesInActiveTrade(ctx, s.db, gameIDs, 0)
+}
+
+func gamesInActiveTrade(ctx context.Context, q queryer, gameIDs []int, excludeOfferID int) ([]int, error) {
+	busy := []int{}
+	if len(gameIDs) == 0 {
+		return busy, nil
+	}
+
+	args := make([]any, 0, len(gameIDs)+len(activeTradeStatuses)+1)
+	gamePlaceholders := make([]string, 0, len(gameIDs))
+	for _, id := range gameIDs {
+		gamePlaceholders = append(gamePlaceholders, "?")
+		args = append(args, id)
+	}
+	statusPlaceholders := make([]string, 0, len(activeTradeStatuses))
+	for _, st := range activeTradeStatuses {
+		statusPlaceholders = append(statusPlaceholders, "?")
+		args = append(args, st)
+	}
+
+	query := `
+		SELECT DISTINCT ti.GameID
+		FROM TRADE_ITEMS ti
+		JOIN TRADE t ON t.OfferID = ti.OfferID
+		WHERE ti.GameID IN (` + strings.Join(gamePlaceholders, ",") + `)
+		  AND t.CurrentStatus IN (` + strings.Join(statusPlaceholders, ",") + `)
+		  AND t.OfferID <> ?
+	`
+	args = append(args, excludeOfferID)
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying active trades: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning active trade row: %w", err)
+		}
+		busy = append(busy, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating active trades: %w", err)
+	}
+	return busy, nil
+}
+
+// lockTradeForParty locks an offer row for the rest of the transaction and checks userID is on it.
+func lockTradeForParty(ctx context.Context, tx *dialectTx, offerID int, userID int) (TradeOffer, error) {
+	o, err := scanTradeOffer(tx.QueryRowContext(ctx, `SELECT `+tradeColumns+` FROM TRADE WHERE OfferID=? FOR UPDATE`, offerID))
+	if err == sql.ErrNoRows {
+		return TradeOffer{}, notFound("trade offer")
+	}
+	if err != nil {
+		return TradeOffer{}, fmt.Errorf("error reading trade offer: %w", err)
+	}
+	if o.RequesterID != userID && o.OwnerUserID != userID {
+		return TradeOffer{}, newError(ErrForbidden, "You are not part of this trade")
+	}
+	return o, nil
+}
+
+// MarkTradeShipped records that userID has posted their side of the trade.
+// Once both sides have shipped the offer moves to shipped.
+func (s *SQLStore) MarkTradeShipped(ctx context.Context, offerID int, userID int, tracking string) (TradeOffer, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return TradeOffer{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	o, err := lockTradeForParty(ctx, tx, offerID, userID)
+	if err != nil {
+		return TradeOffer{}, err
+	}
+	if o.CurrentStatus != TradeStatusAwaitingShipment {
+		return TradeOffer{}, newError(ErrConflict, "Offer is not awaiting shipment")
+	}
+
+	now := time.Now().UTC()
+	if userID == o.RequesterID {
+		if o.Shipment.RequesterShippedAt != nil {
+			return TradeOffer{}, newError(ErrConflict, "Already marked as shipped")
+		}
+		o.Shipment.RequesterTracking = tracking
+		o.Shipment.RequesterShippedAt = &now
+		_, err = tx.ExecContext(ctx, `UPDATE TRADE SET RequesterTracking=?, RequesterShippedAt=?, UpdatedAt=? WHERE OfferID=?`, tracking, now, now, offerID)
+	} else {
+		if o.Shipment.OwnerShippedAt != nil {
+			return TradeOffer{}, newError(ErrConflict, "Already marked as shipped")
+		}
+		o.Shipment.OwnerTracking = tracking
+		o.Shipment.OwnerShippedAt = &now
+		_, err = tx.ExecContext(ctx, `UPDATE TRADE SET OwnerTracking=?, OwnerShippedAt=?, UpdatedAt=? WHERE OfferID=?`, tracking, now, now, offerID)
+	}
+	if err != nil {
+		return TradeOffer{}, fmt.Errorf("error updating shipment: %w", err)
+	}
+
+	note := "Tracking number " + tracking
+	if o.Shipment.RequesterShippedAt != nil && o.Shipment.OwnerShippedAt != nil {
+		if _, err := setTradeStatus(ctx, tx, offerID, o.CurrentStatus, TradeStatusShipped, userID, note); err != nil {
+			return TradeOffer{}, err
+		}
+		o.CurrentStatus = TradeStatusShipped
+	} else if err := recordTradeEvent(ctx, tx, offerID, userID, TradeStatusShipped, o.CurrentStatus, o.CurrentStatus, note); err != nil {
+		return TradeOffer{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return TradeOffer{}, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return o, nil
+}
+
+// ConfirmTradeReceipt records that userID got the other party's games. When both sides
+// have confirmed, every game changes hands and the offer is completed.
+func (s *SQLStore) ConfirmTradeReceipt(ctx context.Context, offerID int, userID int) (TradeOffer, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return TradeOffer{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	o, err := lockTradeForParty(ctx, tx, offerID, userID)
+	if err != nil {
+		return TradeOffer{}, err
+	}
+	if o.CurrentStatus != TradeStatusShipped && o.CurrentStatus != TradeStatusReceived {
+		return TradeOffer{}, newError(ErrConflict, "Offer has not been shipped")
+	}
+
+	now := time.Now().UTC()
+	if userID == o.RequesterID {
+		if o.Shipment.RequesterReceivedAt != nil {
+			return TradeOffer{}, newError(ErrConflict, "Already marked as received")
+		}
+		o.Shipment.RequesterReceivedAt = &now
+		_, err = tx.ExecContext(ctx, `UPDATE TRADE SET RequesterReceivedAt=?, UpdatedAt=? WHERE OfferID=?`, now, now, offerID)
+	} else {
+		if o.Shipment.OwnerReceivedAt != nil {
+			return TradeOffer{}, newError(ErrConflict, "Already marked as received")
+		}
+		o.Shipment.OwnerReceivedAt = &now
+		_, err = tx.ExecContext(ctx, `UPDATE TRADE SET OwnerReceivedAt=?, UpdatedAt=? WHERE OfferID=?`, now, now, offerID)
+	}
+	if err != nil {
+		return TradeOffer{}, fmt.Errorf("error updating receipt: %w", err)
+	}
+
+	if o.Shipment.RequesterReceivedAt == nil || o.Shipment.OwnerReceivedAt == nil {
+		if _, err := setTradeStatus(ctx, tx, offerID, o.CurrentStatus, TradeStatusReceived, userID, ""); err != nil {
+			return TradeOffer{}, err
+		}
+		o.CurrentStatus = TradeStatusReceived
+	} else {
+		if err := completeTrade(ctx, tx, &o, userID); err != nil {
+			return TradeOffer{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return TradeOffer{}, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return o, nil
+}
+
+// completeTrade swaps the owner of every game in the offer and marks it completed.
+func completeTrade(ctx context.Context, tx *dialectTx, o *TradeOffer, actorID int) error {
+	offers := []TradeOffer{*o}
+	if err := loadTradeItems(ctx, tx, offers); err != nil {
+		return err
+	}
+	*o = offers[0]
+
+	for _, gameID := range o.GameRequestedIDs {
+		if err := checkGameOwner(ctx, tx, gameID, o.OwnerUserID); err != nil {
+			if errors.Is(err, ErrOwnershipChanged) {
+				return newError(ErrOwnershipChanged, "Requested game owner changed")
+			}
+			return err
+		}
+	}
+	for _, gameID := range o.GameOfferedIDs {
+		if err := checkGameOwner(ctx, tx, gameID, o.RequesterID); err != nil {
+			if errors.Is(err, ErrOwnershipChanged) {
+				return newError(ErrOwnershipChanged, "Offered game owner changed")
+			}
+			return err
+		}
+	}
+
+	// Swap owners
+	for _, gameID := range o.GameRequestedIDs {
+		if err := transferGame(ctx, tx, gameID, o.OwnerUserID, o.RequesterID, o.OfferID); err != nil {
+			return err
+		}
+	}
+	for _, gameID := range o.GameOfferedIDs {
+		if err := transferGame(ctx, tx, gameID, o.RequesterID, o.OwnerUserID, o.OfferID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := setTradeStatus(ctx, tx, o.OfferID, o.CurrentStatus, TradeStatusCompleted, actorID, ""); err != nil {
+		return err
+	}
+	o.CurrentStatus = TradeStatusCompleted
+	return nil
+}
+
+const disputeColumns = `DisputeID, OfferID, OpenedBy, Reason, Evidence, Status, PreviousStatus, Resolution,
+	ResolutionNote, PenalisedUserID, ResolvedBy, CreatedAt, ResolvedAt`
+
+func scanTradeDispute(row rowScanner) (TradeDispute, error) {
+	var d TradeDispute
+	var resolution, note sql.NullString
+	var penalised, resolvedBy sql.NullInt64
+	var resolvedAt sql.NullTime
+	if err := row.Scan(&d.DisputeID, &d.OfferID, &d.OpenedBy, &d.Reason, &d.Evidence, &d.Status, &d.PreviousStatus,
+		&resolution, &note, &penalised, &resolvedBy, &d.CreatedAt, &resolvedAt); err != nil {
+		return TradeDispute{}, err
+	}
+	d.Resolution = resolution.String
+	d.ResolutionNote = note.String
+	d.PenalisedUserID = nullIntPtr(penalised)
+	d.ResolvedBy = nullIntPtr(resolvedBy)
+	d.ResolvedAt = nullTimePtr(resolvedAt)
+	return d, nil
+}
+
+func queryTradeDisputes(ctx context.Context, q queryer, query string, args ...any) ([]TradeDispute, error) {
+	disputes := []TradeDispute{}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying disputes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanTradeDispute(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning dispute row: %w", err)
+		}
+		disputes = append(disputes, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating disputes: %w", err)
+	}
+	return disputes, nil
+}
+
+// OpenTradeDispute puts an accepted trade on hold until a moderator looks at it.
+func (s *SQLStore) OpenTradeDispute(ctx context.Context, offerID int, userID int, reason string, evidence string) (TradeDispute, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return TradeDispute{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	o, err := lockTradeForParty(ctx, tx, offerID, userID)
+	if err != nil {
+		return TradeDispute{}, err
+	}
+	if o.CurrentStatus == TradeStatusDisputed {
+		return TradeDispute{}, newError(ErrConflict, "Offer is already disputed")
+	}
+	if !disputableStatuses[o.CurrentStatus] {
+		return TradeDispute{}, newError(ErrConflict, "Only accepted trades can be disputed")
+	}
+
+	now := time.Now().UTC()
+	query := `INSERT INTO TRADE_DISPUTES (OfferID, OpenedBy, Reason, Evidence, Status, PreviousStatus, CreatedAt) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, offerID, userID, reason, evidence, DisputeStatusOpen, o.CurrentStatus, now)
+	if err != nil {
+		return TradeDispute{}, fmt.Errorf("error inserting dispute: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return TradeDispute{}, fmt.Errorf("error getting dispute: %w", err)
+	}
+
+	if _, err := setTradeStatus(ctx, tx, offerID, o.CurrentStatus, TradeStatusDisputed, userID, reason); err != nil {
+		return TradeDispute{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return TradeDispute{}, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return TradeDispute{
+		DisputeID:      int(id),
+		OfferID:        offerID,
+		OpenedBy:       userID,
+		Reason:         reason,
+		Evidence:       evidence,
+		Status:         DisputeStatusOpen,
+		PreviousStatus: o.CurrentStatus,
+		CreatedAt:      now,
+	}, nil
+}
+
+func (s *SQLStore) GetTradeDisputes(ctx context.Context, offerID int) ([]TradeDispute, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + disputeColumns + ` FROM TRADE_DISPUTES WHERE OfferID = ? ORDER BY DisputeID`
+	return queryTradeDisputes(ctx, s.db, query, offerID)
+}
+
+// ListDisputes is the moderator queue, optionally filtered by status, oldest first.
+func (s *SQLStore) ListDisputes(ctx context.Context, status string, afterID int, limit int) ([]TradeDispute, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + disputeColumns + ` FROM TRADE_DISPUTES WHERE DisputeID > ?`
+	args := []any{afterID}
+	if status != "" {
+		query += ` AND Status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY DisputeID LIMIT ?`
+	args = append(args, limit)
+	return queryTradeDisputes(ctx, s.db, query, args...)
+}
+
+func (s *SQLStore) GetTradeDispute(ctx context.Context, disputeID int) (TradeDispute, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + disputeColumns + ` FROM TRADE_DISPUTES WHERE DisputeID = ?`
+	d, err := scanTradeDispute(s.db.QueryRowContext(ctx, query, disputeID))
+	if err == sql.ErrNoRows {
+		return TradeDispute{}, notFound("dispute")
+	}
+	if err != nil {
+		return TradeDispute{}, fmt.Errorf("error getting dispute: %w", err)
+	}
+	return d, nil
+}
+
+// ResolveTradeDispute applies a moderator's ruling in one transaction. A revert hands every game
+// back to who had it before the trade (only needed if it had completed) and ends the trade as reverted,
+// a close puts the offer back where it was when the dispute was opened.
+func (s *SQLStore) ResolveTradeDispute(ctx context.Context, disputeID int, moderatorID int, ruling TradeDisputeResolution) (TradeDispute, TradeOffer, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return TradeDispute{}, TradeOffer{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	d, err := scanTradeDispute(tx.QueryRowContext(ctx, `SELECT `+disputeColumns+` FROM TRADE_DISPUTES WHERE DisputeID = ? FOR UPDATE`, disputeID))
+	if err == sql.ErrNoRows {
+		return TradeDispute{}, TradeOffer{}, notFound("dispute")
+	}
+	if err != nil {
+		return TradeDispute{}, TradeOffer{}, fmt.Errorf("error getting dispute: %w", err)
+	}
+	if d.Status != DisputeStatusOpen {
+		return TradeDispute{}, TradeOffer{}, newError(ErrConflict, "Dispute is already resolved")
+	}
+
+	o, err := scanTradeOffer(tx.QueryRowContext(ctx, `SELECT `+tradeColumns+` FROM TRADE WHERE OfferID=? FOR UPDATE`, d.OfferID))
+	if err != nil {
+		return TradeDispute{}, TradeOffer{}, fmt.Errorf("error reading trade offer: %w", err)
+	}
+	offers := []TradeOffer{o}
+	if err := loadTradeItems(ctx, tx, offers); err != nil {
+		return TradeDispute{}, TradeOffer{}, err
+	}
+	o = offers[0]
+
+	if ruling.PenaliseUserID != 0 && ruling.PenaliseUserID != o.RequesterID && ruling.PenaliseUserID != o.OwnerUserID {
+		return TradeDispute{}, TradeOffer{}, newError(ErrInvalid, "Only a party to the trade can be penalised")
+	}
+
+	switch ruling.Resolution {
+	case DisputeResolutionRevert:
+		if d.PreviousStatus == TradeStatusCompleted {
+			if err := revertTrade(ctx, tx, o); err != nil {
+				return TradeDispute{}, TradeOffer{}, err
+			}
+		}
+		if _, err := setTradeStatus(ctx, tx, o.OfferID, TradeStatusDisputed, TradeStatusReverted, moderatorID, ruling.Note); err != nil {
+			return TradeDispute{}, TradeOffer{}, err
+		}
+		o.CurrentStatus = TradeStatusReverted
+	case DisputeResolutionClose:
+		if _, err := setTradeStatus(ctx, tx, o.OfferID, TradeStatusDisputed, d.PreviousStatus, moderatorID, ruling.Note); err != nil {
+			return TradeDispute{}, TradeOffer{}, err
+		}
+		o.CurrentStatus = d.PreviousStatus
+	default:
+		return TradeDispute{}, TradeOffer{}, newError(ErrInvalid, "Invalid resolution")
+	}
+
+	var penalised any
+	if ruling.PenaliseUserID != 0 {
+		penalised = ruling.PenaliseUserID
+		if _, err := tx.ExecContext(ctx, `UPDATE USERS SET Strikes = Strikes + 1 WHERE UserID = ?`, ruling.PenaliseUserID); err != nil {
+			return TradeDispute{}, TradeOffer{}, fmt.Errorf("error penalising user: %w", err)
+		}
+		d.PenalisedUserID = &ruling.PenaliseUserID
+	}
+
+	now := time.Now().UTC()
+	query := `
+		UPDATE TRADE_DISPUTES
+		SET Status = ?, Resolution = ?, ResolutionNote = ?, PenalisedUserID = ?, ResolvedBy = ?, ResolvedAt = ?
+		WHERE DisputeID = ?
+	`
+	if _, err := tx.ExecContext(ctx, query, DisputeStatusResolved, ruling.Resolution, ruling.Note, penalised, moderatorID, now, disputeID); err != nil {
+		return TradeDispute{}, TradeOffer{}, fmt.Errorf("error resolving dispute: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return TradeDispute{}, TradeOffer{}, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	d.Status = DisputeStatusResolved
+	d.Resolution = ruling.Resolution
+	d.ResolutionNote = ruling.Note
+	d.ResolvedBy = &moderatorID
+	d.ResolvedAt = &now
+	return d, o, nil
+}
+
+// revertTrade hands every game in a completed trade back. It refuses if any game has
+// changed hands again since, the moderator has to sort that out by hand.
+func revertTrade(ctx context.Context, tx *dialectTx, o TradeOffer) error {
+	for _, gameID := range o.GameRequestedIDs {
+		if err := checkGameOwner(ctx, tx, gameID, o.RequesterID); err != nil {
+			if errors.Is(err, ErrOwnershipChanged) {
+				return newError(ErrOwnershipChanged, "Game has changed hands since the trade")
+			}
+			return err
+		}
+	}
+	for _, gameID := range o.GameOfferedIDs {
+		if err := checkGameOwner(ctx, tx, gameID, o.OwnerUserID); err != nil {
+			if errors.Is(err, ErrOwnershipChanged) {
+				return newError(ErrOwnershipChanged, "Game has changed hands since the trade")
+			}
+			return err
+		}
+	}
+
+	busy, err := gamesInActiveTrade(ctx, tx, append(append([]int{}, o.GameRequestedIDs...), o.GameOfferedIDs...), o.OfferID)
+	if err != nil {
+		return err
+	}
+	if len(busy) > 0 {
+		return newError(ErrConflict, "Game is already in an active trade")
+	}
+
+	for _, gameID := range o.GameRequestedIDs {
+		if err := transferGame(ctx, tx, gameID, o.RequesterID, o.OwnerUserID, o.OfferID); err != nil {
+			return err
+		}
+	}
+	for _, gameID := range o.GameOfferedIDs {
+		if err := transferGame(ctx, tx, gameID, o.OwnerUserID, o.RequesterID, o.OfferID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateTradeReview stores userID's rating of the other party. The offer row is locked while
+// checking for an earlier review so two requests from the same reviewer can't both get through.
+func (s *SQLStore) CreateTradeReview(ctx context.Context, offerID int, reviewerID int, rating int, comment string) (TradeReview, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return TradeReview{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	o, err := lockTradeForParty(ctx, tx, offerID, reviewerID)
+	if err != nil {
+		return TradeReview{}, err
+	}
+	if o.CurrentStatus != TradeStatusCompleted {
+		return TradeReview{}, newError(ErrConflict, "Only completed trades can be reviewed")
+	}
+
+	var existing int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM TRADE_REVIEWS WHERE OfferID=? AND ReviewerID=?`, offerID, reviewerID).Scan(&existing); err != nil {
+		return TradeReview{}, fmt.Errorf("error checking reviews: %w", err)
+	}
+	if existing > 0 {
+		return TradeReview{}, newError(ErrConflict, "You have already reviewed this trade")
+	}
+
+	reviewee := o.OwnerUserID
+	if reviewerID == o.OwnerUserID {
+		reviewee = o.RequesterID
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	query := `INSERT INTO TRADE_REVIEWS (OfferID, ReviewerID, RevieweeID, Rating, Comment, CreatedAt) VALUES (?, ?, ?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, offerID, reviewerID, reviewee, rating, comment, now)
+	if err != nil {
+		return TradeReview{}, fmt.Errorf("error inserting review: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return TradeReview{}, fmt.Errorf("error getting review: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return TradeReview{}, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return TradeReview{
+		ReviewID:   int(id),
+		OfferID:    offerID,
+		ReviewerID: reviewerID,
+		RevieweeID: reviewee,
+		Rating:     rating,
+		Comment:    comment,
+		CreatedAt:  now,
+	}, nil
+}
+
+func (s *SQLStore) GetTradeReviews(ctx context.Context, offerID int) ([]TradeReview, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	reviews := []TradeReview{}
+	query := `
+		SELECT ReviewID, OfferID, ReviewerID, RevieweeID, Rating, Comment, CreatedAt
+		FROM TRADE_REVIEWS
+		WHERE OfferID = ?
+		ORDER BY ReviewID
+	`
+	rows, err := s.db.QueryContext(ctx, query, offerID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying reviews: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rv TradeReview
+		if err := rows.Scan(&rv.ReviewID, &rv.OfferID, &rv.ReviewerID, &rv.RevieweeID, &rv.Rating, &rv.Comment, &rv.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning review row: %w", err)
+		}
+		reviews = append(reviews, rv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reviews: %w", err)
+	}
+	return reviews, nil
+}
+
+// GetReputations returns the reputation of every given user, users without reviews get a zero value.
+func (s *SQLStore) GetReputations(ctx context.Context, userIDs []int) (map[int]Reputation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	reps := make(map[int]Reputation, len(userIDs))
+	if len(userIDs) == 0 {
+		return reps, nil
+	}
+
+	placeholders := make([]string, 0, len(userIDs))
+	args := make([]any, 0, len(userIDs))
+	for _, id := range userIDs {
+		reps[id] = Reputation{}
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+
+	query := `
+		SELECT RevieweeID, AVG(Rating), COUNT(*)
+		FROM TRADE_REVIEWS
+		WHERE RevieweeID IN (` + strings.Join(placeholders, ",") + `)
+		GROUP BY RevieweeID
+	`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying reputation: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var rep Reputation
+		if err := rows.Scan(&id, &rep.AverageRating, &rep.ReviewCount); err != nil {
+			return nil, fmt.Errorf("error scanning reputation row: %w", err)
+		}
+		reps[id] = rep
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reputation: %w", err)
+	}
+	return reps, nil
+}
+
+func (s *SQLStore) GetUserReputation(ctx context.Context, userID int) (Reputation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	reps, err := s.GetReputations(ctx, []int{userID})
+	if err != nil {
+		return Reputation{}, err
+	}
+	return reps[userID], nil
+}
+
+func (s *SQLStore) AddWishlistItem(ctx context.Context, userID int, item WishlistRequest) (WishlistItem, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var platform, condition any
+	if item.Platform != "" {
+		platform = item.Platform
+	}
+	if item.Condition != "" {
+		condition = item.Condition
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	query := `INSERT INTO WISHLIST (UserID, Title, Platform, Quality, CreatedAt) VALUES (?, ?, ?, ?, ?)`
+	result, err := s.db.ExecContext(ctx, query, userID, item.Title, platform, condition, now)
+	if err != nil {
+		return WishlistItem{}, fmt.Errorf("error inserting wishlist item: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return WishlistItem{}, fmt.Errorf("error getting wishlist item: %w", err)
+	}
+	return WishlistItem{
+		WishID:    int(id),
+		UserID:    userID,
+		Title:     item.Title,
+		Platform:  item.Platform,
+		Condition: item.Condition,
+		CreatedAt: now,
+	}, nil
+}
+
+func (s *SQLStore) GetWishlist(ctx context.Context, userID int) ([]WishlistItem, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `SELECT WishID, UserID, Title, Platform, Quality, CreatedAt FROM WISHLIST WHERE UserID = ? ORDER BY WishID`
+	return queryWishlist(ctx, s.db, query, userID)
+}
+
+func queryWishlist(ctx context.Context, q queryer, query string, args ...any) ([]WishlistItem, error) {
+	items := []WishlistItem{}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying wishlist: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item WishlistItem
+		var platform, condition sql.NullString
+		if err := rows.Scan(&item.WishID, &item.UserID, &item.Title, &platform, &condition, &item.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning wishlist row: %w", err)
+		}
+		item.Platform = platform.String
+		item.Condition = condition.String
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating wishlist: %w", err)
+	}
+	return items, nil
+}
+
+func (s *SQLStore) DeleteWishlistItem(ctx context.Context, userID int, wishID int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, `DELETE FROM WISHLIST WHERE WishID = ? AND UserID = ?`, wishID, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting wishlist item: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return notFound("wishlist item")
+	}
+	return nil
+}
+
+// wishMatchesGame is the condition for a WISHLIST row w matching a GAMES row g. The title
+// is a case-insensitive substring match so a wish for "Zelda" finds "The Legend of Zelda".
+const wishMatchesGame = `
+	INSTR(LOWER(g.Title), LOWER(w.Title)) > 0
+	AND (w.Platform IS NULL OR LOWER(g.Platform) = LOWER(w.Platform))
+	AND (w.Quality IS NULL OR LOWER(g.Quality) = LOWER(w.Quality))
+`
+
+// GetWishlistMatches pages through games from other owners that match any of userID's wishes,
+// leaving out games already promised in an accepted trade.
+func (s *SQLStore) GetWishlistMatches(ctx context.Context, userID int, afterID int, limit int) ([]OwnedGame, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	games := []OwnedGame{}
+	statusPlaceholders := make([]string, 0, len(activeTradeStatuses))
+	args := []any{userID, afterID, userID}
+	for _, st := range activeTradeStatuses {
+		statusPlaceholders = append(statusPlaceholders, "?")
+		args = append(args, st)
+	}
+	args = append(args, limit)
+
+	query := `
+		SELECT g.GameID, g.Title, g.Publisher, g.Description, g.Platform, g.Year, g.Quality, g.OwnerUserID, g.PreviousOwners
+		FROM GAMES g
+		WHERE g.OwnerUserID <> ?
+		  AND g.GameID > ?
+		  AND EXISTS (SELECT 1 FROM WISHLIST w WHERE w.UserID = ? AND ` + wishMatchesGame + `)
+		  AND NOT EXISTS (
+			SELECT 1 FROM TRADE_ITEMS ti JOIN TRADE t ON t.OfferID = ti.OfferID
+			WHERE ti.GameID = g.GameID AND t.CurrentStatus IN (` + strings.Join(statusPlaceholders, ",") + `)
+		  )
+		ORDER BY g.GameID
+		LIMIT ?
+	`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying wishlist matches: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var game OwnedGame
+		if err := rows.Scan(&game.ID, &game.Title, &game.Publisher, &game.Description, &game.Platform, &game.Year, &game.Condition, &game.OwnerUserID, &game.PreviousOwners); err != nil {
+			return nil, fmt.Errorf("error scanning game row: %w", err)
+		}
+		games = append(games, game)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating wishlist matches: %w", err)
+	}
+	return games, nil
+}
+
+// GetWishersForGame returns one matching wish per user who is looking for this game, other than its owner.
+func (s *SQLStore) GetWishersForGame(ctx context.Context, gameID int) ([]WishlistItem, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+		SELECT w.WishID, w.UserID, w.Title, w.Platform, w.Quality, w.CreatedAt
+		FROM WISHLIST w
+		JOIN GAMES g ON g.GameID = ?
+		WHERE w.UserID <> g.OwnerUserID
+		  AND ` + wishMatchesGame + `
+		ORDER BY w.UserID, w.WishID
+	`
+	items, err := queryWishlist(ctx, s.db, query, gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	wishers := []WishlistItem{}
+	seen := map[int]bool{}
+	for _, item := range items {
+		if seen[item.UserID] {
+			continue
+		}
+		seen[item.UserID] = true
+		wishers = append(wishers, item)
+	}
+	return wishers, nil
+}
+
+func (s *SQLStore) expireTradeOffer(ctx context.Context, offerID int) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	changed, err := setTradeStatus(ctx, tx, offerID, "pending", "expired", 0, "")
+	if err != nil || !changed {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return true, nil
+}
+
+// checkGameOwner locks the game row and makes sure it still belongs to ownerID.
+func checkGameOwner(ctx context.Context, tx *dialectTx, gameID int, ownerID int) error {
+	var currentOwner int
+	err := tx.QueryRowContext(ctx, `SELECT OwnerUserID FROM GAMES WHERE GameID=? FOR UPDATE`, gameID).Scan(&currentOwner)
+	if err == sql.ErrNoRows {
+		return notFound("game")
+	}
+	if err != nil {
+		return fmt.Errorf("error checking game owner: %w", err)
+	}
+	if currentOwner != ownerID {
+		return ErrOwnershipChanged
+	}
+	return nil
+}
+
+func (s *SQLStore) VerifyUser(ctx context.Context, email string, password string) int {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var hashedPassword string
+	var id int
+	query := "SELECT UserID, PasswordHash FROM USERS WHERE Email=?"
+	result := s.db.QueryRowContext(ctx, query, email).Scan(&id, &hashedPassword)
+	if result != nil {
+		return -1
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	if err != nil {
+		return -1
+	}
+	return id
+}
+
+func (s *SQLStore) GetUserIDByEmail(ctx context.Context, email string) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var id int
+	err := s.db.QueryRowContext(ctx, `SELECT UserID FROM USERS WHERE Email=?`, email).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, notFound("user")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error getting user: %w", err)
+	}
+	return id, nil
+}
+
+// CreatePasswordReset stores a new reset token hash and retires any the user still had outstanding,
+// so only the most recent email works. It returns ErrTooSoon, and stores nothing, when the user
+// already asked for one after notBefore.
+func (s *SQLStore) CreatePasswordReset(ctx context.Context, userID int, tokenHash string, expiresAt, notBefore time.Time) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var recent int
+	query := `SELECT COUNT(*) FROM PASSWORD_RESETS WHERE UserID=? AND CreatedAt > ?`
+	if err := tx.QueryRowContext(ctx, query, userID, notBefore.UTC()).Scan(&recent); err != nil {
+		return fmt.Errorf("error checking recent reset tokens: %w", err)
+	}
+	if recent > 0 {
+		return ErrTooSoon
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE PASSWORD_RESETS SET UsedAt=UTC_TIMESTAMP() WHERE UserID=? AND UsedAt IS NULL`, userID); err != nil {
+		return fmt.Errorf("error retiring old reset tokens: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO PASSWORD_RESETS (UserID, TokenHash, ExpiresAt, CreatedAt) VALUES (?, ?, ?, ?)`, userID, tokenHash, expiresAt.UTC(), time.Now().UTC()); err != nil {
+		return fmt.Errorf("error inserting reset token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// ResetPassword consumes a reset token, sets the new password and signs the user out everywhere.
+// It returns the ID of the user whose password changed.
+func (s *SQLStore) ResetPassword(ctx context.Context, tokenHash string, password string) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var resetID, userID int
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT ResetID, UserID, ExpiresAt, UsedAt
+		FROM PASSWORD_RESETS
+		WHERE TokenHash=?
+		FOR UPDATE
+	`, tokenHash).Scan(&resetID, &userID, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return 0, notFound("reset token")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading reset token: %w", err)
+	}
+	if usedAt.Valid {
+		return 0, newError(ErrInvalidToken, "reset token already used")
+	}
+	if time.Now().After(expiresAt) {
+		return 0, newError(ErrInvalidToken, "reset token expired")
+	}
+
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE USERS SET PasswordHash=? WHERE UserID=?`, hashed, userID); err != nil {
+		return 0, fmt.Errorf("error updating user password: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE PASSWORD_RESETS SET UsedAt=UTC_TIMESTAMP() WHERE ResetID=?`, resetID); err != nil {
+		return 0, fmt.Errorf("error marking reset token used: %w", err)
+	}
+	if err := revokeUserSessions(ctx, tx, userID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return userID, nil
+}
+
+func (s *SQLStore) IsEmailVerified(ctx context.Context, userID int) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var verified bool
+	err := s.db.QueryRowContext(ctx, `SELECT EmailVerified FROM USERS WHERE UserID=?`, userID).Scan(&verified)
+	if err == sql.ErrNoRows {
+		return false, notFound("user")
+	}
+	if err != nil {
+		return false, fmt.Errorf("error checking email verification: %w", err)
+	}
+	return verified, nil
+}
+
+// MarkEmailVerified only matches while the account still has the address the token was sent to.
+func (s *SQLStore) MarkEmailVerified(ctx context.Context, userID int, email string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, `UPDATE USERS SET EmailVerified=TRUE WHERE UserID=? AND Email=?`, userID, email)
+	if err != nil {
+		return fmt.Errorf("error verifying email: %w", err)
+	}
+	if aff, _ := result.RowsAffected(); aff > 0 {
+		return nil
+	}
+
+	// Nothing changed, either it was already verified or the token is for an address the user no longer has
+	var current string
+	var verified bool
+	err = s.db.QueryRowContext(ctx, `SELECT Email, EmailVerified FROM USERS WHERE UserID=?`, userID).Scan(&current, &verified)
+	if err == sql.ErrNoRows {
+		return notFound("user")
+	}
+	if err != nil {
+		return fmt.Errorf("error verifying email: %w", err)
+	}
+	if current != email {
+		return newError(ErrInvalidToken, "email changed since verification was sent")
+	}
+	return nil
+}
+
+// GetSigningKeys returns every key that can still verify tokens, newest first.
+func (s *SQLStore) GetSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	keys := []SigningKey{}
+	query := `
+		SELECT KeyID, Algorithm, PrivateKey, CreatedAt, RetiredAt, ExpiresAt
+		FROM SIGNING_KEYS
+		WHERE ExpiresAt IS NULL OR ExpiresAt > UTC_TIMESTAMP()
+		ORDER BY CreatedAt DESC
+	`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k SigningKey
+		if err := rows.Scan(&k.KeyID, &k.Algorithm, &k.PrivateKey, &k.CreatedAt, &k.RetiredAt, &k.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("error scanning signing key row: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating signing keys: %w", err)
+	}
+	return keys, nil
+}
+
+// signingKeyLock is the GET_LOCK name auth instances take turns on to rotate the signing key.
+const signingKeyLock = "gameAPI.signing_keys"
+
+// RotateSigningKey makes key the active signing key unless another instance already rotated
+// after notBefore. Keys it replaces keep verifying for the grace window. Reports whether key was stored.
+func (s *SQLStore) RotateSigningKey(ctx context.Context, key SigningKey, notBefore time.Time, grace time.Duration) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// SELECT ... FOR UPDATE can't serialize this on MySQL, with no active key there is no row to
+	// lock and two instances booting together both take a gap lock and deadlock on the INSERT.
+	// A named lock covers the empty table too. SQLite takes its write lock at BEGIN already.
+	if s.db.dialect.advisoryLocks {
+		release, err := s.advisoryLock(ctx, signingKeyLock)
+		if err != nil {
+			return false, err
+		}
+		defer release()
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Not MAX(CreatedAt), SQLite hands an aggregate back as text rather than a time
+	var newest time.Time
+	query := `SELECT CreatedAt FROM SIGNING_KEYS WHERE RetiredAt IS NULL ORDER BY CreatedAt DESC LIMIT 1`
+	err = tx.QueryRowContext(ctx, query).Scan(&newest)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("error checking active signing key: %w", err)
+	}
+	if err == nil && newest.After(notBefore) {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, `UPDATE SIGNING_KEYS SET RetiredAt=?, ExpiresAt=? WHERE RetiredAt IS NULL`, now, now.Add(grace)); err != nil {
+		return false, fmt.Errorf("error retiring signing keys: %w", err)
+	}
+	query = `INSERT INTO SIGNING_KEYS (KeyID, Algorithm, PrivateKey, CreatedAt) VALUES (?, ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, query, key.KeyID, key.Algorithm, key.PrivateKey, now); err != nil {
+		return false, fmt.Errorf("error inserting signing key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return true, nil
 }
@@ -0,0 +1,94 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+)
+
+// dialect is what differs between the databases SQLStore runs on. Queries in this package are
+// written for MySQL, a dialect with a rewrite func fixes up the parts its database reads differently.
+type dialect struct {
+	// driver is the database/sql driver name, and the directory under migrations/ with its schema
+	driver  string
+	rewrite func(query string) string
+	// advisoryLocks is whether GET_LOCK is there to keep instances from migrating at the same time
+	advisoryLocks bool
+	// countTables counts the tables in the database other than SCHEMA_MIGRATIONS
+	countTables string
+}
+
+var (
+	mysqlDialect = dialect{
+		driver:        "mysql",
+		advisoryLocks: true,
+		countTables:   `SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME <> 'SCHEMA_MIGRATIONS'`,
+	}
+	sqliteDialect = dialect{
+		driver:      "sqlite",
+		rewrite:     sqliteQuery,
+		countTables: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('SCHEMA_MIGRATIONS', 'sqlite_sequence')`,
+	}
+)
+
+var forUpdate = regexp.MustCompile(`\s+FOR UPDATE\b`)
+
+// sqliteQuery drops row locks, SQLite has none. Transactions there take the write lock when they
+// begin (_txlock=immediate) so they are already serialized. UTC_TIMESTAMP() becomes CURRENT_TIMESTAMP,
+// which SQLite keeps in UTC.
+func sqliteQuery(query string) string {
+	query = forUpdate.ReplaceAllString(query, "")
+	return strings.ReplaceAll(query, "UTC_TIMESTAMP()", "CURRENT_TIMESTAMP")
+}
+
+func (d dialect) query(query string) string {
+	if d.rewrite == nil {
+		return query
+	}
+	return d.rewrite(query)
+}
+
+// dialectDB is a *sql.DB that passes every query through its dialect first. Transactions
+// started from it do the same, so the rest of the package can stay written for MySQL.
+type dialectDB struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (db *dialectDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.dialect.query(query), args...)
+}
+
+func (db *dialectDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.dialect.query(query), args...)
+}
+
+func (db *dialectDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.dialect.query(query), args...)
+}
+
+func (db *dialectDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*dialectTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &dialectTx{Tx: tx, dialect: db.dialect}, nil
+}
+
+type dialectTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx *dialectTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.dialect.query(query), args...)
+}
+
+func (tx *dialectTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, tx.dialect.query(query), args...)
+}
+
+func (tx *dialectTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.dialect.query(query), args...)
+}
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Error kinds returned by this package. Handlers branch on them with errors.Is,
+// the message of the returned error is still meant for the client.
+var (
+	ErrNotFound     = errors.New("not found in database")
+	ErrConflict     = errors.New("conflict with the current state")
+	ErrForbidden    = errors.New("not allowed")
+	ErrInvalid      = errors.New("invalid request")
+	ErrInvalidToken = errors.New("invalid or expired token")
+
+	// These are narrower kinds of the ones above, errors.Is matches both
+	ErrOwnershipChanged = fmt.Errorf("game owner changed: %w", ErrConflict)
+	ErrDuplicateEmail   = fmt.Errorf("email is already registered: %w", ErrConflict)
+	ErrTokenReused      = fmt.Errorf("refresh token reused: %w", ErrInvalidToken)
+	ErrTooSoon          = fmt.Errorf("asked again too soon: %w", ErrConflict)
+)
+
+// kindError keeps the message the client sees separate from the kind handlers check for.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+func newError(kind error, msg string) error {
+	return &kindError{kind: kind, msg: msg}
+}
+
+// notFound gives the usual "<thing> not found in database" message.
+func notFound(thing string) error {
+	return fmt.Errorf("%s %w", thing, ErrNotFound)
+}
+
+// mysqlDuplicateEntry is ER_DUP_ENTRY, a UNIQUE or PRIMARY KEY was violated.
+const mysqlDuplicateEntry = 1062
+
+func isDuplicateKey(err error) bool {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return me.Number == mysqlDuplicateEntry
+	}
+	var se *sqlite.Error
+	if errors.As(err, &se) {
+		return se.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || se.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	return false
+}
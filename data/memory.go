@@ -0,0 +1,1847 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MemoryStore keeps everything in maps behind one mutex, for running the services and their
+// handlers without MySQL. Every method holds the lock for its whole run and checks everything
+// before it changes anything, so each call is all or nothing like a transaction in SQLStore.
+// The foreign key cascades from the schema are done by hand in the delete methods.
+// A context that is already done is refused before the lock is taken, there is nothing to
+// interrupt once a call is running.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	// lastID is the AUTO_INCREMENT counter of each table
+	lastID map[string]int
+
+	users         map[int]*memUser
+	refreshTokens map[string]*memToken
+	resets        map[string]*memToken
+	signingKeys   map[string]*SigningKey
+
+	games     map[int]*OwnedGame
+	transfers map[int]*GameTransfer
+	wishlist  map[int]*WishlistItem
+
+	trades   map[int]*TradeOffer
+	events   map[int]*TradeEvent
+	messages map[int]*TradeMessage
+	disputes map[int]*TradeDispute
+	reviews  map[int]*TradeReview
+}
+
+// memUser is a USERS row. Password holds the bcrypt hash, the same as GetUser returns it.
+type memUser struct {
+	User
+	TokenVersion int
+}
+
+// memToken is a REFRESH_TOKENS or PASSWORD_RESETS row, UsedAt doubles as RevokedAt and
+// Rotated stands in for ReplacedByID being set.
+type memToken struct {
+	UserID    int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	Rotated   bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		lastID:        map[string]int{},
+		users:         map[int]*memUser{},
+		refreshTokens: map[string]*memToken{},
+		resets:        map[string]*memToken{},
+		signingKeys:   map[string]*SigningKey{},
+		games:         map[int]*OwnedGame{},
+		transfers:     map[int]*GameTransfer{},
+		wishlist:      map[int]*WishlistItem{},
+		trades:        map[int]*TradeOffer{},
+		events:        map[int]*TradeEvent{},
+		messages:      map[int]*TradeMessage{},
+		disputes:      map[int]*TradeDispute{},
+		reviews:       map[int]*TradeReview{},
+	}
+}
+
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (m *MemoryStore) nextID(table string) int {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+func sortedIDs[V any](rows map[int]V) []int {
+	return slices.Sorted(maps.Keys(rows))
+}
+
+// optionalID is how a nullable ID column reads back, zero is NULL.
+func optionalID(id int) *int {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+func copyOffer(o *TradeOffer) TradeOffer {
+	c := *o
+	c.GameRequestedIDs = slices.Clone(o.GameRequestedIDs)
+	c.GameOfferedIDs = slices.Clone(o.GameOfferedIDs)
+	return c
+}
+
+// Users
+
+func (m *MemoryStore) CreateUser(ctx context.Context, user User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	hashed, err := hashPassword(user.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, user.Email) {
+			return 0, ErrDuplicateEmail
+		}
+	}
+
+	id := m.nextID("USERS")
+	m.users[id] = &memUser{User: User{
+		ID:            id,
+		Username:      user.Username,
+		Email:         user.Email,
+		Password:      hashed,
+		StreetAddress: user.StreetAddress,
+		Role:          RoleUser,
+	}}
+	return id, nil
+}
+
+func (m *MemoryStore) GetUser(ctx context.Context, userID int) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return User{}, notFound("user")
+	}
+	return User{
+		ID:            u.ID,
+		Username:      u.Username,
+		Email:         u.Email,
+		Password:      u.Password,
+		StreetAddress: u.StreetAddress,
+		Role:          u.Role,
+		EmailVerified: u.EmailVerified,
+		Strikes:       u.Strikes,
+	}, nil
+}
+
+func (m *MemoryStore) ListUsers(ctx context.Context, role string, afterID int, limit int) ([]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := []User{}
+	for _, id := range sortedIDs(m.users) {
+		u := m.users[id]
+		if id <= afterID || (role != "" && u.Role != role) {
+			continue
+		}
+		if len(users) == limit {
+			break
+		}
+		users = append(users, User{
+			ID:            u.ID,
+			Username:      u.Username,
+			Email:         u.Email,
+			StreetAddress: u.StreetAddress,
+			Role:          u.Role,
+			Strikes:       u.Strikes,
+		})
+	}
+	return users, nil
+}
+
+func (m *MemoryStore) GetUserRole(ctx context.Context, userID int) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return "", notFound("user")
+	}
+	return u.Role, nil
+}
+
+func (m *MemoryStore) UpdateUserRole(ctx context.Context, userID int, role string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return notFound("user")
+	}
+	u.Role = role
+	u.TokenVersion++
+	return nil
+}
+
+func (m *MemoryStore) UpdateUsername(ctx context.Context, userID int, username string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return notFound("user")
+	}
+	u.Username = username
+	return nil
+}
+
+func (m *MemoryStore) UpdateStreetAddress(ctx context.Context, userID int, streetAddress string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return notFound("user")
+	}
+	u.StreetAddress = streetAddress
+	return nil
+}
+
+func (m *MemoryStore) UpdateUserPassword(ctx context.Context, userID int, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return notFound("user")
+	}
+	u.Password = hashed
+	return m.revokeUserSessions(userID)
+}
+
+func (m *MemoryStore) CheckUserPassword(ctx context.Context, userID int, password string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	u, ok := m.users[userID]
+	var hashed string
+	if ok {
+		hashed = u.Password
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return false, notFound("user")
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) == nil, nil
+}
+
+func (m *MemoryStore) DeleteUserByID(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return notFound("user")
+	}
+	if m.hasOpenDispute(userID) {
+		return newError(ErrConflict, "User has an open dispute")
+	}
+	m.deleteUser(userID)
+	return nil
+}
+
+// hasOpenDispute is whether any trade the user is part of has a dispute waiting on a moderator.
+func (m *MemoryStore) hasOpenDispute(userID int) bool {
+	for _, d := range m.disputes {
+		o, ok := m.trades[d.OfferID]
+		if ok && d.Status == DisputeStatusOpen && (o.RequesterID == userID || o.OwnerUserID == userID) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteUser removes a user and everything that cascades from USERS.
+func (m *MemoryStore) deleteUser(userID int) {
+	delete(m.users, userID)
+
+	for hash, t := range m.refreshTokens {
+		if t.UserID == userID {
+			delete(m.refreshTokens, hash)
+		}
+	}
+	for hash, t := range m.resets {
+		if t.UserID == userID {
+			delete(m.resets, hash)
+		}
+	}
+	for id, w := range m.wishlist {
+		if w.UserID == userID {
+			delete(m.wishlist, id)
+		}
+	}
+	for id, g := range m.games {
+		if g.OwnerUserID == userID {
+			m.deleteGame(id)
+		}
+	}
+	for id, o := range m.trades {
+		if o.RequesterID == userID || o.OwnerUserID == userID {
+			m.deleteTrade(id)
+		}
+	}
+	for id, msg := range m.messages {
+		if msg.SenderID == userID {
+			delete(m.messages, id)
+		}
+	}
+	for id, d := range m.disputes {
+		if d.OpenedBy == userID {
+			delete(m.disputes, id)
+			continue
+		}
+		if d.PenalisedUserID != nil && *d.PenalisedUserID == userID {
+			d.PenalisedUserID = nil
+		}
+		if d.ResolvedBy != nil && *d.ResolvedBy == userID {
+			d.ResolvedBy = nil
+		}
+	}
+	for id, rv := range m.reviews {
+		if rv.ReviewerID == userID || rv.RevieweeID == userID {
+			delete(m.reviews, id)
+		}
+	}
+	for _, t := range m.transfers {
+		if t.FromUserID != nil && *t.FromUserID == userID {
+			t.FromUserID = nil
+		}
+		if t.ToUserID != nil && *t.ToUserID == userID {
+			t.ToUserID = nil
+		}
+	}
+	for _, e := range m.events {
+		if e.ActorID != nil && *e.ActorID == userID {
+			e.ActorID = nil
+		}
+	}
+}
+
+func (m *MemoryStore) GetEmailWithID(ctx context.Context, id int) string {
+	if ctx.Err() != nil {
+		return ""
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; ok {
+		return u.Email
+	}
+	return ""
+}
+
+// userByEmail matches case-insensitively like the column collation does.
+func (m *MemoryStore) userByEmail(email string) *memUser {
+	for _, id := range sortedIDs(m.users) {
+		if strings.EqualFold(m.users[id].Email, email) {
+			return m.users[id]
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetUserIDByEmail(ctx context.Context, email string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u := m.userByEmail(email)
+	if u == nil {
+		return 0, notFound("user")
+	}
+	return u.ID, nil
+}
+
+func (m *MemoryStore) VerifyUser(ctx context.Context, email string, password string) int {
+	if ctx.Err() != nil {
+		return -1
+	}
+	m.mu.Lock()
+	u := m.userByEmail(email)
+	var id int
+	var hashed string
+	if u != nil {
+		id, hashed = u.ID, u.Password
+	}
+	m.mu.Unlock()
+
+	if u == nil || bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) != nil {
+		return -1
+	}
+	return id
+}
+
+func (m *MemoryStore) IsEmailVerified(ctx context.Context, userID int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return false, notFound("user")
+	}
+	return u.EmailVerified, nil
+}
+
+func (m *MemoryStore) MarkEmailVerified(ctx context.Context, userID int, email string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return notFound("user")
+	}
+	if !strings.EqualFold(u.Email, email) {
+		return newError(ErrInvalidToken, "email changed since verification was sent")
+	}
+	u.EmailVerified = true
+	return nil
+}
+
+func (m *MemoryStore) CreatePasswordReset(ctx context.Context, userID int, tokenHash string, expiresAt, notBefore time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.resets {
+		if t.UserID == userID && t.CreatedAt.After(notBefore) {
+			return ErrTooSoon
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, t := range m.resets {
+		if t.UserID == userID && t.UsedAt == nil {
+			t.UsedAt = &now
+		}
+	}
+	m.resets[tokenHash] = &memToken{UserID: userID, CreatedAt: now, ExpiresAt: expiresAt.UTC()}
+	return nil
+}
+
+func (m *MemoryStore) ResetPassword(ctx context.Context, tokenHash string, password string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.resets[tokenHash]
+	if !ok {
+		return 0, notFound("reset token")
+	}
+	if t.UsedAt != nil {
+		return 0, newError(ErrInvalidToken, "reset token already used")
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return 0, newError(ErrInvalidToken, "reset token expired")
+	}
+	u, ok := m.users[t.UserID]
+	if !ok {
+		return 0, notFound("user")
+	}
+
+	now := time.Now().UTC()
+	u.Password = hashed
+	t.UsedAt = &now
+	if err := m.revokeUserSessions(t.UserID); err != nil {
+		return 0, err
+	}
+	return t.UserID, nil
+}
+
+func (m *MemoryStore) GetTokenVersion(ctx context.Context, userID int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return 0, notFound("user")
+	}
+	return u.TokenVersion, nil
+}
+
+func (m *MemoryStore) RevokeUserSessions(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.revokeUserSessions(userID)
+}
+
+func (m *MemoryStore) revokeUserSessions(userID int) error {
+	u, ok := m.users[userID]
+	if !ok {
+		return notFound("user")
+	}
+	u.TokenVersion++
+
+	now := time.Now().UTC()
+	for _, t := range m.refreshTokens {
+		if t.UserID == userID && t.UsedAt == nil {
+			t.UsedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateRefreshToken(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.refreshTokens[tokenHash] = &memToken{UserID: userID, ExpiresAt: expiresAt.UTC()}
+	return nil
+}
+
+func (m *MemoryStore) RotateRefreshToken(ctx context.Context, oldHash string, newHash string, expiresAt time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.refreshTokens[oldHash]
+	if !ok {
+		return 0, notFound("refresh token")
+	}
+	if t.Rotated {
+		if err := m.revokeUserSessions(t.UserID); err != nil {
+			return 0, err
+		}
+		return 0, ErrTokenReused
+	}
+	if t.UsedAt != nil {
+		return 0, newError(ErrInvalidToken, "refresh token revoked")
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return 0, newError(ErrInvalidToken, "refresh token expired")
+	}
+
+	now := time.Now().UTC()
+	m.refreshTokens[newHash] = &memToken{UserID: t.UserID, ExpiresAt: expiresAt.UTC()}
+	t.UsedAt = &now
+	t.Rotated = true
+	return t.UserID, nil
+}
+
+func (m *MemoryStore) RevokeRefreshToken(ctx context.Context, tokenHash string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.refreshTokens[tokenHash]
+	if !ok {
+		return 0, notFound("refresh token")
+	}
+	if t.UsedAt == nil {
+		now := time.Now().UTC()
+		t.UsedAt = &now
+	}
+	return t.UserID, nil
+}
+
+// Wishlists
+
+func (m *MemoryStore) AddWishlistItem(ctx context.Context, userID int, item WishlistRequest) (WishlistItem, error) {
+	if err := ctx.Err(); err != nil {
+		return WishlistItem{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := WishlistItem{
+		WishID:    m.nextID("WISHLIST"),
+		UserID:    userID,
+		Title:     item.Title,
+		Platform:  item.Platform,
+		Condition: item.Condition,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	m.wishlist[w.WishID] = &w
+	return w, nil
+}
+
+func (m *MemoryStore) GetWishlist(ctx context.Context, userID int) ([]WishlistItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []WishlistItem{}
+	for _, id := range sortedIDs(m.wishlist) {
+		if w := m.wishlist[id]; w.UserID == userID {
+			items = append(items, *w)
+		}
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) DeleteWishlistItem(ctx context.Context, userID int, wishID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.wishlist[wishID]
+	if !ok || w.UserID != userID {
+		return notFound("wishlist item")
+	}
+	delete(m.wishlist, wishID)
+	return nil
+}
+
+// wishMatches is wishMatchesGame for a single wish and game.
+func wishMatches(w *WishlistItem, g *OwnedGame) bool {
+	if !strings.Contains(strings.ToLower(g.Title), strings.ToLower(w.Title)) {
+		return false
+	}
+	if w.Platform != "" && !strings.EqualFold(g.Platform, w.Platform) {
+		return false
+	}
+	return w.Condition == "" || strings.EqualFold(g.Condition, w.Condition)
+}
+
+func (m *MemoryStore) GetWishlistMatches(ctx context.Context, userID int, afterID int, limit int) ([]OwnedGame, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var wishes []*WishlistItem
+	for _, id := range sortedIDs(m.wishlist) {
+		if w := m.wishlist[id]; w.UserID == userID {
+			wishes = append(wishes, w)
+		}
+	}
+
+	games := []OwnedGame{}
+	for _, id := range sortedIDs(m.games) {
+		if len(games) == limit {
+			break
+		}
+		g := m.games[id]
+		if g.OwnerUserID == userID || id <= afterID {
+			continue
+		}
+		if !slices.ContainsFunc(wishes, func(w *WishlistItem) bool { return wishMatches(w, g) }) {
+			continue
+		}
+		if len(m.gamesInActiveTrade([]int{id}, 0)) > 0 {
+			continue
+		}
+		games = append(games, *g)
+	}
+	return games, nil
+}
+
+func (m *MemoryStore) GetWishersForGame(ctx context.Context, gameID int) ([]WishlistItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wishers := []WishlistItem{}
+	g, ok := m.games[gameID]
+	if !ok {
+		return wishers, nil
+	}
+
+	var matches []*WishlistItem
+	for _, w := range m.wishlist {
+		if w.UserID != g.OwnerUserID && wishMatches(w, g) {
+			matches = append(matches, w)
+		}
+	}
+	slices.SortFunc(matches, func(a, b *WishlistItem) int {
+		return cmp.Or(cmp.Compare(a.UserID, b.UserID), cmp.Compare(a.WishID, b.WishID))
+	})
+
+	seen := map[int]bool{}
+	for _, w := range matches {
+		if seen[w.UserID] {
+			continue
+		}
+		seen[w.UserID] = true
+		wishers = append(wishers, *w)
+	}
+	return wishers, nil
+}
+
+// Signing keys
+
+func (m *MemoryStore) GetSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	keys := []SigningKey{}
+	for _, k := range m.signingKeys {
+		if !k.ExpiresAt.Valid || k.ExpiresAt.Time.After(now) {
+			keys = append(keys, *k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b SigningKey) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return keys, nil
+}
+
+func (m *MemoryStore) RotateSigningKey(ctx context.Context, key SigningKey, notBefore time.Time, grace time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.signingKeys {
+		if !k.RetiredAt.Valid && k.CreatedAt.After(notBefore) {
+			return false, nil
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, k := range m.signingKeys {
+		if !k.RetiredAt.Valid {
+			k.RetiredAt.Time, k.RetiredAt.Valid = now, true
+			k.ExpiresAt.Time, k.ExpiresAt.Valid = now.Add(grace), true
+		}
+	}
+	m.signingKeys[key.KeyID] = &SigningKey{
+		KeyID:      key.KeyID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: key.PrivateKey,
+		CreatedAt:  now,
+	}
+	return true, nil
+}
+
+// Games
+
+func (m *MemoryStore) CreateGame(ctx context.Context, game Game, userID int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.nextID("GAMES")
+	m.games[id] = &OwnedGame{
+		OwnerUserID: userID,
+		ID:          id,
+		Title:       game.Title,
+		Publisher:   game.Publisher,
+		Description: game.Description,
+		Platform:    game.Platform,
+		Year:        game.Year,
+		Condition:   game.Condition,
+	}
+	m.recordGameTransfer(id, 0, userID, 0)
+	return id, nil
+}
+
+func (m *MemoryStore) transferGame(gameID int, fromUserID int, toUserID int, offerID int) {
+	g := m.games[gameID]
+	g.OwnerUserID = toUserID
+	g.PreviousOwners++
+	m.recordGameTransfer(gameID, fromUserID, toUserID, offerID)
+}
+
+func (m *MemoryStore) recordGameTransfer(gameID int, fromUserID int, toUserID int, offerID int) {
+	id := m.nextID("GAME_OWNERSHIP")
+	m.transfers[id] = &GameTransfer{
+		TransferID:    id,
+		GameID:        gameID,
+		FromUserID:    optionalID(fromUserID),
+		ToUserID:      optionalID(toUserID),
+		OfferID:       optionalID(offerID),
+		TransferredAt: time.Now().UTC(),
+	}
+}
+
+func (m *MemoryStore) GetGameProvenance(ctx context.Context, gameID int) ([]GameTransfer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transfers := []GameTransfer{}
+	for _, id := range sortedIDs(m.transfers) {
+		if t := m.transfers[id]; t.GameID == gameID {
+			transfers = append(transfers, *t)
+		}
+	}
+	return transfers, nil
+}
+
+func gameOf(g *OwnedGame) Game {
+	return Game{
+		Title:       g.Title,
+		Publisher:   g.Publisher,
+		Description: g.Description,
+		Platform:    g.Platform,
+		Year:        g.Year,
+		Condition:   g.Condition,
+		ID:          g.ID,
+	}
+}
+
+func (m *MemoryStore) GetGameBYID(ctx context.Context, gameID int) (Game, error) {
+	if err := ctx.Err(); err != nil {
+		return Game{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g, ok := m.games[gameID]
+	if !ok {
+		return Game{}, notFound("game")
+	}
+	return gameOf(g), nil
+}
+
+func (m *MemoryStore) GetOwnedGameBYID(ctx context.Context, gameID int) (OwnedGame, error) {
+	if err := ctx.Err(); err != nil {
+		return OwnedGame{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g, ok := m.games[gameID]
+	if !ok {
+		return OwnedGame{}, notFound("game")
+	}
+	return *g, nil
+}
+
+func (m *MemoryStore) SearchGames(ctx context.Context, search GameSearch) (GamePage, error) {
+	if err := ctx.Err(); err != nil {
+		return GamePage{}, err
+	}
+	column, desc, err := gameSortColumn(search.Sort)
+	if err != nil {
+		return GamePage{}, err
+	}
+	backwards := search.Cursor != nil && search.Cursor.Before
+	ascending := desc == backwards
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	games := []OwnedGame{}
+	for _, g := range m.games {
+		if !searchMatches(search, g) {
+			continue
+		}
+		if search.Cursor != nil {
+			c := cmp.Compare(g.ID, search.Cursor.ID)
+			if column != "GameID" {
+				c = cmp.Or(compareGameColumn(g, column, search.Cursor.Value), c)
+			}
+			if (ascending && c <= 0) || (!ascending && c >= 0) {
+				continue
+			}
+		}
+		games = append(games, *g)
+	}
+
+	slices.SortFunc(games, func(a, b OwnedGame) int {
+		c := cmp.Compare(a.ID, b.ID)
+		if column != "GameID" {
+			c = cmp.Or(compareGameColumn(&a, column, gameSortValue(b, column)), c)
+		}
+		if !ascending {
+			return -c
+		}
+		return c
+	})
+	if len(games) > search.Limit+1 {
+		games = games[:search.Limit+1]
+	}
+	return gamePage(games, search, column), nil
+}
+
+// searchMatches applies the GameSearch filters, text is compared case-insensitively like MySQL does.
+func searchMatches(search GameSearch, g *OwnedGame) bool {
+	switch {
+	case search.Title != "" && !strings.Contains(strings.ToLower(g.Title), strings.ToLower(search.Title)):
+		return false
+	case search.Publisher != "" && !strings.Contains(strings.ToLower(g.Publisher), strings.ToLower(search.Publisher)):
+		return false
+	case search.Platform != "" && !strings.EqualFold(g.Platform, search.Platform):
+		return false
+	case search.Condition != "" && !strings.EqualFold(g.Condition, search.Condition):
+		return false
+	case search.YearFrom > 0 && g.Year < search.YearFrom:
+		return false
+	case search.YearTo > 0 && g.Year > search.YearTo:
+		return false
+	case search.OwnerID > 0 && g.OwnerUserID != search.OwnerID:
+		return false
+	case search.ExcludeOwnerID > 0 && g.OwnerUserID == search.ExcludeOwnerID:
+		return false
+	}
+	return true
+}
+
+// compareGameColumn compares a game's sort column with a cursor value.
+func compareGameColumn(g *OwnedGame, column string, value string) int {
+	switch column {
+	case "Year":
+		year, _ := strconv.Atoi(value)
+		return cmp.Compare(g.Year, year)
+	case "GameID":
+		id, _ := strconv.Atoi(value)
+		return cmp.Compare(g.ID, id)
+	default:
+		return strings.Compare(strings.ToLower(gameSortValue(*g, column)), strings.ToLower(value))
+	}
+}
+
+func (m *MemoryStore) updateGame(gameID int, update func(g *OwnedGame)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g, ok := m.games[gameID]
+	if !ok {
+		return notFound("game")
+	}
+	update(g)
+	return nil
+}
+
+func (m *MemoryStore) UpdateFullGame(ctx context.Context, gameID int, game GamePutRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.updateGame(gameID, func(g *OwnedGame) {
+		g.Title = game.Title
+		g.Publisher = game.Publisher
+		g.Description = game.Description
+		g.Platform = game.Platform
+		g.Year = game.Year
+		g.Condition = game.Condition
+	})
+}
+
+func (m *MemoryStore) UpdateGameTitle(ctx context.Context, gameID int, title string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.updateGame(gameID, func(g *OwnedGame) { g.Title = title })
+}
+
+func (m *MemoryStore) UpdateGameCondition(ctx context.Context, gameID int, condition string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.updateGame(gameID, func(g *OwnedGame) { g.Condition = condition })
+}
+
+func (m *MemoryStore) UpdateGameDescription(ctx context.Context, gameID int, description string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.updateGame(gameID, func(g *OwnedGame) { g.Description = description })
+}
+
+func (m *MemoryStore) UpdateGamePlatform(ctx context.Context, gameID int, platform string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.updateGame(gameID, func(g *OwnedGame) { g.Platform = platform })
+}
+
+func (m *MemoryStore) DeleteGameByID(ctx context.Context, gameID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.games[gameID]; !ok {
+		return notFound("game")
+	}
+	if len(m.gamesInActiveTrade([]int{gameID}, 0)) > 0 {
+		return newError(ErrConflict, "Game is in an active trade")
+	}
+	m.deleteGameAndOffers(gameID)
+	return nil
+}
+
+// deleteGameAndOffers is DeleteGameByID, the pending offers holding the game are voided and every
+// offer keeps its history.
+func (m *MemoryStore) deleteGameAndOffers(gameID int) {
+	note := "Game #" + strconv.Itoa(gameID) + " in this offer was deleted"
+	for _, id := range sortedIDs(m.trades) {
+		o := m.trades[id]
+		if o.CurrentStatus != TradeStatusPending {
+			continue
+		}
+		if slices.Contains(o.GameRequestedIDs, gameID) || slices.Contains(o.GameOfferedIDs, gameID) {
+			m.setTradeStatus(id, TradeStatusPending, "void", 0, note)
+		}
+	}
+	m.deleteGame(gameID)
+}
+
+// deleteGame removes a game with its provenance and takes it out of any offer still holding it.
+func (m *MemoryStore) deleteGame(gameID int) {
+	delete(m.games, gameID)
+	for id, t := range m.transfers {
+		if t.GameID == gameID {
+			delete(m.transfers, id)
+		}
+	}
+	isGame := func(id int) bool { return id == gameID }
+	for _, o := range m.trades {
+		o.GameRequestedIDs = slices.DeleteFunc(o.GameRequestedIDs, isGame)
+		o.GameOfferedIDs = slices.DeleteFunc(o.GameOfferedIDs, isGame)
+	}
+}
+
+// deleteTrade removes an offer and everything that cascades from TRADE.
+func (m *MemoryStore) deleteTrade(offerID int) {
+	delete(m.trades, offerID)
+	for _, o := range m.trades {
+		if o.ParentOfferID != nil && *o.ParentOfferID == offerID {
+			o.ParentOfferID = nil
+		}
+	}
+	for id, e := range m.events {
+		if e.OfferID == offerID {
+			delete(m.events, id)
+		}
+	}
+	for id, msg := range m.messages {
+		if msg.OfferID == offerID {
+			delete(m.messages, id)
+		}
+	}
+	for id, d := range m.disputes {
+		if d.OfferID == offerID {
+			delete(m.disputes, id)
+		}
+	}
+	for id, rv := range m.reviews {
+		if rv.OfferID == offerID {
+			delete(m.reviews, id)
+		}
+	}
+}
+
+// Trades
+
+func (m *MemoryStore) CreateTradeOffer(ctx context.Context, offer TradeOffer) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.insertTradeOffer(offer), nil
+}
+
+func (m *MemoryStore) CounterTradeOffer(ctx context.Context, parentID int, counter TradeOffer) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent, ok := m.trades[parentID]
+	if !ok {
+		return 0, notFound("trade offer")
+	}
+	if parent.CurrentStatus != "pending" {
+		return 0, newError(ErrConflict, "Offer is not pending")
+	}
+	if parent.Expired(time.Now()) {
+		return 0, newError(ErrConflict, "Offer has expired")
+	}
+
+	m.setTradeStatus(parentID, "pending", "countered", counter.RequesterID, "")
+	counter.ParentOfferID = &parentID
+	return m.insertTradeOffer(counter), nil
+}
+
+func (m *MemoryStore) insertTradeOffer(offer TradeOffer) int {
+	id := m.nextID("TRADE")
+	now := time.Now().UTC()
+
+	o := &TradeOffer{
+		OfferID:          id,
+		RequesterID:      offer.RequesterID,
+		OwnerUserID:      offer.OwnerUserID,
+		GameRequestedIDs: slices.Sorted(slices.Values(offer.GameRequestedIDs)),
+		GameOfferedIDs:   slices.Sorted(slices.Values(offer.GameOfferedIDs)),
+		CurrentStatus:    offer.CurrentStatus,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if o.GameRequestedIDs == nil {
+		o.GameRequestedIDs = []int{}
+	}
+	if o.GameOfferedIDs == nil {
+		o.GameOfferedIDs = []int{}
+	}
+	if offer.ParentOfferID != nil {
+		o.ParentOfferID = optionalID(*offer.ParentOfferID)
+	}
+	if offer.ExpiresAt != nil {
+		expires := offer.ExpiresAt.UTC()
+		o.ExpiresAt = &expires
+	}
+	m.trades[id] = o
+
+	note := ""
+	if offer.ParentOfferID != nil {
+		note = "Counter-offer to #" + strconv.Itoa(*offer.ParentOfferID)
+	}
+	m.recordTradeEvent(id, offer.RequesterID, TradeEventCreated, "", offer.CurrentStatus, note)
+	return id
+}
+
+// setTradeStatus is the in-memory setTradeStatus, false means the offer was not in the from status.
+func (m *MemoryStore) setTradeStatus(offerID int, from string, to string, actorID int, note string) bool {
+	o, ok := m.trades[offerID]
+	if !ok || o.CurrentStatus != from {
+		return false
+	}
+	o.CurrentStatus = to
+	o.UpdatedAt = time.Now().UTC()
+	m.recordTradeEvent(offerID, actorID, to, from, to, note)
+	return true
+}
+
+func (m *MemoryStore) recordTradeEvent(offerID int, actorID int, eventType string, from string, to string, note string) {
+	id := m.nextID("TRADE_EVENTS")
+	m.events[id] = &TradeEvent{
+		EventID:    id,
+		OfferID:    offerID,
+		ActorID:    optionalID(actorID),
+		EventType:  eventType,
+		FromStatus: from,
+		ToStatus:   to,
+		Note:       note,
+		CreatedAt:  time.Now().UTC(),
+	}
+}
+
+func (m *MemoryStore) GetTradeHistory(ctx context.Context, offerID int) ([]TradeEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := []TradeEvent{}
+	for _, id := range sortedIDs(m.events) {
+		if e := m.events[id]; e.OfferID == offerID {
+			events = append(events, *e)
+		}
+	}
+	return events, nil
+}
+
+func (m *MemoryStore) GetTradeThread(ctx context.Context, offerID int) ([]TradeOffer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	root, ok := m.trades[offerID]
+	if !ok {
+		return nil, notFound("trade offer")
+	}
+	for root.ParentOfferID != nil {
+		root = m.trades[*root.ParentOfferID]
+	}
+
+	thread := []TradeOffer{}
+	ids := sortedIDs(m.trades)
+	for next := root; next != nil; {
+		thread = append(thread, copyOffer(next))
+		parentID := next.OfferID
+		next = nil
+		for _, id := range ids {
+			if o := m.trades[id]; o.ParentOfferID != nil && *o.ParentOfferID == parentID {
+				next = o
+				break
+			}
+		}
+	}
+	return thread, nil
+}
+
+func (m *MemoryStore) GetTradeOfferByID(ctx context.Context, offerID int) (TradeOffer, error) {
+	if err := ctx.Err(); err != nil {
+		return TradeOffer{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.trades[offerID]
+	if !ok {
+		return TradeOffer{}, notFound("trade offer")
+	}
+	return copyOffer(o), nil
+}
+
+func (m *MemoryStore) GetIncomingTradeOffers(ctx context.Context, ownerID int) ([]TradeOffer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.listTradeOffers(func(o *TradeOffer) bool { return o.OwnerUserID == ownerID }), nil
+}
+
+func (m *MemoryStore) GetOutgoingTradeOffers(ctx context.Context, requesterID int) ([]TradeOffer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.listTradeOffers(func(o *TradeOffer) bool { return o.RequesterID == requesterID }), nil
+}
+
+// listTradeOffers returns the matching offers newest first.
+func (m *MemoryStore) listTradeOffers(match func(o *TradeOffer) bool) []TradeOffer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	offers := []TradeOffer{}
+	ids := sortedIDs(m.trades)
+	slices.Reverse(ids)
+	for _, id := range ids {
+		if o := m.trades[id]; match(o) {
+			offers = append(offers, copyOffer(o))
+		}
+	}
+	return offers
+}
+
+func (m *MemoryStore) UpdateTradeOfferStatus(ctx context.Context, offerID int, status string, actorID int, note string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.trades[offerID]
+	if !ok {
+		return notFound("trade offer")
+	}
+	if !m.setTradeStatus(o.OfferID, TradeStatusPending, status, actorID, note) {
+		return newError(ErrConflict, "Offer is not pending")
+	}
+	return nil
+}
+
+func (m *MemoryStore) AcceptTradeOffer(ctx context.Context, offerID int, actorID int) ([]TradeOffer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.trades[offerID]
+	if !ok {
+		return nil, notFound("trade offer")
+	}
+	if o.CurrentStatus != "pending" {
+		return nil, newError(ErrConflict, "Offer is not pending")
+	}
+	if o.Expired(time.Now()) {
+		return nil, newError(ErrConflict, "Offer has expired")
+	}
+	if len(o.GameRequestedIDs) == 0 || len(o.GameOfferedIDs) == 0 {
+		return nil, newError(ErrConflict, "Offer has no games on one side")
+	}
+	if err := m.checkTradeOwners(o, o.OwnerUserID, o.RequesterID,
+		"Requested game owner changed", "Offered game owner changed"); err != nil {
+		return nil, err
+	}
+	if busy := m.gamesInActiveTrade(tradeGames(o), o.OfferID); len(busy) > 0 {
+		return nil, newError(ErrConflict, "Game is already in an active trade")
+	}
+
+	m.setTradeStatus(o.OfferID, TradeStatusPending, TradeStatusAwaitingShipment, actorID, "")
+
+	// Void every other pending offer sharing a game, the same as voidConflictingOffers
+	voided := []TradeOffer{}
+	games := tradeGames(o)
+	note := "A game in this offer was traded in offer #" + strconv.Itoa(o.OfferID)
+	for _, id := range sortedIDs(m.trades) {
+		v := m.trades[id]
+		if id == o.OfferID || v.CurrentStatus != "pending" {
+			continue
+		}
+		if !slices.ContainsFunc(tradeGames(v), func(g int) bool { return slices.Contains(games, g) }) {
+			continue
+		}
+		voided = append(voided, copyOffer(v))
+		m.setTradeStatus(id, "pending", "void", 0, note)
+	}
+	return voided, nil
+}
+
+func tradeGames(o *TradeOffer) []int {
+	return append(append([]int{}, o.GameRequestedIDs...), o.GameOfferedIDs...)
+}
+
+// checkTradeOwners makes sure the requested games still belong to requestedOwner and the offered
+// ones to offeredOwner, with the message to give for each side when they don't.
+func (m *MemoryStore) checkTradeOwners(o *TradeOffer, requestedOwner int, offeredOwner int, requestedMsg string, offeredMsg string) error {
+	for _, gameID := range o.GameRequestedIDs {
+		if err := m.checkGameOwner(gameID, requestedOwner); err != nil {
+			if errors.Is(err, ErrOwnershipChanged) {
+				return newError(ErrOwnershipChanged, requestedMsg)
+			}
+			return err
+		}
+	}
+	for _, gameID := range o.GameOfferedIDs {
+		if err := m.checkGameOwner(gameID, offeredOwner); err != nil {
+			if errors.Is(err, ErrOwnershipChanged) {
+				return newError(ErrOwnershipChanged, offeredMsg)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) checkGameOwner(gameID int, ownerID int) error {
+	g, ok := m.games[gameID]
+	if !ok {
+		return notFound("game")
+	}
+	if g.OwnerUserID != ownerID {
+		return ErrOwnershipChanged
+	}
+	return nil
+}
+
+func (m *MemoryStore) ExpireTradeOffers(ctx context.Context, now time.Time) ([]TradeOffer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expired := []TradeOffer{}
+	for _, id := range sortedIDs(m.trades) {
+		o := m.trades[id]
+		if o.CurrentStatus != "pending" || o.ExpiresAt == nil || o.ExpiresAt.After(now) {
+			continue
+		}
+		m.setTradeStatus(id, "pending", "expired", 0, "")
+		expired = append(expired, copyOffer(o))
+	}
+	return expired, nil
+}
+
+func (m *MemoryStore) GamesInActiveTrade(ctx context.Context, gameIDs []int) ([]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.gamesInActiveTrade(gameIDs, 0), nil
+}
+
+func (m *MemoryStore) gamesInActiveTrade(gameIDs []int, excludeOfferID int) []int {
+	busy := []int{}
+	for _, gameID := range gameIDs {
+		if slices.Contains(busy, gameID) {
+			continue
+		}
+		for _, o := range m.trades {
+			if o.OfferID == excludeOfferID || !slices.Contains(activeTradeStatuses, o.CurrentStatus) {
+				continue
+			}
+			if slices.Contains(o.GameRequestedIDs, gameID) || slices.Contains(o.GameOfferedIDs, gameID) {
+				busy = append(busy, gameID)
+				break
+			}
+		}
+	}
+	return busy
+}
+
+// tradeForParty is lockTradeForParty, the lock being the store mutex the caller already holds.
+func (m *MemoryStore) tradeForParty(offerID int, userID int) (*TradeOffer, error) {
+	o, ok := m.trades[offerID]
+	if !ok {
+		return nil, notFound("trade offer")
+	}
+	if o.RequesterID != userID && o.OwnerUserID != userID {
+		return nil, newError(ErrForbidden, "You are not part of this trade")
+	}
+	return o, nil
+}
+
+func (m *MemoryStore) MarkTradeShipped(ctx context.Context, offerID int, userID int, tracking string) (TradeOffer, error) {
+	if err := ctx.Err(); err != nil {
+		return TradeOffer{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, err := m.tradeForParty(offerID, userID)
+	if err != nil {
+		return TradeOffer{}, err
+	}
+	if o.CurrentStatus != TradeStatusAwaitingShipment {
+		return TradeOffer{}, newError(ErrConflict, "Offer is not awaiting shipment")
+	}
+
+	now := time.Now().UTC()
+	if userID == o.RequesterID {
+		if o.Shipment.RequesterShippedAt != nil {
+			return TradeOffer{}, newError(ErrConflict, "Already marked as shipped")
+		}
+		o.Shipment.RequesterTracking = tracking
+		o.Shipment.RequesterShippedAt = &now
+	} else {
+		if o.Shipment.OwnerShippedAt != nil {
+			return TradeOffer{}, newError(ErrConflict, "Already marked as shipped")
+		}
+		o.Shipment.OwnerTracking = tracking
+		o.Shipment.OwnerShippedAt = &now
+	}
+	o.UpdatedAt = now
+
+	note := "Tracking number " + tracking
+	if o.Shipment.RequesterShippedAt != nil && o.Shipment.OwnerShippedAt != nil {
+		m.setTradeStatus(offerID, o.CurrentStatus, TradeStatusShipped, userID, note)
+	} else {
+		m.recordTradeEvent(offerID, userID, TradeStatusShipped, o.CurrentStatus, o.CurrentStatus, note)
+	}
+	return copyOffer(o), nil
+}
+
+func (m *MemoryStore) ConfirmTradeReceipt(ctx context.Context, offerID int, userID int) (TradeOffer, error) {
+	if err := ctx.Err(); err != nil {
+		return TradeOffer{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, err := m.tradeForParty(offerID, userID)
+	if err != nil {
+		return TradeOffer{}, err
+	}
+	if o.CurrentStatus != TradeStatusShipped && o.CurrentStatus != TradeStatusReceived {
+		return TradeOffer{}, newError(ErrConflict, "Offer has not been shipped")
+	}
+
+	requester := userID == o.RequesterID
+	if (requester && o.Shipment.RequesterReceivedAt != nil) || (!requester && o.Shipment.OwnerReceivedAt != nil) {
+		return TradeOffer{}, newError(ErrConflict, "Already marked as received")
+	}
+	otherReceived := o.Shipment.OwnerReceivedAt != nil
+	if !requester {
+		otherReceived = o.Shipment.RequesterReceivedAt != nil
+	}
+
+	// Completing swaps the games, so check that can happen before recording anything
+	if otherReceived {
+		if err := m.checkTradeOwners(o, o.OwnerUserID, o.RequesterID,
+			"Requested game owner changed", "Offered game owner changed"); err != nil {
+			return TradeOffer{}, err
+		}
+	}
+
+	now := time.Now().UTC()
+	if requester {
+		o.Shipment.RequesterReceivedAt = &now
+	} else {
+		o.Shipment.OwnerReceivedAt = &now
+	}
+	o.UpdatedAt = now
+
+	if !otherReceived {
+		m.setTradeStatus(offerID, o.CurrentStatus, TradeStatusReceived, userID, "")
+		return copyOffer(o), nil
+	}
+
+	for _, gameID := range o.GameRequestedIDs {
+		m.transferGame(gameID, o.OwnerUserID, o.RequesterID, o.OfferID)
+	}
+	for _, gameID := range o.GameOfferedIDs {
+		m.transferGame(gameID, o.RequesterID, o.OwnerUserID, o.OfferID)
+	}
+	m.setTradeStatus(offerID, o.CurrentStatus, TradeStatusCompleted, userID, "")
+	return copyOffer(o), nil
+}
+
+// Messages
+
+func (m *MemoryStore) CreateTradeMessage(ctx context.Context, offerID int, senderID int, body string) (TradeMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return TradeMessage{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.trades[offerID]; !ok {
+		return TradeMessage{}, notFound("trade offer")
+	}
+	msg := TradeMessage{
+		MessageID: m.nextID("TRADE_MESSAGES"),
+		OfferID:   offerID,
+		SenderID:  senderID,
+		Body:      body,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	m.messages[msg.MessageID] = &msg
+	return msg, nil
+}
+
+func (m *MemoryStore) ListTradeMessages(ctx context.Context, offerID int, afterID int, limit int) ([]TradeMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := []TradeMessage{}
+	for _, id := range sortedIDs(m.messages) {
+		if len(messages) == limit {
+			break
+		}
+		if msg := m.messages[id]; msg.OfferID == offerID && id > afterID {
+			messages = append(messages, *msg)
+		}
+	}
+	return messages, nil
+}
+
+// Disputes
+
+func (m *MemoryStore) OpenTradeDispute(ctx context.Context, offerID int, userID int, reason string, evidence string) (TradeDispute, error) {
+	if err := ctx.Err(); err != nil {
+		return TradeDispute{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, err := m.tradeForParty(offerID, userID)
+	if err != nil {
+		return TradeDispute{}, err
+	}
+	if o.CurrentStatus == TradeStatusDisputed {
+		return TradeDispute{}, newError(ErrConflict, "Offer is already disputed")
+	}
+	if !disputableStatuses[o.CurrentStatus] {
+		return TradeDispute{}, newError(ErrConflict, "Only accepted trades can be disputed")
+	}
+
+	d := TradeDispute{
+		DisputeID:      m.nextID("TRADE_DISPUTES"),
+		OfferID:        offerID,
+		OpenedBy:       userID,
+		Reason:         reason,
+		Evidence:       evidence,
+		Status:         DisputeStatusOpen,
+		PreviousStatus: o.CurrentStatus,
+		CreatedAt:      time.Now().UTC(),
+	}
+	m.disputes[d.DisputeID] = &d
+	m.setTradeStatus(offerID, o.CurrentStatus, TradeStatusDisputed, userID, reason)
+	return d, nil
+}
+
+func (m *MemoryStore) GetTradeDisputes(ctx context.Context, offerID int) ([]TradeDispute, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.listDisputes(func(d *TradeDispute) bool { return d.OfferID == offerID }, -1), nil
+}
+
+func (m *MemoryStore) ListDisputes(ctx context.Context, status string, afterID int, limit int) ([]TradeDispute, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.listDisputes(func(d *TradeDispute) bool {
+		return d.DisputeID > afterID && (status == "" || d.Status == status)
+	}, limit), nil
+}
+
+// listDisputes returns up to limit matching disputes oldest first, a negative limit means all of them.
+func (m *MemoryStore) listDisputes(match func(d *TradeDispute) bool, limit int) []TradeDispute {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	disputes := []TradeDispute{}
+	for _, id := range sortedIDs(m.disputes) {
+		if len(disputes) == limit {
+			break
+		}
+		if d := m.disputes[id]; match(d) {
+			disputes = append(disputes, *d)
+		}
+	}
+	return disputes
+}
+
+func (m *MemoryStore) GetTradeDispute(ctx context.Context, disputeID int) (TradeDispute, error) {
+	if err := ctx.Err(); err != nil {
+		return TradeDispute{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.disputes[disputeID]
+	if !ok {
+		return TradeDispute{}, notFound("dispute")
+	}
+	return *d, nil
+}
+
+func (m *MemoryStore) ResolveTradeDispute(ctx context.Context, disputeID int, moderatorID int, ruling TradeDisputeResolution) (TradeDispute, TradeOffer, error) {
+	if err := ctx.Err(); err != nil {
+		return TradeDispute{}, TradeOffer{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.disputes[disputeID]
+	if !ok {
+		return TradeDispute{}, TradeOffer{}, notFound("dispute")
+	}
+	if d.Status != DisputeStatusOpen {
+		return TradeDispute{}, TradeOffer{}, newError(ErrConflict, "Dispute is already resolved")
+	}
+	o := m.trades[d.OfferID]
+
+	if ruling.PenaliseUserID != 0 && ruling.PenaliseUserID != o.RequesterID && ruling.PenaliseUserID != o.OwnerUserID {
+		return TradeDispute{}, TradeOffer{}, newError(ErrInvalid, "Only a party to the trade can be penalised")
+	}
+
+	switch ruling.Resolution {
+	case DisputeResolutionRevert:
+		if d.PreviousStatus == TradeStatusCompleted {
+			// The same checks as revertTrade, the games go back the other way
+			if err := m.checkTradeOwners(o, o.RequesterID, o.OwnerUserID,
+				"Game has changed hands since the trade", "Game has changed hands since the trade"); err != nil {
+				return TradeDispute{}, TradeOffer{}, err
+			}
+			if busy := m.gamesInActiveTrade(tradeGames(o), o.OfferID); len(busy) > 0 {
+				return TradeDispute{}, TradeOffer{}, newError(ErrConflict, "Game is already in an active trade")
+			}
+			for _, gameID := range o.GameRequestedIDs {
+				m.transferGame(gameID, o.RequesterID, o.OwnerUserID, o.OfferID)
+			}
+			for _, gameID := range o.GameOfferedIDs {
+				m.transferGame(gameID, o.OwnerUserID, o.RequesterID, o.OfferID)
+			}
+		}
+		m.setTradeStatus(o.OfferID, TradeStatusDisputed, TradeStatusReverted, moderatorID, ruling.Note)
+	case DisputeResolutionClose:
+		m.setTradeStatus(o.OfferID, TradeStatusDisputed, d.PreviousStatus, moderatorID, ruling.Note)
+	default:
+		return TradeDispute{}, TradeOffer{}, newError(ErrInvalid, "Invalid resolution")
+	}
+
+	if ruling.PenaliseUserID != 0 {
+		if u, ok := m.users[ruling.PenaliseUserID]; ok {
+			u.Strikes++
+		}
+		d.PenalisedUserID = optionalID(ruling.PenaliseUserID)
+	}
+
+	now := time.Now().UTC()
+	d.Status = DisputeStatusResolved
+	d.Resolution = ruling.Resolution
+	d.ResolutionNote = ruling.Note
+	d.ResolvedBy = optionalID(moderatorID)
+	d.ResolvedAt = &now
+	return *d, copyOffer(o), nil
+}
+
+// Reviews
+
+func (m *MemoryStore) CreateTradeReview(ctx context.Context, offerID int, reviewerID int, rating int, comment string) (TradeReview, error) {
+	if err := ctx.Err(); err != nil {
+		return TradeReview{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, err := m.tradeForParty(offerID, reviewerID)
+	if err != nil {
+		return TradeReview{}, err
+	}
+	if o.CurrentStatus != TradeStatusCompleted {
+		return TradeReview{}, newError(ErrConflict, "Only completed trades can be reviewed")
+	}
+	for _, rv := range m.reviews {
+		if rv.OfferID == offerID && rv.ReviewerID == reviewerID {
+			return TradeReview{}, newError(ErrConflict, "You have already reviewed this trade")
+		}
+	}
+
+	reviewee := o.OwnerUserID
+	if reviewerID == o.OwnerUserID {
+		reviewee = o.RequesterID
+	}
+
+	rv := TradeReview{
+		ReviewID:   m.nextID("TRADE_REVIEWS"),
+		OfferID:    offerID,
+		ReviewerID: reviewerID,
+		RevieweeID: reviewee,
+		Rating:     rating,
+		Comment:    comment,
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	m.reviews[rv.ReviewID] = &rv
+	return rv, nil
+}
+
+func (m *MemoryStore) GetTradeReviews(ctx context.Context, offerID int) ([]TradeReview, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reviews := []TradeReview{}
+	for _, id := range sortedIDs(m.reviews) {
+		if rv := m.reviews[id]; rv.OfferID == offerID {
+			reviews = append(reviews, *rv)
+		}
+	}
+	return reviews, nil
+}
+
+func (m *MemoryStore) GetReputations(ctx context.Context, userIDs []int) (map[int]Reputation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reps := make(map[int]Reputation, len(userIDs))
+	totals := map[int]int{}
+	for _, id := range userIDs {
+		reps[id] = Reputation{}
+	}
+	for _, rv := range m.reviews {
+		if rep, ok := reps[rv.RevieweeID]; ok {
+			rep.ReviewCount++
+			reps[rv.RevieweeID] = rep
+			totals[rv.RevieweeID] += rv.Rating
+		}
+	}
+	for id, rep := range reps {
+		if rep.ReviewCount > 0 {
+			rep.AverageRating = float64(totals[id]) / float64(rep.ReviewCount)
+			reps[id] = rep
+		}
+	}
+	return reps, nil
+}
+
+func (m *MemoryStore) GetUserReputation(ctx context.Context, userID int) (Reputation, error) {
+	if err := ctx.Err(); err != nil {
+		return Reputation{}, err
+	}
+	reps, err := m.GetReputations(ctx, []int{userID})
+	if err != nil {
+		return Reputation{}, err
+	}
+	return reps[userID], nil
+}
@@ -0,0 +1,461 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema as numbered steps, NNNN_name.up.sql applies one and
+// NNNN_name.down.sql undoes it. There is a directory per driver and a new schema change goes
+// in each of them as a new pair, never an edit to an old one. 0001 is the schema as it was in
+// SQLScriptChanges.sql, see MigrateBaseline for adopting a database built from that script.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationLock is the GET_LOCK name every instance waits on, so api1/api2/api3 booting together
+// take turns and the later ones find nothing left to apply.
+const migrationLock = "gameAPI.schema_migrations"
+
+// migrationLockWait is how long an instance waits for another one to finish migrating.
+const migrationLockWait = 5 * time.Minute
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt"`
+	// Dirty is set while a migration runs, one left dirty failed part way through
+	Dirty bool `json:"dirty"`
+}
+
+// Migrations returns every embedded migration for a driver in version order.
+func Migrations(driver string) ([]Migration, error) {
+	dir := "migrations/" + driver
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s is not named NNNN_name.up.sql or NNNN_name.down.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := migrationFiles.ReadFile(dir + "/" + entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	return migrations, nil
+}
+
+// splitStatements breaks a migration into single statements, the driver only runs one per Exec.
+// Comment lines are dropped and a statement ends at a line ending in a semicolon.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// withMigrationLock runs fn on one connection while no other instance is migrating. On MySQL that
+// is GET_LOCK, which belongs to the session that took it, so everything has to go through the same
+// *sql.Conn. SQLite has no advisory locks, there a write transaction around the whole run does the
+// job and, DDL being transactional in SQLite, makes the run all or nothing as well.
+// Foreign keys are off in SQLite for the run so a migration can rebuild a table the way SQLite
+// changes one, without the DROP TABLE cascading, and are checked in full before the commit.
+// There is no query timeout here, a migration takes as long as it takes.
+func (s *SQLStore) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting a connection for migrations: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if s.db.dialect.advisoryLocks {
+		var got sql.NullInt64
+		err = conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, migrationLock, int(migrationLockWait.Seconds())).Scan(&got)
+		if err != nil {
+			return fmt.Errorf("error taking the migration lock: %w", err)
+		}
+		if got.Int64 != 1 {
+			return fmt.Errorf("timed out after %s waiting for another instance to finish migrating", migrationLockWait)
+		}
+		// Released on a fresh context so a cancelled ctx doesn't leave the lock held
+		defer func() {
+			_, _ = conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, migrationLock)
+		}()
+
+		if err := createMigrationsTable(ctx, conn); err != nil {
+			return err
+		}
+		return fn(conn)
+	}
+
+	// PRAGMA foreign_keys does nothing inside a transaction, it has to go first
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return fmt.Errorf("error turning off foreign keys: %w", err)
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `PRAGMA foreign_keys = ON`)
+	}()
+
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return fmt.Errorf("error taking the migration lock: %w", err)
+	}
+	err = createMigrationsTable(ctx, conn)
+	if err == nil {
+		err = fn(conn)
+	}
+	if err == nil {
+		err = checkForeignKeys(ctx, conn)
+	}
+	if err != nil {
+		_, _ = conn.ExecContext(context.Background(), `ROLLBACK`)
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+		return fmt.Errorf("error committing migrations: %w", err)
+	}
+	return nil
+}
+
+// advisoryLock takes a GET_LOCK named name on a connection of its own, waiting as long as ctx
+// allows. The lock lives as long as that connection's session, release hands both back.
+func (s *SQLStore) advisoryLock(ctx context.Context, name string) (release func(), err error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting a connection for lock %s: %w", name, err)
+	}
+
+	wait := migrationLockWait
+	if deadline, ok := ctx.Deadline(); ok {
+		wait = time.Until(deadline)
+	}
+	var got sql.NullInt64
+	err = conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, name, max(int(wait.Seconds()), 0)).Scan(&got)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error taking lock %s: %w", name, err)
+	}
+	if got.Int64 != 1 {
+		_ = conn.Close()
+		return nil, fmt.Errorf("timed out waiting for lock %s", name)
+	}
+
+	return func() {
+		// A fresh context so a cancelled ctx doesn't leave the lock held
+		_, _ = conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, name)
+		_ = conn.Close()
+	}, nil
+}
+
+// checkForeignKeys fails when the migrations left a row pointing at one that isn't there, SQLite
+// only, it is the check the run skipped with foreign keys off.
+func checkForeignKeys(ctx context.Context, conn *sql.Conn) error {
+	rows, err := conn.QueryContext(ctx, `PRAGMA foreign_key_check`)
+	if err != nil {
+		return fmt.Errorf("error checking foreign keys: %w", err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fk int
+		if err := rows.Scan(&table, &rowID, &parent, &fk); err != nil {
+			return fmt.Errorf("error checking foreign keys: %w", err)
+		}
+		return fmt.Errorf("migrations left %s row %d pointing at a missing %s row", table, rowID.Int64, parent)
+	}
+	return rows.Err()
+}
+
+func createMigrationsTable(ctx context.Context, conn *sql.Conn) error {
+	query := `CREATE TABLE IF NOT EXISTS SCHEMA_MIGRATIONS (
+		Version INT NOT NULL,
+		Name VARCHAR(255) NOT NULL,
+		AppliedAt DATETIME NOT NULL,
+		Dirty BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (Version)
+	)`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error creating SCHEMA_MIGRATIONS: %w", err)
+	}
+	return nil
+}
+
+// appliedMigration is a SCHEMA_MIGRATIONS row.
+type appliedMigration struct {
+	Name      string
+	AppliedAt time.Time
+	Dirty     bool
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT Version, Name, AppliedAt, Dirty FROM SCHEMA_MIGRATIONS`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading SCHEMA_MIGRATIONS: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var m appliedMigration
+		if err := rows.Scan(&version, &m.Name, &m.AppliedAt, &m.Dirty); err != nil {
+			return nil, err
+		}
+		applied[version] = m
+	}
+	return applied, rows.Err()
+}
+
+// refuseDirty stops up, down and baseline while a migration is marked dirty. That only happens on
+// MySQL, where DDL commits as it goes: a run failed part way through and the schema is somewhere
+// between two versions. Finish or undo the migration's statements by hand, then delete its
+// SCHEMA_MIGRATIONS row if it was undone, or set Dirty = FALSE if it was finished.
+func refuseDirty(applied map[int]appliedMigration) error {
+	for version, m := range applied {
+		if m.Dirty {
+			return fmt.Errorf("migration %04d_%s is dirty, it failed part way through; fix the schema by hand and update SCHEMA_MIGRATIONS before migrating again", version, m.Name)
+		}
+	}
+	return nil
+}
+
+// runMigration executes one direction of a migration. MySQL commits DDL as it goes, so a
+// failure part way there leaves the earlier statements applied and the version dirty, the error
+// says which statement to fix by hand before running again.
+func runMigration(ctx context.Context, conn *sql.Conn, m Migration, script string) error {
+	for i, statement := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("migration %04d_%s statement %d: %w", m.Version, m.Name, i+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) countTables(ctx context.Context, conn *sql.Conn) (int, error) {
+	var tables int
+	if err := conn.QueryRowContext(ctx, s.db.dialect.countTables).Scan(&tables); err != nil {
+		return 0, fmt.Errorf("error checking for an existing schema: %w", err)
+	}
+	return tables, nil
+}
+
+// refuseUnmanagedSchema stops MigrateUp from building on tables it didn't create. A database
+// from the old SQLScriptChanges.sql has no SCHEMA_MIGRATIONS rows, running 0001 over it would
+// fail on the tables already being there. MigrateBaseline adopts one of those instead.
+func (s *SQLStore) refuseUnmanagedSchema(ctx context.Context, conn *sql.Conn) error {
+	tables, err := s.countTables(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if tables > 0 {
+		return fmt.Errorf("database has %d tables but no migrations recorded, it was built without them; if it came from SQLScriptChanges.sql run `migrate baseline` first", tables)
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration in order and returns the ones it ran. Each one is
+// recorded dirty before it runs and clean after, so one that fails half way on MySQL stays
+// marked and the next run stops instead of building on a half changed schema.
+func (s *SQLStore) MigrateUp(ctx context.Context) ([]Migration, error) {
+	migrations, err := Migrations(s.db.dialect.driver)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := refuseDirty(applied); err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			if err := s.refuseUnmanagedSchema(ctx, conn); err != nil {
+				return err
+			}
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			query := `INSERT INTO SCHEMA_MIGRATIONS (Version, Name, AppliedAt, Dirty) VALUES (?, ?, ?, TRUE)`
+			if _, err := conn.ExecContext(ctx, query, m.Version, m.Name, time.Now().UTC()); err != nil {
+				return fmt.Errorf("error recording migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			if err := runMigration(ctx, conn, m, m.Up); err != nil {
+				return err
+			}
+			query = `UPDATE SCHEMA_MIGRATIONS SET AppliedAt=?, Dirty=FALSE WHERE Version=?`
+			if _, err := conn.ExecContext(ctx, query, time.Now().UTC(), m.Version); err != nil {
+				return fmt.Errorf("error recording migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			ran = append(ran, m)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// MigrateDown reverts the newest applied migration. ok is false when nothing was applied.
+func (s *SQLStore) MigrateDown(ctx context.Context) (Migration, bool, error) {
+	migrations, err := Migrations(s.db.dialect.driver)
+	if err != nil {
+		return Migration{}, false, err
+	}
+
+	var reverted Migration
+	var ok bool
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := refuseDirty(applied); err != nil {
+			return err
+		}
+		for _, m := range slices.Backward(migrations) {
+			if _, done := applied[m.Version]; !done {
+				continue
+			}
+			if _, err := conn.ExecContext(ctx, `UPDATE SCHEMA_MIGRATIONS SET Dirty=TRUE WHERE Version=?`, m.Version); err != nil {
+				return fmt.Errorf("error recording migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			if err := runMigration(ctx, conn, m, m.Down); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, `DELETE FROM SCHEMA_MIGRATIONS WHERE Version=?`, m.Version); err != nil {
+				return fmt.Errorf("error unrecording migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			reverted, ok = m, true
+			return nil
+		}
+		return nil
+	})
+	return reverted, ok, err
+}
+
+// MigrateBaseline adopts a database built from SQLScriptChanges.sql. That script made the schema
+// 0001 creates, so baseline records 0001 as applied without running it and MigrateUp carries on
+// from 0002. It refuses a database that already has migrations recorded, and an empty one, which
+// MigrateUp can build from scratch.
+func (s *SQLStore) MigrateBaseline(ctx context.Context) (Migration, error) {
+	migrations, err := Migrations(s.db.dialect.driver)
+	if err != nil {
+		return Migration{}, err
+	}
+	if len(migrations) == 0 || migrations[0].Version != 1 {
+		return Migration{}, fmt.Errorf("there is no 0001 migration to baseline at")
+	}
+	baseline := migrations[0]
+
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if len(applied) > 0 {
+			return fmt.Errorf("database already has %d migrations recorded, there is nothing to adopt", len(applied))
+		}
+		tables, err := s.countTables(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if tables == 0 {
+			return fmt.Errorf("database is empty, run `migrate up` to build it")
+		}
+
+		query := `INSERT INTO SCHEMA_MIGRATIONS (Version, Name, AppliedAt, Dirty) VALUES (?, ?, ?, FALSE)`
+		if _, err := conn.ExecContext(ctx, query, baseline.Version, baseline.Name, time.Now().UTC()); err != nil {
+			return fmt.Errorf("error recording migration %04d_%s: %w", baseline.Version, baseline.Name, err)
+		}
+		return nil
+	})
+	return baseline, err
+}
+
+// MigrationStatus lists every embedded migration, AppliedAt is nil for the pending ones.
+func (s *SQLStore) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := Migrations(s.db.dialect.driver)
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			st := MigrationStatus{Version: m.Version, Name: m.Name}
+			if a, ok := applied[m.Version]; ok {
+				st.AppliedAt = &a.AppliedAt
+				st.Dirty = a.Dirty
+			}
+			status = append(status, st)
+		}
+		return nil
+	})
+	return status, err
+}
@@ -0,0 +1,289 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openEmptySQLite is an in-memory database with no migrations run yet.
+func openEmptySQLite(t *testing.T) (*SQLStore, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:?_pragma=foreign_keys(1)&_txlock=immediate&_time_format=sqlite&_timezone=UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: gets its own database, keep it to one
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	store, err := NewSQLStore(db, "sqlite", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, db
+}
+
+func applied(t *testing.T, store *SQLStore) []int {
+	t.Helper()
+	status, err := store.MigrationStatus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int
+	for _, st := range status {
+		if st.AppliedAt != nil {
+			versions = append(versions, st.Version)
+		}
+	}
+	return versions
+}
+
+func TestMigrationsMatchAcrossDrivers(t *testing.T) {
+	mysql, err := Migrations("mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := Migrations("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mysql) != len(sqlite) {
+		t.Fatalf("mysql has %d migrations, sqlite %d", len(mysql), len(sqlite))
+	}
+	for i := range mysql {
+		if mysql[i].Version != i+1 || mysql[i].Version != sqlite[i].Version || mysql[i].Name != sqlite[i].Name {
+			t.Fatalf("migration %d is %04d_%s on mysql and %04d_%s on sqlite", i+1, mysql[i].Version, mysql[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
+
+func TestMigrateRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store, _ := openEmptySQLite(t)
+	migrations, err := Migrations("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ran, err := store.MigrateUp(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != len(migrations) {
+		t.Fatalf("ran %d migrations, want %d", len(ran), len(migrations))
+	}
+	if got := applied(t, store); len(got) != len(migrations) {
+		t.Fatalf("status has %v applied", got)
+	}
+	if ran, err := store.MigrateUp(ctx); err != nil || len(ran) != 0 {
+		t.Fatalf("second up ran %v, %v", ran, err)
+	}
+
+	// Data written on the latest schema has to survive the downs that rebuild tables
+	_, gameID, offerID := seed(t, store)
+
+	for i := len(migrations); i > 1; i-- {
+		m, ok, err := store.MigrateDown(ctx)
+		if err != nil {
+			t.Fatalf("down from %d: %v", i, err)
+		}
+		if !ok || m.Version != i {
+			t.Fatalf("down from %d reverted %04d_%s", i, m.Version, m.Name)
+		}
+	}
+	if got := applied(t, store); !slices.Equal(got, []int{1}) {
+		t.Fatalf("status has %v applied, want just the baseline", got)
+	}
+	var requested int
+	if err := store.db.QueryRowContext(ctx, `SELECT GameRequestedID FROM TRADE WHERE OfferID=?`, offerID).Scan(&requested); err != nil {
+		t.Fatal(err)
+	}
+	if requested != gameID {
+		t.Fatalf("baseline offer requests game %d, want %d", requested, gameID)
+	}
+
+	if _, ok, err := store.MigrateDown(ctx); err != nil || !ok {
+		t.Fatalf("down from the baseline: %v %v", ok, err)
+	}
+	if _, ok, err := store.MigrateDown(ctx); err != nil || ok {
+		t.Fatalf("down with nothing applied: %v %v", ok, err)
+	}
+	var tables int
+	if err := store.db.QueryRowContext(ctx, sqliteDialect.countTables).Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Fatalf("%d tables left after every down", tables)
+	}
+
+	if ran, err := store.MigrateUp(ctx); err != nil || len(ran) != len(migrations) {
+		t.Fatalf("up again ran %d, %v", len(ran), err)
+	}
+	seed(t, store)
+}
+
+// TestMigrateBaseline adopts a database the way SQLScriptChanges.sql left it, rows and all.
+func TestMigrateBaseline(t *testing.T) {
+	ctx := context.Background()
+	store, _ := openEmptySQLite(t)
+	migrations, err := Migrations("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.MigrateBaseline(ctx); err == nil || !strings.Contains(err.Error(), "migrate up") {
+		t.Fatalf("baseline of an empty database: %v", err)
+	}
+
+	for _, statement := range splitStatements(migrations[0].Up) {
+		if _, err := store.db.ExecContext(ctx, statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, statement := range []string{
+		`INSERT INTO USERS (UserID, Name, Email, PasswordHash, StreetAddress) VALUES (1, 'alice', 'alice@example.com', 'x', '1 Ice Floe')`,
+		`INSERT INTO USERS (UserID, Name, Email, PasswordHash, StreetAddress) VALUES (2, 'bob', 'bob@example.com', 'x', '2 Ice Floe')`,
+		`INSERT INTO GAMES (GameID, OwnerUserID, Title, Publisher, Description, Year, Quality) VALUES (1, 1, 'Ocarina of Time', 'Nintendo', 'Boxed', 1998, 'good')`,
+		`INSERT INTO GAMES (GameID, OwnerUserID, Title, Publisher, Description, Year, Quality) VALUES (2, 2, 'Super Mario 64', 'Nintendo', 'Loose', 1996, 'fair')`,
+		`INSERT INTO TRADE (OfferID, RequesterID, OwnerUserID, GameRequestedID, GameOfferedID) VALUES (1, 2, 1, 1, 2)`,
+	} {
+		if _, err := store.db.ExecContext(ctx, statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.MigrateUp(ctx); err == nil || !strings.Contains(err.Error(), "migrate baseline") {
+		t.Fatalf("up over an unversioned database: %v", err)
+	}
+	m, err := store.MigrateBaseline(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 1 {
+		t.Fatalf("baselined at %04d_%s", m.Version, m.Name)
+	}
+	if _, err := store.MigrateBaseline(ctx); err == nil {
+		t.Fatal("baselined twice")
+	}
+	ran, err := store.MigrateUp(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != len(migrations)-1 {
+		t.Fatalf("ran %d migrations after the baseline, want %d", len(ran), len(migrations)-1)
+	}
+
+	// The old rows read back through the store as if they had been made on the new schema
+	offer, err := store.GetTradeOfferByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(offer.GameRequestedIDs, []int{1}) || !slices.Equal(offer.GameOfferedIDs, []int{2}) {
+		t.Fatalf("got offer %+v", offer)
+	}
+	if verified, err := store.IsEmailVerified(ctx, 1); err != nil || !verified {
+		t.Fatalf("existing user verified %v, %v", verified, err)
+	}
+	provenance, err := store.GetGameProvenance(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provenance) != 1 {
+		t.Fatalf("got provenance %+v", provenance)
+	}
+}
+
+func TestMigrateRefusesUnversioned(t *testing.T) {
+	store, _ := openEmptySQLite(t)
+	if _, err := store.db.ExecContext(context.Background(), `CREATE TABLE USERS (UserID INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.MigrateUp(context.Background()); err == nil || !strings.Contains(err.Error(), "no migrations recorded") {
+		t.Fatalf("got %v", err)
+	}
+	if got := applied(t, store); len(got) != 0 {
+		t.Fatalf("status has %v applied", got)
+	}
+}
+
+func TestMigrateRefusesDirty(t *testing.T) {
+	ctx := context.Background()
+	store, _ := openEmptySQLite(t)
+	if _, err := store.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// What a MySQL migration failing part way through leaves behind
+	if _, err := store.db.ExecContext(ctx, `UPDATE SCHEMA_MIGRATIONS SET Dirty=TRUE WHERE Version=3`); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := store.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status[2].Dirty {
+		t.Fatalf("got status %+v", status[2])
+	}
+
+	calls := map[string]func() error{
+		"up": func() error {
+			_, err := store.MigrateUp(ctx)
+			return err
+		},
+		"down": func() error {
+			_, _, err := store.MigrateDown(ctx)
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			if err := call(); err == nil || !strings.Contains(err.Error(), "0003_user_roles is dirty") {
+				t.Fatalf("got %v", err)
+			}
+		})
+	}
+	if got := applied(t, store); len(got) == 0 || got[len(got)-1] != len(status) {
+		t.Fatalf("a refused down still reverted something, status has %v applied", got)
+	}
+}
+
+func TestTradeEventsOutliveOffers(t *testing.T) {
+	ctx := context.Background()
+	store, db := openSQLite(t, 5*time.Second)
+	aliceID, gameID, offerID := seed(t, store)
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM TRADE WHERE OfferID=?`, offerID); err == nil {
+		t.Fatal("deleted an offer out from under its events")
+	}
+
+	if err := store.DeleteGameByID(ctx, gameID); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := store.GetTradeOfferByID(ctx, offerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.CurrentStatus != "void" {
+		t.Fatalf("got status %q", offer.CurrentStatus)
+	}
+	events, err := store.GetTradeHistory(ctx, offerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].ToStatus != "void" {
+		t.Fatalf("got events %+v", events)
+	}
+
+	// Erasing an account is the one thing that takes the events along
+	if err := store.DeleteUserByID(ctx, aliceID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetTradeOfferByID(ctx, offerID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("offer after deleting its owner: %v", err)
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS TRADE;
+DROP TABLE IF EXISTS GAMES;
+DROP TABLE IF EXISTS USERS;
@@ -1,8 +1,6 @@
-DROP database RetroGameDatabase;
-
-create database RetroGameDatabase;
-
-USE RetroGameDatabase;
+-- The schema SQLScriptChanges.sql created before the migrations, every later change is a step of
+-- its own. A database that was built from that script is adopted with `migrate baseline`, which
+-- records this step as applied without running it.
 
 CREATE TABLE USERS (
   UserID INT NOT NULL AUTO_INCREMENT,
@@ -61,16 +59,3 @@ CREATE TABLE TRADE(
         REFERENCES GAMES(GameID)
         ON DELETE CASCADE
 );
-
-select *
-From USERS;
-
-select *
-from GAMES;
-
-select *
-from TRADE;
-
-
-
-
@@ -0,0 +1,5 @@
+-- idx_games_owner took over from the index InnoDB made for fk_games_users, put that one back first
+ALTER TABLE GAMES ADD INDEX fk_games_users (OwnerUserID);
+DROP INDEX idx_games_owner ON GAMES;
+DROP INDEX idx_games_year ON GAMES;
+DROP INDEX idx_games_title ON GAMES;
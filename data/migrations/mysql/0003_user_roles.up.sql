@@ -0,0 +1,3 @@
+-- Promote an existing account to admin, there is no API for the first one
+-- UPDATE USERS SET Role = 'admin' WHERE Email = 'you@example.com';
+ALTER TABLE USERS ADD COLUMN Role VARCHAR(20) NOT NULL DEFAULT 'user' AFTER StreetAddress;
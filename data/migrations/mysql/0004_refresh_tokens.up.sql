@@ -0,0 +1,19 @@
+-- Bumping TokenVersion revokes every access token the user holds
+ALTER TABLE USERS ADD COLUMN TokenVersion INT NOT NULL DEFAULT 0 AFTER Role;
+
+CREATE TABLE REFRESH_TOKENS (
+  TokenID INT NOT NULL AUTO_INCREMENT,
+  UserID INT NOT NULL,
+  TokenHash CHAR(64) NOT NULL,
+  ExpiresAt DATETIME NOT NULL,
+  CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  RevokedAt DATETIME NULL,
+  ReplacedByID INT NULL,
+  PRIMARY KEY (TokenID),
+  UNIQUE (TokenHash),
+
+  CONSTRAINT fk_refresh_users
+    FOREIGN KEY (UserID)
+    REFERENCES USERS(UserID)
+    ON DELETE CASCADE
+);
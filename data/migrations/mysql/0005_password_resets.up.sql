@@ -0,0 +1,15 @@
+CREATE TABLE PASSWORD_RESETS (
+  ResetID INT NOT NULL AUTO_INCREMENT,
+  UserID INT NOT NULL,
+  TokenHash CHAR(64) NOT NULL,
+  ExpiresAt DATETIME NOT NULL,
+  CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UsedAt DATETIME NULL,
+  PRIMARY KEY (ResetID),
+  UNIQUE (TokenHash),
+
+  CONSTRAINT fk_resets_users
+    FOREIGN KEY (UserID)
+    REFERENCES USERS(UserID)
+    ON DELETE CASCADE
+);
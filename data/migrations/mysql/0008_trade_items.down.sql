@@ -0,0 +1,15 @@
+-- The old columns hold one game a side. Offers keep the lowest GameID of each side and the ones
+-- missing a side can't be written down at all, so they are deleted.
+ALTER TABLE TRADE
+  ADD COLUMN GameRequestedID INT NULL AFTER OwnerUserID,
+  ADD COLUMN GameOfferedID INT NULL AFTER GameRequestedID;
+UPDATE TRADE SET
+  GameRequestedID = (SELECT MIN(GameID) FROM TRADE_ITEMS i WHERE i.OfferID = TRADE.OfferID AND i.Side = 'requested'),
+  GameOfferedID = (SELECT MIN(GameID) FROM TRADE_ITEMS i WHERE i.OfferID = TRADE.OfferID AND i.Side = 'offered');
+DELETE FROM TRADE WHERE GameRequestedID IS NULL OR GameOfferedID IS NULL;
+ALTER TABLE TRADE
+  MODIFY GameRequestedID INT NOT NULL,
+  MODIFY GameOfferedID INT NOT NULL,
+  ADD CONSTRAINT fk_trade_gamerequested FOREIGN KEY (GameRequestedID) REFERENCES GAMES(GameID) ON DELETE CASCADE,
+  ADD CONSTRAINT fk_trade_gameoffer FOREIGN KEY (GameOfferedID) REFERENCES GAMES(GameID) ON DELETE CASCADE;
+DROP TABLE IF EXISTS TRADE_ITEMS;
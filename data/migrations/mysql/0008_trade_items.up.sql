@@ -0,0 +1,28 @@
+-- One row per game in an offer, Side says which party is giving it up
+CREATE TABLE TRADE_ITEMS(
+	OfferID INT NOT NULL,
+    GameID INT NOT NULL,
+    Side VARCHAR(10) NOT NULL,
+    
+	PRIMARY KEY (OfferID, GameID),
+    INDEX idx_trade_items_game (GameID),
+    
+    CONSTRAINT fk_trade_items_offer
+		FOREIGN KEY (OfferID)
+        REFERENCES TRADE(OfferID)
+        ON DELETE CASCADE,
+        
+	CONSTRAINT fk_trade_items_game
+		FOREIGN KEY (GameID)
+        REFERENCES GAMES(GameID)
+        ON DELETE CASCADE
+);
+
+-- Every existing offer becomes one game a side
+INSERT INTO TRADE_ITEMS (OfferID, GameID, Side)
+SELECT OfferID, GameRequestedID, 'requested' FROM TRADE;
+INSERT INTO TRADE_ITEMS (OfferID, GameID, Side)
+SELECT OfferID, GameOfferedID, 'offered' FROM TRADE WHERE GameOfferedID <> GameRequestedID;
+
+ALTER TABLE TRADE DROP FOREIGN KEY fk_trade_gamerequested, DROP FOREIGN KEY fk_trade_gameoffer;
+ALTER TABLE TRADE DROP COLUMN GameRequestedID, DROP COLUMN GameOfferedID;
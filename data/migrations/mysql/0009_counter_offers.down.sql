@@ -0,0 +1,2 @@
+ALTER TABLE TRADE DROP FOREIGN KEY fk_trade_parent;
+ALTER TABLE TRADE DROP INDEX idx_trade_parent, DROP COLUMN ParentOfferID;
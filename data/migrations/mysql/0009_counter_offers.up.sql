@@ -0,0 +1,5 @@
+-- The offer this one counters, if any
+ALTER TABLE TRADE
+  ADD COLUMN ParentOfferID INT NULL AFTER CurrentStatus,
+  ADD INDEX idx_trade_parent (ParentOfferID),
+  ADD CONSTRAINT fk_trade_parent FOREIGN KEY (ParentOfferID) REFERENCES TRADE(OfferID) ON DELETE SET NULL;
@@ -0,0 +1 @@
+ALTER TABLE TRADE DROP INDEX idx_trade_expiry, DROP COLUMN ExpiresAt;
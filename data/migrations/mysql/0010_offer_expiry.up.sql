@@ -0,0 +1,4 @@
+-- Offers made before this never expire, the sweeper skips a NULL ExpiresAt
+ALTER TABLE TRADE
+  ADD COLUMN ExpiresAt DATETIME NULL AFTER ParentOfferID,
+  ADD INDEX idx_trade_expiry (CurrentStatus, ExpiresAt);
@@ -0,0 +1,20 @@
+CREATE TABLE TRADE_MESSAGES(
+	MessageID INT NOT NULL AUTO_INCREMENT,
+    OfferID INT NOT NULL,
+    SenderID INT NOT NULL,
+    Body VARCHAR(2000) NOT NULL,
+    CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    
+	PRIMARY KEY (MessageID),
+    INDEX idx_trade_messages_offer (OfferID, MessageID),
+    
+    CONSTRAINT fk_trade_messages_offer
+		FOREIGN KEY (OfferID)
+        REFERENCES TRADE(OfferID)
+        ON DELETE CASCADE,
+        
+	CONSTRAINT fk_trade_messages_sender
+		FOREIGN KEY (SenderID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE
+);
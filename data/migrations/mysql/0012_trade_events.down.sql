@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS TRADE_EVENTS;
+ALTER TABLE TRADE DROP COLUMN UpdatedAt, DROP COLUMN CreatedAt;
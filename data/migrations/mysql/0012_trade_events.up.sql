@@ -0,0 +1,29 @@
+-- Offers already here get the time of the migration, there is no record of when they were made
+ALTER TABLE TRADE
+  ADD COLUMN CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP AFTER ExpiresAt,
+  ADD COLUMN UpdatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP AFTER CreatedAt;
+
+-- Append only, every status change on an offer adds a row. A NULL ActorID means the system did it
+CREATE TABLE TRADE_EVENTS(
+	EventID INT NOT NULL AUTO_INCREMENT,
+    OfferID INT NOT NULL,
+    ActorID INT NULL,
+    EventType VARCHAR(20) NOT NULL,
+    FromStatus VARCHAR(20) NULL,
+    ToStatus VARCHAR(20) NOT NULL,
+    Note VARCHAR(500) NULL,
+    CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    
+	PRIMARY KEY (EventID),
+    INDEX idx_trade_events_offer (OfferID, EventID),
+    
+    CONSTRAINT fk_trade_events_offer
+		FOREIGN KEY (OfferID)
+        REFERENCES TRADE(OfferID)
+        ON DELETE CASCADE,
+        
+	CONSTRAINT fk_trade_events_actor
+		FOREIGN KEY (ActorID)
+        REFERENCES USERS(UserID)
+        ON DELETE SET NULL
+);
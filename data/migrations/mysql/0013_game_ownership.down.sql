@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS GAME_OWNERSHIP;
+ALTER TABLE GAMES MODIFY PreviousOwners INT NULL;
@@ -0,0 +1,34 @@
+-- Nothing ever wrote PreviousOwners, the games already listed have had none
+UPDATE GAMES SET PreviousOwners = 0 WHERE PreviousOwners IS NULL;
+ALTER TABLE GAMES MODIFY PreviousOwners INT NOT NULL DEFAULT 0;
+
+-- Chain of custody for each game, the first row is the listing (FromUserID NULL)
+CREATE TABLE GAME_OWNERSHIP (
+  TransferID INT NOT NULL AUTO_INCREMENT,
+  GameID INT NOT NULL,
+  FromUserID INT NULL,
+  ToUserID INT NULL,
+  OfferID INT NULL,
+  TransferredAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (TransferID),
+  INDEX idx_game_ownership_game (GameID, TransferID),
+
+  CONSTRAINT fk_game_ownership_game
+    FOREIGN KEY (GameID)
+    REFERENCES GAMES(GameID)
+    ON DELETE CASCADE,
+
+  CONSTRAINT fk_game_ownership_from
+    FOREIGN KEY (FromUserID)
+    REFERENCES USERS(UserID)
+    ON DELETE SET NULL,
+
+  CONSTRAINT fk_game_ownership_to
+    FOREIGN KEY (ToUserID)
+    REFERENCES USERS(UserID)
+    ON DELETE SET NULL
+);
+
+-- Start the provenance of the games already listed with their current owner
+INSERT INTO GAME_OWNERSHIP (GameID, FromUserID, ToUserID)
+SELECT GameID, NULL, OwnerUserID FROM GAMES ORDER BY GameID;
@@ -0,0 +1,11 @@
+-- Before shipping an accepted trade swapped its games straight away, the ones in the post go
+-- back to plain accepted
+UPDATE TRADE SET CurrentStatus = 'accepted' WHERE CurrentStatus IN ('awaiting_shipment', 'shipped', 'received');
+ALTER TABLE TRADE
+  DROP COLUMN OwnerReceivedAt,
+  DROP COLUMN OwnerShippedAt,
+  DROP COLUMN OwnerTracking,
+  DROP COLUMN RequesterReceivedAt,
+  DROP COLUMN RequesterShippedAt,
+  DROP COLUMN RequesterTracking;
+ALTER TABLE TRADE MODIFY CurrentStatus VARCHAR(15) NOT NULL DEFAULT 'pending';
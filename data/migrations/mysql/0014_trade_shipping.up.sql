@@ -0,0 +1,11 @@
+-- awaiting_shipment doesn't fit the old 15 characters
+ALTER TABLE TRADE MODIFY CurrentStatus VARCHAR(20) NOT NULL DEFAULT 'pending';
+
+-- Shipping after acceptance, each party posts their own games
+ALTER TABLE TRADE
+  ADD COLUMN RequesterTracking VARCHAR(64) NULL AFTER UpdatedAt,
+  ADD COLUMN RequesterShippedAt DATETIME NULL AFTER RequesterTracking,
+  ADD COLUMN RequesterReceivedAt DATETIME NULL AFTER RequesterShippedAt,
+  ADD COLUMN OwnerTracking VARCHAR(64) NULL AFTER RequesterReceivedAt,
+  ADD COLUMN OwnerShippedAt DATETIME NULL AFTER OwnerTracking,
+  ADD COLUMN OwnerReceivedAt DATETIME NULL AFTER OwnerShippedAt;
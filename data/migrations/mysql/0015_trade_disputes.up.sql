@@ -0,0 +1,42 @@
+ALTER TABLE USERS ADD COLUMN Strikes INT NOT NULL DEFAULT 0 AFTER EmailVerified;
+
+-- Disputes on accepted trades, PreviousStatus is restored when a moderator closes one
+CREATE TABLE TRADE_DISPUTES(
+	DisputeID INT NOT NULL AUTO_INCREMENT,
+    OfferID INT NOT NULL,
+    OpenedBy INT NOT NULL,
+    Reason VARCHAR(500) NOT NULL,
+    Evidence VARCHAR(2000) NOT NULL DEFAULT '',
+    Status VARCHAR(10) NOT NULL DEFAULT 'open',
+    PreviousStatus VARCHAR(20) NOT NULL,
+    Resolution VARCHAR(10) NULL,
+    ResolutionNote VARCHAR(500) NULL,
+    PenalisedUserID INT NULL,
+    ResolvedBy INT NULL,
+    CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ResolvedAt DATETIME NULL,
+    
+	PRIMARY KEY (DisputeID),
+    INDEX idx_trade_disputes_offer (OfferID),
+    INDEX idx_trade_disputes_status (Status, DisputeID),
+    
+    CONSTRAINT fk_trade_disputes_offer
+		FOREIGN KEY (OfferID)
+        REFERENCES TRADE(OfferID)
+        ON DELETE CASCADE,
+        
+	CONSTRAINT fk_trade_disputes_opened_by
+		FOREIGN KEY (OpenedBy)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE,
+        
+	CONSTRAINT fk_trade_disputes_penalised
+		FOREIGN KEY (PenalisedUserID)
+        REFERENCES USERS(UserID)
+        ON DELETE SET NULL,
+        
+	CONSTRAINT fk_trade_disputes_resolved_by
+		FOREIGN KEY (ResolvedBy)
+        REFERENCES USERS(UserID)
+        ON DELETE SET NULL
+);
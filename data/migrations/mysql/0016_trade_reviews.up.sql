@@ -0,0 +1,29 @@
+-- One review per party per completed trade
+CREATE TABLE TRADE_REVIEWS(
+	ReviewID INT NOT NULL AUTO_INCREMENT,
+    OfferID INT NOT NULL,
+    ReviewerID INT NOT NULL,
+    RevieweeID INT NOT NULL,
+    Rating TINYINT NOT NULL CHECK (Rating BETWEEN 1 AND 5),
+    Comment VARCHAR(1000) NOT NULL DEFAULT '',
+    CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    
+	PRIMARY KEY (ReviewID),
+    UNIQUE (OfferID, ReviewerID),
+    INDEX idx_trade_reviews_reviewee (RevieweeID),
+    
+    CONSTRAINT fk_trade_reviews_offer
+		FOREIGN KEY (OfferID)
+        REFERENCES TRADE(OfferID)
+        ON DELETE CASCADE,
+        
+	CONSTRAINT fk_trade_reviews_reviewer
+		FOREIGN KEY (ReviewerID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE,
+        
+	CONSTRAINT fk_trade_reviews_reviewee
+		FOREIGN KEY (RevieweeID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE
+);
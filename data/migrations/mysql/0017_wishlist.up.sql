@@ -0,0 +1,16 @@
+-- Titles users are hunting for, Platform and Quality are optional filters
+CREATE TABLE WISHLIST (
+  WishID INT NOT NULL AUTO_INCREMENT,
+  UserID INT NOT NULL,
+  Title VARCHAR(40) NOT NULL,
+  Platform VARCHAR(40) NULL,
+  Quality VARCHAR(20) NULL,
+  CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (WishID),
+  INDEX idx_wishlist_user (UserID, WishID),
+
+  CONSTRAINT fk_wishlist_users
+    FOREIGN KEY (UserID)
+    REFERENCES USERS(UserID)
+    ON DELETE CASCADE
+);
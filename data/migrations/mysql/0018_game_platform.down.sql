@@ -0,0 +1 @@
+ALTER TABLE GAMES DROP COLUMN Platform;
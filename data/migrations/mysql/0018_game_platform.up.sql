@@ -0,0 +1,3 @@
+-- The platform a game runs on, wishlists match it exactly instead of searching the description.
+-- Listings made before this have it empty.
+ALTER TABLE GAMES ADD COLUMN Platform VARCHAR(40) NOT NULL DEFAULT '' AFTER Description;
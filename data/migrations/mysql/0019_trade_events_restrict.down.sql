@@ -0,0 +1,3 @@
+ALTER TABLE TRADE_EVENTS DROP FOREIGN KEY fk_trade_events_offer;
+ALTER TABLE TRADE_EVENTS
+  ADD CONSTRAINT fk_trade_events_offer FOREIGN KEY (OfferID) REFERENCES TRADE(OfferID) ON DELETE CASCADE;
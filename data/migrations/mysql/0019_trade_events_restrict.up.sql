@@ -0,0 +1,6 @@
+-- Deleting an offer used to take its audit trail with it. Nothing cascades into TRADE_EVENTS
+-- now, offers that can't go through are voided instead and account deletion clears the events
+-- it means to.
+ALTER TABLE TRADE_EVENTS DROP FOREIGN KEY fk_trade_events_offer;
+ALTER TABLE TRADE_EVENTS
+  ADD CONSTRAINT fk_trade_events_offer FOREIGN KEY (OfferID) REFERENCES TRADE(OfferID) ON DELETE RESTRICT;
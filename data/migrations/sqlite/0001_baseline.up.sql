@@ -0,0 +1,56 @@
+-- The same schema as mysql/0001_baseline.up.sql in SQLite terms. NOCASE stands in for MySQL's
+-- case-insensitive collation on the columns the store looks up or sorts by text.
+
+CREATE TABLE USERS (
+  UserID INTEGER PRIMARY KEY AUTOINCREMENT,
+  Name VARCHAR(100) NOT NULL COLLATE NOCASE,
+  Email VARCHAR(150) NOT NULL COLLATE NOCASE,
+  PasswordHash VARCHAR(255) NOT NULL,
+  StreetAddress VARCHAR(255) NOT NULL,
+  UNIQUE (Email)
+);
+
+CREATE TABLE GAMES (
+  GameID INTEGER PRIMARY KEY AUTOINCREMENT,
+  OwnerUserID INT NOT NULL,
+  Title VARCHAR(40) NOT NULL COLLATE NOCASE,
+  Publisher VARCHAR(40) NOT NULL COLLATE NOCASE,
+  Description VARCHAR(100) NOT NULL,
+  Year INT NOT NULL,
+  Quality VARCHAR(20) NOT NULL COLLATE NOCASE,
+  PreviousOwners INT NULL,
+
+  CONSTRAINT fk_games_users
+    FOREIGN KEY (OwnerUserID)
+    REFERENCES USERS(UserID)
+    ON DELETE CASCADE
+);
+
+CREATE TABLE TRADE(
+	OfferID INTEGER PRIMARY KEY AUTOINCREMENT,
+    RequesterID INT NOT NULL,
+    OwnerUserID INT NOT NULL,
+    GameRequestedID INT NOT NULL,
+    GameOfferedID INT NOT NULL,
+    CurrentStatus VARCHAR(15) NOT NULL DEFAULT 'pending',
+    
+    CONSTRAINT fk_trade_requester
+		FOREIGN KEY (RequesterID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE,
+        
+    CONSTRAINT fk_trade_owner
+		FOREIGN KEY (OwnerUserID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE,
+	
+    CONSTRAINT fk_trade_gamerequested
+		FOREIGN KEY (GameRequestedID)
+        REFERENCES GAMES(GameID)
+        ON DELETE CASCADE,
+        
+	CONSTRAINT fk_trade_gameoffer
+		FOREIGN KEY (GameOfferedID)
+        REFERENCES GAMES(GameID)
+        ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+DROP INDEX idx_games_owner;
+DROP INDEX idx_games_year;
+DROP INDEX idx_games_title;
@@ -0,0 +1,4 @@
+-- Catalog search pages by (sort column, GameID), these keep each sort an index range scan
+CREATE INDEX idx_games_title ON GAMES (Title, GameID);
+CREATE INDEX idx_games_year ON GAMES (Year, GameID);
+CREATE INDEX idx_games_owner ON GAMES (OwnerUserID, GameID);
@@ -0,0 +1 @@
+ALTER TABLE USERS ADD COLUMN Role VARCHAR(20) NOT NULL DEFAULT 'user';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS REFRESH_TOKENS;
+ALTER TABLE USERS DROP COLUMN TokenVersion;
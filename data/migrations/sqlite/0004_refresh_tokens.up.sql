@@ -0,0 +1,18 @@
+-- Bumping TokenVersion revokes every access token the user holds
+ALTER TABLE USERS ADD COLUMN TokenVersion INT NOT NULL DEFAULT 0;
+
+CREATE TABLE REFRESH_TOKENS (
+  TokenID INTEGER PRIMARY KEY AUTOINCREMENT,
+  UserID INT NOT NULL,
+  TokenHash CHAR(64) NOT NULL,
+  ExpiresAt DATETIME NOT NULL,
+  CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  RevokedAt DATETIME NULL,
+  ReplacedByID INT NULL,
+  UNIQUE (TokenHash),
+
+  CONSTRAINT fk_refresh_users
+    FOREIGN KEY (UserID)
+    REFERENCES USERS(UserID)
+    ON DELETE CASCADE
+);
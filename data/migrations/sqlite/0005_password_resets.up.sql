@@ -0,0 +1,14 @@
+CREATE TABLE PASSWORD_RESETS (
+  ResetID INTEGER PRIMARY KEY AUTOINCREMENT,
+  UserID INT NOT NULL,
+  TokenHash CHAR(64) NOT NULL,
+  ExpiresAt DATETIME NOT NULL,
+  CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UsedAt DATETIME NULL,
+  UNIQUE (TokenHash),
+
+  CONSTRAINT fk_resets_users
+    FOREIGN KEY (UserID)
+    REFERENCES USERS(UserID)
+    ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+ALTER TABLE USERS ADD COLUMN EmailVerified BOOLEAN NOT NULL DEFAULT FALSE;
+-- Only accounts made from now on start unverified, the ones already here keep logging in
+UPDATE USERS SET EmailVerified = TRUE;
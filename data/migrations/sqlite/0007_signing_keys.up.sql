@@ -0,0 +1,10 @@
+-- Private keys for the auth service JWTs, the public halves are served from /.well-known/jwks.json
+CREATE TABLE SIGNING_KEYS (
+  KeyID VARCHAR(64) NOT NULL,
+  Algorithm VARCHAR(10) NOT NULL,
+  PrivateKey TEXT NOT NULL,
+  CreatedAt DATETIME NOT NULL,
+  RetiredAt DATETIME NULL,
+  ExpiresAt DATETIME NULL,
+  PRIMARY KEY (KeyID)
+);
@@ -0,0 +1,41 @@
+-- The old columns hold one game a side. Offers keep the lowest GameID of each side and the ones
+-- missing a side can't be written down at all, so they are dropped.
+CREATE TABLE TRADE_old(
+	OfferID INTEGER PRIMARY KEY AUTOINCREMENT,
+    RequesterID INT NOT NULL,
+    OwnerUserID INT NOT NULL,
+    GameRequestedID INT NOT NULL,
+    GameOfferedID INT NOT NULL,
+    CurrentStatus VARCHAR(15) NOT NULL DEFAULT 'pending',
+    
+    CONSTRAINT fk_trade_requester
+		FOREIGN KEY (RequesterID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE,
+        
+    CONSTRAINT fk_trade_owner
+		FOREIGN KEY (OwnerUserID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE,
+	
+    CONSTRAINT fk_trade_gamerequested
+		FOREIGN KEY (GameRequestedID)
+        REFERENCES GAMES(GameID)
+        ON DELETE CASCADE,
+        
+	CONSTRAINT fk_trade_gameoffer
+		FOREIGN KEY (GameOfferedID)
+        REFERENCES GAMES(GameID)
+        ON DELETE CASCADE
+);
+INSERT INTO TRADE_old (OfferID, RequesterID, OwnerUserID, GameRequestedID, GameOfferedID, CurrentStatus)
+SELECT * FROM (
+  SELECT OfferID, RequesterID, OwnerUserID,
+    (SELECT MIN(GameID) FROM TRADE_ITEMS i WHERE i.OfferID = TRADE.OfferID AND i.Side = 'requested') AS GameRequestedID,
+    (SELECT MIN(GameID) FROM TRADE_ITEMS i WHERE i.OfferID = TRADE.OfferID AND i.Side = 'offered') AS GameOfferedID,
+    CurrentStatus
+  FROM TRADE
+) WHERE GameRequestedID IS NOT NULL AND GameOfferedID IS NOT NULL;
+DROP TABLE TRADE_ITEMS;
+DROP TABLE TRADE;
+ALTER TABLE TRADE_old RENAME TO TRADE;
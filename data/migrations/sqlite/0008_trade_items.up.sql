@@ -0,0 +1,47 @@
+-- One row per game in an offer, Side says which party is giving it up
+CREATE TABLE TRADE_ITEMS(
+	OfferID INT NOT NULL,
+    GameID INT NOT NULL,
+    Side VARCHAR(10) NOT NULL,
+    
+	PRIMARY KEY (OfferID, GameID),
+    
+    CONSTRAINT fk_trade_items_offer
+		FOREIGN KEY (OfferID)
+        REFERENCES TRADE(OfferID)
+        ON DELETE CASCADE,
+        
+	CONSTRAINT fk_trade_items_game
+		FOREIGN KEY (GameID)
+        REFERENCES GAMES(GameID)
+        ON DELETE CASCADE
+);
+CREATE INDEX idx_trade_items_game ON TRADE_ITEMS (GameID);
+
+-- Every existing offer becomes one game a side
+INSERT INTO TRADE_ITEMS (OfferID, GameID, Side)
+SELECT OfferID, GameRequestedID, 'requested' FROM TRADE;
+INSERT INTO TRADE_ITEMS (OfferID, GameID, Side)
+SELECT OfferID, GameOfferedID, 'offered' FROM TRADE WHERE GameOfferedID <> GameRequestedID;
+
+-- SQLite can't drop a column with a foreign key on it, TRADE is rebuilt without them
+CREATE TABLE TRADE_new(
+	OfferID INTEGER PRIMARY KEY AUTOINCREMENT,
+    RequesterID INT NOT NULL,
+    OwnerUserID INT NOT NULL,
+    CurrentStatus VARCHAR(15) NOT NULL DEFAULT 'pending',
+    
+    CONSTRAINT fk_trade_requester
+		FOREIGN KEY (RequesterID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE,
+        
+    CONSTRAINT fk_trade_owner
+		FOREIGN KEY (OwnerUserID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE
+);
+INSERT INTO TRADE_new (OfferID, RequesterID, OwnerUserID, CurrentStatus)
+SELECT OfferID, RequesterID, OwnerUserID, CurrentStatus FROM TRADE;
+DROP TABLE TRADE;
+ALTER TABLE TRADE_new RENAME TO TRADE;
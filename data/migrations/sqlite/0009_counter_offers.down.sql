@@ -0,0 +1,21 @@
+-- SQLite can't drop a column with a foreign key on it, TRADE is rebuilt without it
+CREATE TABLE TRADE_old(
+	OfferID INTEGER PRIMARY KEY AUTOINCREMENT,
+    RequesterID INT NOT NULL,
+    OwnerUserID INT NOT NULL,
+    CurrentStatus VARCHAR(15) NOT NULL DEFAULT 'pending',
+    
+    CONSTRAINT fk_trade_requester
+		FOREIGN KEY (RequesterID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE,
+        
+    CONSTRAINT fk_trade_owner
+		FOREIGN KEY (OwnerUserID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE
+);
+INSERT INTO TRADE_old (OfferID, RequesterID, OwnerUserID, CurrentStatus)
+SELECT OfferID, RequesterID, OwnerUserID, CurrentStatus FROM TRADE;
+DROP TABLE TRADE;
+ALTER TABLE TRADE_old RENAME TO TRADE;
@@ -0,0 +1,3 @@
+-- The offer this one counters, if any
+ALTER TABLE TRADE ADD COLUMN ParentOfferID INT NULL CONSTRAINT fk_trade_parent REFERENCES TRADE(OfferID) ON DELETE SET NULL;
+CREATE INDEX idx_trade_parent ON TRADE (ParentOfferID);
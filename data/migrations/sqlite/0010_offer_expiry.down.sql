@@ -0,0 +1,2 @@
+DROP INDEX idx_trade_expiry;
+ALTER TABLE TRADE DROP COLUMN ExpiresAt;
@@ -0,0 +1,3 @@
+-- Offers made before this never expire, the sweeper skips a NULL ExpiresAt
+ALTER TABLE TRADE ADD COLUMN ExpiresAt DATETIME NULL;
+CREATE INDEX idx_trade_expiry ON TRADE (CurrentStatus, ExpiresAt);
@@ -0,0 +1,18 @@
+CREATE TABLE TRADE_MESSAGES(
+	MessageID INTEGER PRIMARY KEY AUTOINCREMENT,
+    OfferID INT NOT NULL,
+    SenderID INT NOT NULL,
+    Body VARCHAR(2000) NOT NULL,
+    CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    
+    CONSTRAINT fk_trade_messages_offer
+		FOREIGN KEY (OfferID)
+        REFERENCES TRADE(OfferID)
+        ON DELETE CASCADE,
+        
+	CONSTRAINT fk_trade_messages_sender
+		FOREIGN KEY (SenderID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE
+);
+CREATE INDEX idx_trade_messages_offer ON TRADE_MESSAGES (OfferID, MessageID);
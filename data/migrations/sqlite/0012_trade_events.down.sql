@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS TRADE_EVENTS;
+ALTER TABLE TRADE DROP COLUMN UpdatedAt;
+ALTER TABLE TRADE DROP COLUMN CreatedAt;
@@ -0,0 +1,57 @@
+-- SQLite won't add a column defaulting to CURRENT_TIMESTAMP, TRADE is rebuilt with them. Offers
+-- already here get the time of the migration, there is no record of when they were made.
+CREATE TABLE TRADE_new(
+	OfferID INTEGER PRIMARY KEY AUTOINCREMENT,
+    RequesterID INT NOT NULL,
+    OwnerUserID INT NOT NULL,
+    CurrentStatus VARCHAR(15) NOT NULL DEFAULT 'pending',
+    ParentOfferID INT NULL,
+    ExpiresAt DATETIME NULL,
+    CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    
+    CONSTRAINT fk_trade_requester
+		FOREIGN KEY (RequesterID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE,
+        
+    CONSTRAINT fk_trade_owner
+		FOREIGN KEY (OwnerUserID)
+        REFERENCES USERS(UserID)
+        ON DELETE CASCADE,
+        
+	-- The offer this one counters, if any
+    CONSTRAINT fk_trade_parent
+		FOREIGN KEY (ParentOfferID)
+        REFERENCES TRADE(OfferID)
+        ON DELETE SET NULL
+);
+INSERT INTO TRADE_new (OfferID, RequesterID, OwnerUserID, CurrentStatus, ParentOfferID, ExpiresAt)
+SELECT OfferID, RequesterID, OwnerUserID, CurrentStatus, ParentOfferID, ExpiresAt FROM TRADE;
+DROP TABLE TRADE;
+ALTER TABLE TRADE_new RENAME TO TRADE;
+CREATE INDEX idx_trade_parent ON TRADE (ParentOfferID);
+CREATE INDEX idx_trade_expiry ON TRADE (CurrentStatus, ExpiresAt);
+
+-- Append only, every status change on an offer adds a row. A NULL ActorID means the system did it
+CREATE TABLE TRADE_EVENTS(
+	EventID INTEGER PRIMARY KEY AUTOINCREMENT,
+    OfferID INT NOT NULL,
+    ActorID INT NULL,
+    EventType VARCHAR(20) NOT NULL,
+    FromStatus VARCHAR(20) NULL,
+    ToStatus VARCHAR(20) NOT NULL,
+    Note VARCHAR(500) NULL,
+    CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    
+    CONSTRAINT fk_trade_events_offer
+		FOREIGN KEY (OfferID)
+        REFERENCES TRADE(OfferID)
+        ON DELETE CASCADE,
+        
+	CONSTRAINT fk_trade_events_actor
+		FOREIGN KEY (ActorID)
+        REFERENCES USERS(UserID)
+        ON DELETE SET NULL
+);
+CREATE INDEX idx_trade_events_offer ON TRADE_EVENTS (OfferID, EventID);
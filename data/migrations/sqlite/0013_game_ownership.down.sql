@@ -0,0 +1,25 @@
+DROP TABLE IF EXISTS GAME_OWNERSHIP;
+
+-- SQLite can't make a column nullable in place, GAMES is rebuilt
+CREATE TABLE GAMES_old (
+  GameID INTEGER PRIMARY KEY AUTOINCREMENT,
+  OwnerUserID INT NOT NULL,
+  Title VARCHAR(40) NOT NULL COLLATE NOCASE,
+  Publisher VARCHAR(40) NOT NULL COLLATE NOCASE,
+  Description VARCHAR(100) NOT NULL,
+  Year INT NOT NULL,
+  Quality VARCHAR(20) NOT NULL COLLATE NOCASE,
+  PreviousOwners INT NULL,
+
+  CONSTRAINT fk_games_users
+    FOREIGN KEY (OwnerUserID)
+    REFERENCES USERS(UserID)
+    ON DELETE CASCADE
+);
+INSERT INTO GAMES_old (GameID, OwnerUserID, Title, Publisher, Description, Year, Quality, PreviousOwners)
+SELECT GameID, OwnerUserID, Title, Publisher, Description, Year, Quality, PreviousOwners FROM GAMES;
+DROP TABLE GAMES;
+ALTER TABLE GAMES_old RENAME TO GAMES;
+CREATE INDEX idx_games_title ON GAMES (Title, GameID);
+CREATE INDEX idx_games_year ON GAMES (Year, GameID);
+CREATE INDEX idx_games_owner ON GAMES (OwnerUserID, GameID);
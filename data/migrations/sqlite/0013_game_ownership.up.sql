@@ -0,0 +1,55 @@
+-- Nothing ever wrote PreviousOwners, the games already listed have had none. SQLite can't make a
+-- column NOT NULL in place, GAMES is rebuilt.
+UPDATE GAMES SET PreviousOwners = 0 WHERE PreviousOwners IS NULL;
+CREATE TABLE GAMES_new (
+  GameID INTEGER PRIMARY KEY AUTOINCREMENT,
+  OwnerUserID INT NOT NULL,
+  Title VARCHAR(40) NOT NULL COLLATE NOCASE,
+  Publisher VARCHAR(40) NOT NULL COLLATE NOCASE,
+  Description VARCHAR(100) NOT NULL,
+  Year INT NOT NULL,
+  Quality VARCHAR(20) NOT NULL COLLATE NOCASE,
+  PreviousOwners INT NOT NULL DEFAULT 0,
+
+  CONSTRAINT fk_games_users
+    FOREIGN KEY (OwnerUserID)
+    REFERENCES USERS(UserID)
+    ON DELETE CASCADE
+);
+INSERT INTO GAMES_new (GameID, OwnerUserID, Title, Publisher, Description, Year, Quality, PreviousOwners)
+SELECT GameID, OwnerUserID, Title, Publisher, Description, Year, Quality, PreviousOwners FROM GAMES;
+DROP TABLE GAMES;
+ALTER TABLE GAMES_new RENAME TO GAMES;
+CREATE INDEX idx_games_title ON GAMES (Title, GameID);
+CREATE INDEX idx_games_year ON GAMES (Year, GameID);
+CREATE INDEX idx_games_owner ON GAMES (OwnerUserID, GameID);
+
+-- Chain of custody for each game, the first row is the listing (FromUserID NULL)
+CREATE TABLE GAME_OWNERSHIP (
+  TransferID INTEGER PRIMARY KEY AUTOINCREMENT,
+  GameID INT NOT NULL,
+  FromUserID INT NULL,
+  ToUserID INT NULL,
+  OfferID INT NULL,
+  TransferredAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  CONSTRAINT fk_game_ownership_game
+    FOREIGN KEY (GameID)
+    REFERENCES GAMES(GameID)
+    ON DELETE CASCADE,
+
+  CONSTRAINT fk_game_ownership_from
+    FOREIGN KEY (FromUserID)
+    REFERENCES USERS(UserID)
+    ON DELETE SET NULL,
+
+  CONSTRAINT fk_game_ownership_to
+    FOREIGN KEY (ToUserID)
+    REFERENCES USERS(UserID)
+    ON DELETE SET NULL
+);
+CREATE INDEX idx_game_ownership_game ON GAME_OWNERSHIP (GameID, TransferID);
+
+-- Start the provenance of the games already listed with their current owner
+INSERT INTO GAME_OWNERSHIP (GameID, FromUserID, ToUserID)
+SELECT GameID, NULL, OwnerUserID FROM GAMES ORDER BY GameID;
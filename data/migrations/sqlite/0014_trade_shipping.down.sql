@@ -0,0 +1,9 @@
+-- Before shipping an accepted trade swapped its games straight away, the ones in the post go
+-- back to plain accepted
+UPDATE TRADE SET CurrentStatus = 'accepted' WHERE CurrentStatus IN ('awaiting_shipment', 'shipped', 'received');
+ALTER TABLE TRADE DROP COLUMN OwnerReceivedAt;
+ALTER TABLE TRADE DROP COLUMN OwnerShippedAt;
+ALTER TABLE TRADE DROP COLUMN OwnerTracking;
+ALTER TABLE TRADE DROP COLUMN RequesterReceivedAt;
+ALTER TABLE TRADE DROP COLUMN RequesterShippedAt;
+ALTER TABLE TRADE DROP COLUMN RequesterTracking;
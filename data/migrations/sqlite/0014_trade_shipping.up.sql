@@ -0,0 +1,8 @@
+-- Shipping after acceptance, each party posts their own games. SQLite doesn't enforce the
+-- VARCHAR length, awaiting_shipment fits CurrentStatus as it is.
+ALTER TABLE TRADE ADD COLUMN RequesterTracking VARCHAR(64) NULL;
+ALTER TABLE TRADE ADD COLUMN RequesterShippedAt DATETIME NULL;
+ALTER TABLE TRADE ADD COLUMN RequesterReceivedAt DATETIME NULL;
+ALTER TABLE TRADE ADD COLUMN OwnerTracking VARCHAR(64) NULL;
+ALTER TABLE TRADE ADD COLUMN OwnerShippedAt DATETIME NULL;
+ALTER TABLE TRADE ADD COLUMN OwnerReceivedAt DATETIME NULL;
@@ -0,0 +1,6 @@
+-- A disputed or reverted trade goes back to where it was when the dispute opened
+UPDATE TRADE SET CurrentStatus = (
+  SELECT d.PreviousStatus FROM TRADE_DISPUTES d WHERE d.OfferID = TRADE.OfferID ORDER BY d.DisputeID DESC LIMIT 1
+) WHERE CurrentStatus IN ('disputed', 'reverted');
+DROP TABLE IF EXISTS TRADE_DISPUTES;
+ALTER TABLE USERS DROP COLUMN Strikes;
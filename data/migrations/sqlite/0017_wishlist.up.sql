@@ -0,0 +1,15 @@
+-- Titles users are hunting for, Platform and Quality are optional filters
+CREATE TABLE WISHLIST (
+  WishID INTEGER PRIMARY KEY AUTOINCREMENT,
+  UserID INT NOT NULL,
+  Title VARCHAR(40) NOT NULL COLLATE NOCASE,
+  Platform VARCHAR(40) NULL COLLATE NOCASE,
+  Quality VARCHAR(20) NULL COLLATE NOCASE,
+  CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  CONSTRAINT fk_wishlist_users
+    FOREIGN KEY (UserID)
+    REFERENCES USERS(UserID)
+    ON DELETE CASCADE
+);
+CREATE INDEX idx_wishlist_user ON WISHLIST (UserID, WishID);
@@ -0,0 +1,28 @@
+-- Deleting an offer used to take its audit trail with it. Nothing cascades into TRADE_EVENTS
+-- now, offers that can't go through are voided instead and account deletion clears the events
+-- it means to. SQLite can't change a foreign key in place, TRADE_EVENTS is rebuilt.
+CREATE TABLE TRADE_EVENTS_new(
+	EventID INTEGER PRIMARY KEY AUTOINCREMENT,
+    OfferID INT NOT NULL,
+    ActorID INT NULL,
+    EventType VARCHAR(20) NOT NULL,
+    FromStatus VARCHAR(20) NULL,
+    ToStatus VARCHAR(20) NOT NULL,
+    Note VARCHAR(500) NULL,
+    CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    
+    CONSTRAINT fk_trade_events_offer
+		FOREIGN KEY (OfferID)
+        REFERENCES TRADE(OfferID)
+        ON DELETE RESTRICT,
+        
+	CONSTRAINT fk_trade_events_actor
+		FOREIGN KEY (ActorID)
+        REFERENCES USERS(UserID)
+        ON DELETE SET NULL
+);
+INSERT INTO TRADE_EVENTS_new (EventID, OfferID, ActorID, EventType, FromStatus, ToStatus, Note, CreatedAt)
+SELECT EventID, OfferID, ActorID, EventType, FromStatus, ToStatus, Note, CreatedAt FROM TRADE_EVENTS;
+DROP TABLE TRADE_EVENTS;
+ALTER TABLE TRADE_EVENTS_new RENAME TO TRADE_EVENTS;
+CREATE INDEX idx_trade_events_offer ON TRADE_EVENTS (OfferID, EventID);
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		ok       bool
+	}{
+		{"empty", "", false},
+		{"too short", "abc1234", false},
+		{"letters only", "penguinpenguin", false},
+		{"digits only", "1234567890", false},
+		{"letters and digits", "penguin123", true},
+		{"eight runes with a multibyte letter", "pingüin1", true},
+		{"seven runes of multibyte", "ñññññ12", false},
+		{"at the bcrypt limit", strings.Repeat("a", MaxPasswordBytes-1) + "1", true},
+		{"past the bcrypt limit", strings.Repeat("a", MaxPasswordBytes) + "1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := CheckPasswordPolicy(tt.password)
+			if (msg == "") != tt.ok {
+				t.Fatalf("CheckPasswordPolicy(%q) = %q, want ok=%v", tt.password, msg, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	hashed, err := hashPassword("penguin123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashed == "penguin123" {
+		t.Fatal("password stored in plain text")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte("penguin123")); err != nil {
+		t.Fatalf("hash doesnt match its password: %v", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte("penguin124")); err == nil {
+		t.Fatal("hash matched the wrong password")
+	}
+
+	again, err := hashPassword("penguin123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == hashed {
+		t.Fatal("two hashes of the same password are identical, salt missing")
+	}
+}
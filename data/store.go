@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// UserStore is everything about accounts: profiles, passwords, sessions and wishlists.
+type UserStore interface {
+	CreateUser(ctx context.Context, user User) (int, error)
+	GetUser(ctx context.Context, userID int) (User, error)
+	ListUsers(ctx context.Context, role string, afterID int, limit int) ([]User, error)
+	GetUserRole(ctx context.Context, userID int) (string, error)
+	UpdateUserRole(ctx context.Context, userID int, role string) error
+	UpdateUsername(ctx context.Context, userID int, username string) error
+	UpdateStreetAddress(ctx context.Context, userID int, streetAddress string) error
+	UpdateUserPassword(ctx context.Context, userID int, password string) error
+	CheckUserPassword(ctx context.Context, userID int, password string) (bool, error)
+	DeleteUserByID(ctx context.Context, userID int) error
+	GetEmailWithID(ctx context.Context, id int) string
+	GetUserIDByEmail(ctx context.Context, email string) (int, error)
+	// VerifyUser returns the user's ID when the password matches, -1 otherwise.
+	VerifyUser(ctx context.Context, email string, password string) int
+	IsEmailVerified(ctx context.Context, userID int) (bool, error)
+	MarkEmailVerified(ctx context.Context, userID int, email string) error
+
+	CreatePasswordReset(ctx context.Context, userID int, tokenHash string, expiresAt, notBefore time.Time) error
+	ResetPassword(ctx context.Context, tokenHash string, password string) (int, error)
+	GetTokenVersion(ctx context.Context, userID int) (int, error)
+	RevokeUserSessions(ctx context.Context, userID int) error
+	CreateRefreshToken(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error
+	RotateRefreshToken(ctx context.Context, oldHash string, newHash string, expiresAt time.Time) (int, error)
+	RevokeRefreshToken(ctx context.Context, tokenHash string) (int, error)
+
+	AddWishlistItem(ctx context.Context, userID int, item WishlistRequest) (WishlistItem, error)
+	GetWishlist(ctx context.Context, userID int) ([]WishlistItem, error)
+	DeleteWishlistItem(ctx context.Context, userID int, wishID int) error
+}
+
+// GameStore is the game catalog, its ownership history and matching it against wishlists.
+type GameStore interface {
+	CreateGame(ctx context.Context, game Game, userID int) (int, error)
+	GetGameBYID(ctx context.Context, gameID int) (Game, error)
+	GetOwnedGameBYID(ctx context.Context, gameID int) (OwnedGame, error)
+	SearchGames(ctx context.Context, search GameSearch) (GamePage, error)
+	GetGameProvenance(ctx context.Context, gameID int) ([]GameTransfer, error)
+	UpdateFullGame(ctx context.Context, gameID int, game GamePutRequest) error
+	UpdateGameTitle(ctx context.Context, gameID int, title string) error
+	UpdateGameCondition(ctx context.Context, gameID int, condition string) error
+	UpdateGameDescription(ctx context.Context, gameID int, description string) error
+	UpdateGamePlatform(ctx context.Context, gameID int, platform string) error
+	DeleteGameByID(ctx context.Context, gameID int) error
+
+	GetWishlistMatches(ctx context.Context, userID int, afterID int, limit int) ([]OwnedGame, error)
+	GetWishersForGame(ctx context.Context, gameID int) ([]WishlistItem, error)
+}
+
+// TradeStore is offers and everything that hangs off them: history, messages, shipping,
+// disputes and reviews.
+type TradeStore interface {
+	CreateTradeOffer(ctx context.Context, offer TradeOffer) (int, error)
+	CounterTradeOffer(ctx context.Context, parentID int, counter TradeOffer) (int, error)
+	GetTradeOfferByID(ctx context.Context, offerID int) (TradeOffer, error)
+	GetTradeThread(ctx context.Context, offerID int) ([]TradeOffer, error)
+	GetTradeHistory(ctx context.Context, offerID int) ([]TradeEvent, error)
+	GetIncomingTradeOffers(ctx context.Context, ownerID int) ([]TradeOffer, error)
+	GetOutgoingTradeOffers(ctx context.Context, requesterID int) ([]TradeOffer, error)
+	UpdateTradeOfferStatus(ctx context.Context, offerID int, status string, actorID int, note string) error
+	AcceptTradeOffer(ctx context.Context, offerID int, actorID int) ([]TradeOffer, error)
+	ExpireTradeOffers(ctx context.Context, now time.Time) ([]TradeOffer, error)
+	GamesInActiveTrade(ctx context.Context, gameIDs []int) ([]int, error)
+
+	MarkTradeShipped(ctx context.Context, offerID int, userID int, tracking string) (TradeOffer, error)
+	ConfirmTradeReceipt(ctx context.Context, offerID int, userID int) (TradeOffer, error)
+
+	CreateTradeMessage(ctx context.Context, offerID int, senderID int, body string) (TradeMessage, error)
+	ListTradeMessages(ctx context.Context, offerID int, afterID int, limit int) ([]TradeMessage, error)
+
+	OpenTradeDispute(ctx context.Context, offerID int, userID int, reason string, evidence string) (TradeDispute, error)
+	GetTradeDisputes(ctx context.Context, offerID int) ([]TradeDispute, error)
+	ListDisputes(ctx context.Context, status string, afterID int, limit int) ([]TradeDispute, error)
+	GetTradeDispute(ctx context.Context, disputeID int) (TradeDispute, error)
+	ResolveTradeDispute(ctx context.Context, disputeID int, moderatorID int, ruling TradeDisputeResolution) (TradeDispute, TradeOffer, error)
+
+	CreateTradeReview(ctx context.Context, offerID int, reviewerID int, rating int, comment string) (TradeReview, error)
+	GetTradeReviews(ctx context.Context, offerID int) ([]TradeReview, error)
+	GetReputations(ctx context.Context, userIDs []int) (map[int]Reputation, error)
+	GetUserReputation(ctx context.Context, userID int) (Reputation, error)
+}
+
+// KeyStore holds the auth service's JWT signing keys.
+type KeyStore interface {
+	GetSigningKeys(ctx context.Context) ([]SigningKey, error)
+	RotateSigningKey(ctx context.Context, key SigningKey, notBefore time.Time, grace time.Duration) (bool, error)
+}
+
+// Store is a whole backend, SQLStore and MemoryStore both are one.
+type Store interface {
+	UserStore
+	GameStore
+	TradeStore
+	KeyStore
+	Ping(ctx context.Context) error
+}
+
+var (
+	_ Store = (*SQLStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// tokenStores opens each store the auth service can run on.
+var tokenStores = map[string]func(t *testing.T) Store{
+	"memory": func(t *testing.T) Store { return NewMemoryStore() },
+	"sqlite": func(t *testing.T) Store {
+		store, _ := openSQLite(t, 5*time.Second)
+		return store
+	},
+}
+
+// TestRotateRefreshToken runs the same rotation through both stores, the auth tests only cover the memory one.
+func TestRotateRefreshToken(t *testing.T) {
+	for name, open := range tokenStores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := open(t)
+			userID, _, _ := seed(t, store)
+			expires := time.Now().Add(time.Hour)
+
+			if err := store.CreateRefreshToken(ctx, userID, HashToken("first"), expires); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.CreateRefreshToken(ctx, userID, HashToken("other"), expires); err != nil {
+				t.Fatal(err)
+			}
+			version, err := store.GetTokenVersion(ctx, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := store.RotateRefreshToken(ctx, HashToken("first"), HashToken("second"), expires)
+			if err != nil || got != userID {
+				t.Fatalf("rotate: got user %d, %v", got, err)
+			}
+			if got, err := store.RotateRefreshToken(ctx, HashToken("second"), HashToken("third"), expires); err != nil || got != userID {
+				t.Fatalf("rotate the new token: got user %d, %v", got, err)
+			}
+			if _, err := store.RotateRefreshToken(ctx, HashToken("never-issued"), HashToken("fourth"), expires); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("unknown token: %v", err)
+			}
+			if after, err := store.GetTokenVersion(ctx, userID); err != nil || after != version {
+				t.Fatalf("token version moved to %d on a plain rotate, %v", after, err)
+			}
+
+			// Replaying a rotated token revokes everything the user has
+			if _, err := store.RotateRefreshToken(ctx, HashToken("first"), HashToken("fourth"), expires); !errors.Is(err, ErrTokenReused) {
+				t.Fatalf("replayed token: %v", err)
+			}
+			if after, err := store.GetTokenVersion(ctx, userID); err != nil || after != version+1 {
+				t.Fatalf("token version after reuse: got %d, want %d, %v", after, version+1, err)
+			}
+			for _, token := range []string{"third", "other"} {
+				if _, err := store.RotateRefreshToken(ctx, HashToken(token), HashToken("fifth"), expires); !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("%s token after reuse: %v", token, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	for name, open := range tokenStores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := open(t)
+			userID, _, _ := seed(t, store)
+			expires := time.Now().Add(time.Hour)
+
+			if err := store.CreateRefreshToken(ctx, userID, HashToken("first"), expires); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.CreateRefreshToken(ctx, userID, HashToken("expired"), time.Now().Add(-time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			version, err := store.GetTokenVersion(ctx, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				if got, err := store.RevokeRefreshToken(ctx, HashToken("first")); err != nil || got != userID {
+					t.Fatalf("revoke %d: got user %d, %v", i+1, got, err)
+				}
+			}
+			if _, err := store.RevokeRefreshToken(ctx, HashToken("never-issued")); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("unknown token: %v", err)
+			}
+
+			// A logged out token is refused, but it isnt reuse so the user keeps their other sessions
+			if _, err := store.RotateRefreshToken(ctx, HashToken("first"), HashToken("second"), expires); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("rotate after logout: %v", err)
+			}
+			if _, err := store.RotateRefreshToken(ctx, HashToken("expired"), HashToken("second"), expires); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("rotate an expired token: %v", err)
+			}
+			if after, err := store.GetTokenVersion(ctx, userID); err != nil || after != version {
+				t.Fatalf("token version moved to %d, %v", after, err)
+			}
+		})
+	}
+}
@@ -152,6 +152,30 @@ func setStartingValues() {
 	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Game Offer Rejected", "type": "offers"}).Add(0)
 	ef.FailedEmails.With(ProMetrics.Labels{"subject": "Game Offer Accepted", "type": "offers"}).Add(0)
 	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Game Offer Accepted", "type": "offers"}).Add(0)
+	ef.FailedEmails.With(ProMetrics.Labels{"subject": "Password Reset Requested", "type": "users"}).Add(0)
+	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Password Reset Requested", "type": "users"}).Add(0)
+	ef.FailedEmails.With(ProMetrics.Labels{"subject": "Verify Your Email", "type": "users"}).Add(0)
+	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Verify Your Email", "type": "users"}).Add(0)
+	ef.FailedEmails.With(ProMetrics.Labels{"subject": "Account Locked", "type": "users"}).Add(0)
+	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Account Locked", "type": "users"}).Add(0)
+	ef.FailedEmails.With(ProMetrics.Labels{"subject": "Game Offer Countered", "type": "offers"}).Add(0)
+	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Game Offer Countered", "type": "offers"}).Add(0)
+	ef.FailedEmails.With(ProMetrics.Labels{"subject": "Game Offer Void", "type": "offers"}).Add(0)
+	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Game Offer Void", "type": "offers"}).Add(0)
+	ef.FailedEmails.With(ProMetrics.Labels{"subject": "Game Offer Expired", "type": "offers"}).Add(0)
+	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Game Offer Expired", "type": "offers"}).Add(0)
+	ef.FailedEmails.With(ProMetrics.Labels{"subject": "New Offer Message", "type": "offers"}).Add(0)
+	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "New Offer Message", "type": "offers"}).Add(0)
+	ef.FailedEmails.With(ProMetrics.Labels{"subject": "Game Offer Shipped", "type": "offers"}).Add(0)
+	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Game Offer Shipped", "type": "offers"}).Add(0)
+	ef.FailedEmails.With(ProMetrics.Labels{"subject": "Game Offer Completed", "type": "offers"}).Add(0)
+	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Game Offer Completed", "type": "offers"}).Add(0)
+	ef.FailedEmails.With(ProMetrics.Labels{"subject": "Trade Dispute Opened", "type": "offers"}).Add(0)
+	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Trade Dispute Opened", "type": "offers"}).Add(0)
+	ef.FailedEmails.With(ProMetrics.Labels{"subject": "Trade Dispute Resolved", "type": "offers"}).Add(0)
+	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Trade Dispute Resolved", "type": "offers"}).Add(0)
+	ef.FailedEmails.With(ProMetrics.Labels{"subject": "Wishlist Match", "type": "users"}).Add(0)
+	es.SuccessfulEmails.With(ProMetrics.Labels{"subject": "Wishlist Match", "type": "users"}).Add(0)
 }
 
 func main() {